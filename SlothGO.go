@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -13,12 +17,12 @@ import (
 	"time"
 )
 
-var wg sync.WaitGroup
-var readChan chan string
-
 // dryRun indicates whether file operations should be simulated only
 var dryRun bool
 
+// configPath is the rules file read by getFolders (overridable with --config)
+var configPath = "config.json"
+
 type folder struct {
 	Name            string   `json:"name"`
 	Input           string   `json:"input"`
@@ -28,80 +32,91 @@ type folder struct {
 	DeleteOlderThan int      `json:"deleteOlderThan"`
 	RemoveOlderThan int      `json:"removeOlderThan,omitempty"` // legacy field retained for migration
 	DryRun          bool     `json:"dryRun"`
+	Verify          bool     `json:"verify,omitempty"`         // hash before and after each move; see verify.go
+	DeleteRemote    bool     `json:"deleteRemote,omitempty"`   // remove downloaded files from a remote input; see fetch.go
+	Action          string   `json:"action,omitempty"`         // move (default), copy, hardlink, symlink or reflink; see action.go
+	Companions      []string `json:"companions,omitempty"`     // extensions of sidecar files moved with each file; see companion.go
+	RenameTemplate  string   `json:"renameTemplate,omitempty"` // output name, e.g. "{date}_{stem}{ext}"; see rename.go
+
+	Preserve *preserveOptions `json:"preserve,omitempty"` // metadata kept by copy-based moves
+	Compress *compressOptions `json:"compress,omitempty"` // compress files into the output; see compress.go
+	Bundle   *bundleOptions   `json:"bundle,omitempty"`   // pack files into archives; see bundle.go
+	Encrypt  *encryptOptions  `json:"encrypt,omitempty"`  // encrypt files to age recipients; see encrypt.go
+	Scan     *scanOptions     `json:"scan,omitempty"`     // symbolic links and special files; see scan.go
+	Ready    *readyOptions    `json:"ready,omitempty"`    // defer files still being written; see ready.go
+	Sanitize *sanitizeOptions `json:"sanitize,omitempty"` // clean up output names; see rename.go
+	DirMode  string           `json:"dirMode,omitempty"`  // octal mode for created directories, e.g. "2775"
+	DirOwner string           `json:"dirOwner,omitempty"` // "user:group" for created directories
 }
 
-func main() {
-	header()
-
-	// Parse flags early
-	dryRunFlag := flag.Bool("dry-run", false, "simulate all operations without changing the filesystem")
-	flag.Parse()
-
-	// Allow env override (SLOTH_DRY_RUN=1)
-	if os.Getenv("SLOTH_DRY_RUN") == "1" {
-		dryRun = true
-	} else {
-		dryRun = *dryRunFlag
-	}
-
-	appLogger := NewAppLogger(dryRun)
-	start := time.Now()
-	appLogger.Info("Start time: %s", start.Format(time.RFC3339))
-
-	balancer := &Balancer{}
-	folders := getFolders(appLogger)
-	elapsed := time.Since(start)
-
-	// Use index loop to avoid implicit memory aliasing of range variable when taking its address
-	for i := range folders {
-		processFolder(appLogger, balancer, &folders[i])
-	}
+// dryRunSampleLimit caps how many files each rule acts on (and logs) in dry-run mode so
+// a dry run over a large tree stays readable. `sloth plan` lists everything.
+const dryRunSampleLimit = 5
 
-	appLogger.Summary(elapsed)
+func main() {
+	os.Exit(runCLI(os.Args[1:]))
 }
 
-// deleteFiles using filepath.WalkDir (more efficient than filepath.Walk)
+// deleteFiles removes files older than removeOlderThan days under inPath, which may be
+// a local path or a remote storage location (see storage.go). wrapped is passed to
+// matchesExtension.
 // TODO: swap inPath for Outpath. Need to avoid deleting files from root folders.
-func deleteFiles(inPath, extension string, removeOlderThan int, appLogger *AppLogger, dryRun bool) {
-	const dryRunDeleteLimit = 5
+func deleteFiles(inPath, extension string, wrapped bool, removeOlderThan int, sc *scanOptions, appLogger *AppLogger, dryRun bool) {
 	deleteCount := 0
 
-	e := filepath.WalkDir(inPath, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
+	if root, err := filepath.Abs(inPath); err == nil && !isRemote(inPath) && filepath.Dir(root) == root {
+		appLogger.GuardAbort("refusing to delete from a filesystem root", srcAttr(root))
+		return
+	}
+	st, err := storageFor(inPath)
+	if err != nil {
+		appLogger.ErrorAttrs("delete traversal error", srcAttr(inPath), errAttr(err))
+		return
+	}
+
+	e := listLoc(inPath, sc, func(path string, fileInfo os.FileInfo) error {
+		if appLogger.Interrupted() {
+			return filepath.SkipAll
 		}
-		if d.IsDir() {
+		name := fileInfo.Name()
+		if name == manifestName || strings.HasSuffix(name, bundleIndexSuffix) {
 			return nil
 		}
 
-		fileInfo, err := d.Info()
-		if err != nil {
-			return err
-		}
-
-		if filepath.Ext(d.Name()) == extension && fileInfo.ModTime().Before(time.Now().AddDate(0, 0, -1*removeOlderThan)) {
+		if matchesExtension(name, extension, wrapped) && fileInfo.ModTime().Before(time.Now().AddDate(0, 0, -1*removeOlderThan)) {
 			if dryRun {
-				if deleteCount < dryRunDeleteLimit {
-					appLogger.Info("[DRY-RUN] Would delete: %s", path)
+				appLogger.CountDeleted(inPath, fileInfo.Size())
+				if deleteCount < dryRunSampleLimit {
+					appLogger.InfoAttrs("[DRY-RUN] Would delete", srcAttr(path), bytesAttr(fileInfo.Size()))
 					deleteCount++
-				} else if deleteCount == dryRunDeleteLimit {
-					appLogger.Info("[DRY-RUN] Reached sample limit (%d files), skipping remaining deletions", dryRunDeleteLimit)
+				} else if deleteCount == dryRunSampleLimit {
+					appLogger.Info("[DRY-RUN] Reached sample limit (%d files), skipping remaining deletions (use `sloth plan` for the full list)", dryRunSampleLimit)
 					deleteCount++
 				}
 				return nil
 			}
-			err = os.Remove(path)
+			err = st.Delete(path)
 			if err != nil {
-				appLogger.Error("delete failed: %v", err)
+				appLogger.CountFailed(inPath)
+				appLogger.ErrorAttrs("delete failed", srcAttr(path), errAttr(err))
 				return err
 			}
-			appLogger.Info("Deleted: %s", path)
+			if !isRemote(path) {
+				removeBundleIndex(path)
+				if err := removeFromManifest(path); err != nil {
+					appLogger.WarnAttrs("manifest not updated", srcAttr(path), errAttr(err))
+				}
+			}
+			appLogger.CountDeleted(inPath, fileInfo.Size())
+			appLogger.InfoAttrs("Deleted", srcAttr(path), bytesAttr(fileInfo.Size()))
 		}
 		return nil
+	}, func(path string, err error) {
+		appLogger.WarnAttrs("skipped during delete traversal", srcAttr(path), errAttr(err))
 	})
 
 	if e != nil {
-		appLogger.Error("delete traversal error: %v", e)
+		appLogger.ErrorAttrs("delete traversal error", srcAttr(inPath), errAttr(e))
 	}
 }
 
@@ -114,136 +129,343 @@ func processFolder(appLogger *AppLogger, balancer *Balancer, f *folder) {
 	folderType := f.FolderType
 	removeOlderThan := f.DeleteOlderThan
 	localDryRun := dryRun || f.DryRun
+	ruleLog := appLogger.WithRule(name)
+	ruleLog.StartRule()
+	defer ruleLog.FinishRule()
+
+	dirs, err := newDirSettings(f)
+	if err != nil {
+		ruleLog.Error("%v", err)
+		return
+	}
 
-	readChan = make(chan string, 100)
+	if !localDryRun && !confirm.rule(ruleLog, f) {
+		return
+	}
 
 	// For delete-only rules (folderType == "delete"), delete from INPUT and skip move operations
 	if strings.EqualFold(folderType, "delete") {
 		if removeOlderThan > 0 && inPath != "" {
-			appLogger.Info("[Rule:%s] Deleting files older than %d days from INPUT: %s", name, removeOlderThan, inPath)
-			deleteFiles(inPath, extension, removeOlderThan, appLogger, localDryRun)
+			ruleLog.InfoAttrs("Deleting old files from input", srcAttr(inPath), slog.Int("olderThanDays", removeOlderThan))
+			deleteFiles(inPath, extension, false, removeOlderThan, f.Scan, ruleLog, localDryRun)
 		}
-		appLogger.Info("[Rule:%s] Delete-only rule completed", name)
 		return
 	}
 
 	// Check if output paths exist; if not, try creating only the last directory component
 	for _, outPath := range outPaths {
+		if isRemote(outPath) {
+			// Remote folders are created on upload; connect now to fail early.
+			if _, err := storageFor(outPath); err != nil {
+				ruleLog.ErrorAttrs("cannot connect to output", dstAttr(outPath), errAttr(err))
+				return
+			}
+			continue
+		}
 		if _, err := os.Stat(outPath); os.IsNotExist(err) {
 			parentDir := filepath.Dir(outPath)
 			if _, err := os.Stat(parentDir); os.IsNotExist(err) {
-				appLogger.Error("[Rule:%s] Output parent directory does not exist: %s (cannot auto-create)", name, parentDir)
+				ruleLog.GuardAbort("output parent directory does not exist (cannot auto-create)", dstAttr(parentDir), errAttr(err))
 				return
 			}
 			// Parent exists, create just the final directory
-			if err := os.Mkdir(outPath, 0755); err != nil {
-				appLogger.Error("[Rule:%s] Failed to create output directory %s: %v", name, outPath, err)
+			if err := makeDirs(outPath, dirs); err != nil {
+				ruleLog.ErrorAttrs("Failed to create output directory", dstAttr(outPath), errAttr(err))
 				return
 			}
-			appLogger.Info("[Rule:%s] Created output directory: %s", name, outPath)
+			ruleLog.InfoAttrs("Created output directory", dstAttr(outPath))
 		}
 	}
 
-	files, err := os.ReadDir(inPath)
-	if err != nil {
-		appLogger.Error("ReadDir error: %v", err)
+	// A remote input is downloaded into a local staging directory, which then stands in
+	// for the input.
+	remoteInput := isRemote(inPath)
+	if remoteInput {
+		staged, ok := fetchRemote(ruleLog, f, localDryRun)
+		if !ok {
+			return
+		}
+		local := *f
+		local.Input = staged
+		f, inPath = &local, staged
+	}
+
+	files, skipped, err := scanRule(inPath, f)
+	if err != nil && !(remoteInput && os.IsNotExist(err)) {
+		ruleLog.ErrorAttrs("ReadDir error", srcAttr(inPath), errAttr(err))
 		return
 	}
+	for _, s := range skipped {
+		ruleLog.DebugAttrs("Skipped "+s.reason, srcAttr(s.path))
+	}
+	ruleLog.CountSkipped(len(skipped))
+	groups := readyFiles(ruleLog, f.Ready, inPath, f.groupFiles(files))
+
+	// Limit dry-run to a sample of files to avoid massive logs
+	if localDryRun && len(groups) > dryRunSampleLimit {
+		ruleLog.Info("DRY-RUN: Found %d files, limiting to %d sample files (use `sloth plan` for the full list)", len(groups), dryRunSampleLimit)
+		for _, g := range groups[dryRunSampleLimit:] {
+			ruleLog.CountSkipped(g.size())
+		}
+		groups = groups[:dryRunSampleLimit]
+	}
 
-	// Filter matching files
-	var matchingFiles []string
-	for _, element := range files {
-		if !element.IsDir() {
-			if filepath.Ext(element.Name()) == extension || extension == "" {
-				matchingFiles = append(matchingFiles, element.Name())
-			}
+	if f.Bundle != nil {
+		var names []string
+		for _, g := range groups {
+			names = append(names, g.fi.Name())
 		}
+		bundleFiles(ruleLog, balancer, f, dirs, names, localDryRun)
+	} else {
+		moveAll(ruleLog, balancer, f, dirs, groups, localDryRun)
 	}
 
-	// Limit dry-run to sample of 5 files to avoid massive logs
-	const dryRunSampleLimit = 5
-	if localDryRun && len(matchingFiles) > dryRunSampleLimit {
-		appLogger.Info(
-			"[Rule:%s] DRY-RUN: Found %d files, limiting to %d sample files",
-			name,
-			len(matchingFiles),
-			dryRunSampleLimit,
-		)
-		matchingFiles = matchingFiles[:dryRunSampleLimit]
+	// For move rules with deleteOlderThan, delete old files from OUTPUT paths (archives)
+	if removeOlderThan > 0 && len(outPaths) > 0 {
+		ruleLog.InfoAttrs("Deleting old files from output paths", slog.Int("olderThanDays", removeOlderThan))
+		for _, outPath := range outPaths {
+			deleteFiles(outPath, f.Sanitize.ext(extension), f.wrapsOutputs(), removeOlderThan, f.Scan, ruleLog, localDryRun)
+		}
 	}
+}
 
+// moveAll moves groups of files from the rule's input with a pool of workers.
+func moveAll(ruleLog *AppLogger, balancer *Balancer, f *folder, dirs dirSettings, groups []fileGroup, localDryRun bool) {
 	var numWorkers = 2 * runtime.GOMAXPROCS(0)
 
-	appLogger.Info("[Rule:%s] Starting %d workers (dryRun=%v)", name, numWorkers, localDryRun)
+	ruleLog.InfoAttrs("Starting workers", slog.Int("workers", numWorkers), slog.Bool("dryRun", localDryRun))
+	prog := startProgress(ruleLog, len(groups))
+	readChan := make(chan fileGroup, 100)
+	var wg sync.WaitGroup
 	wg.Add(numWorkers)
 	for i := 0; i < numWorkers; i++ {
-		go moveFiles(appLogger, balancer, readChan, inPath, outPaths, folderType, localDryRun)
+		go moveFiles(ruleLog, balancer, &wg, readChan, f, dirs, localDryRun, prog)
 	}
 
-	for _, fileName := range matchingFiles {
-		readChan <- fileName
+	for i, g := range groups {
+		if ruleLog.Interrupted() {
+			for _, g := range groups[i:] {
+				ruleLog.CountSkipped(g.size())
+			}
+			break
+		}
+		readChan <- g
 	}
 
 	close(readChan)
 	wg.Wait()
-
-	// For move rules with deleteOlderThan, delete old files from OUTPUT paths (archives)
-	if removeOlderThan > 0 && len(outPaths) > 0 {
-		appLogger.Info("[Rule:%s] Deleting files older than %d days from OUTPUT paths", name, removeOlderThan)
-		for _, outPath := range outPaths {
-			deleteFiles(outPath, extension, removeOlderThan, appLogger, localDryRun)
-		}
-	}
-
-	appLogger.Info("[Rule:%s] Completed", name)
+	prog.finish()
 }
 
 func moveFiles(
 	appLogger *AppLogger,
 	b *Balancer,
-	inChan chan string,
-	inPath string,
-	outPaths []string,
-	folderType string,
+	wg *sync.WaitGroup,
+	inChan chan fileGroup,
+	f *folder,
+	dirs dirSettings,
 	localDryRun bool,
+	prog *progress,
 ) {
-	for fileToMove := range inChan {
-		in := filepath.Join(inPath, fileToMove)
-		balOut, err := b.Next(outPaths)
-		if err != nil {
-			appLogger.Error("Balancer error: %v", err)
-			continue
+	for g := range inChan {
+		var companions []string
+		for _, c := range g.companions {
+			companions = append(companions, c.Name())
 		}
-		outFolder := createOutputPath(appLogger, inPath, balOut, fileToMove, folderType)
-		out := filepath.Join(outFolder, fileToMove)
+		prog.add(moveFile(appLogger, b, f, dirs, g.fi.Name(), companions, localDryRun))
+	}
+	wg.Done()
+}
 
-		if localDryRun {
-			appLogger.Info("[DRY-RUN] Would create folder: %s", outFolder)
-			appLogger.Info("[DRY-RUN] Would move %s -> %s", in, out)
-			continue
+// moveFile moves one file from the rule's input into the output picked by the balancer,
+// followed by its companions (see companion.go), and returns their size, or 0 if it
+// failed before the size was known.
+func moveFile(appLogger *AppLogger, b *Balancer, f *folder, dirs dirSettings, fileToMove string, companions []string, localDryRun bool) int64 {
+	in := filepath.Join(f.Input, fileToMove)
+	var balOut string
+	if fi, err := f.statInput(fileToMove); err == nil {
+		balOut = f.placedOn(f.Input, fi, companions)
+	}
+	var err error
+	if balOut == "" {
+		balOut, err = b.Next(f.Output)
+	}
+	if err != nil {
+		appLogger.CountFailed("")
+		appLogger.ErrorAttrs("Balancer error", srcAttr(in), errAttr(err))
+		return 0
+	}
+	follow := f.Scan.symlinks() == "follow" && isSymlink(in)
+	outFolder := createOutputPath(appLogger, f.Input, balOut, fileToMove, f.FolderType, follow)
+	if outFolder == "" {
+		// createOutputPath already logged why
+		appLogger.CountFailed(balOut)
+		return 0
+	}
+	outNames, release, err := f.claimOutNames(fileToMove, companions, outFolder)
+	if err != nil {
+		appLogger.CountFailed(balOut)
+		appLogger.ErrorAttrs("rename failed", srcAttr(in), errAttr(err))
+		return 0
+	}
+	defer release()
+
+	size, ok := moveInto(appLogger, f, dirs, balOut, outFolder, fileToMove, outNames[0], localDryRun)
+	if !ok {
+		return size
+	}
+	// Companions go after the primary file, so a failed primary keeps its group
+	// together in the input.
+	for i, c := range companions {
+		n, _ := moveInto(appLogger, f, dirs, balOut, outFolder, c, outNames[i+1], localDryRun)
+		size += n
+	}
+	return size
+}
+
+// moveInto moves the file name from the rule's input into outFolder on the output balOut,
+// as outName (see rename.go). It returns the file's size and whether it was moved.
+func moveInto(appLogger *AppLogger, f *folder, dirs dirSettings, balOut, outFolder, name, outName string, localDryRun bool) (int64, bool) {
+	in := filepath.Join(f.Input, name)
+	// A followed link is moved by copying its target and removing the link.
+	follow := f.Scan.symlinks() == "follow" && isSymlink(in)
+	out := joinLoc(outFolder, outName+f.Compress.ext()+f.Encrypt.ext())
+
+	var size int64
+	if fi, err := os.Lstat(in); err == nil {
+		size = fi.Size()
+	}
+	if follow {
+		if fi, err := os.Stat(in); err == nil {
+			size = fi.Size()
 		}
+	}
 
-		// Ensure destination folder exists
-		if err := os.MkdirAll(outFolder, 0755); err != nil {
-			appLogger.Error("mkdir failed: %v", err)
-			continue
+	if a := f.action(); a != "move" {
+		return size, placeAction(appLogger, f, dirs, a, in, out, balOut, size, localDryRun)
+	}
+
+	if localDryRun {
+		appLogger.CountMoved(balOut, size)
+		appLogger.InfoAttrs("[DRY-RUN] Would create folder", dstAttr(outFolder))
+		if f.Encrypt != nil {
+			appLogger.InfoAttrs("[DRY-RUN] Would encrypt", srcAttr(in), dstAttr(out), bytesAttr(size))
+		} else if f.Compress != nil {
+			appLogger.InfoAttrs("[DRY-RUN] Would compress", srcAttr(in), dstAttr(out), bytesAttr(size))
+		} else {
+			appLogger.InfoAttrs("[DRY-RUN] Would move", srcAttr(in), dstAttr(out), bytesAttr(size))
+		}
+		return size, true
+	}
+
+	if isRemote(out) {
+		start := time.Now()
+		if err := uploadMove(in, out, f.Compress, f.Encrypt); err != nil {
+			appLogger.CountFailed(balOut)
+			appLogger.ErrorAttrs("upload failed", srcAttr(in), dstAttr(out), errAttr(err))
+			return size, false
 		}
+		journal.record(appLogger.rule, in, out, f.Compress, f.Encrypt != nil)
+		removeSentinels(in, f.Ready.sentinel())
+		appLogger.CountMoved(balOut, size)
+		appLogger.DebugAttrs("Uploaded", srcAttr(in), dstAttr(out), bytesAttr(size), durationAttr(time.Since(start)))
+		return size, true
+	}
+
+	start := time.Now()
+	m := localMove{dirs: dirs, preserve: f.Preserve, compress: f.Compress, encrypt: f.Encrypt, follow: follow, verify: f.Verify}
+	if what, err := m.move(in, out); err != nil {
+		appLogger.CountFailed(balOut)
+		appLogger.ErrorAttrs(what, srcAttr(in), dstAttr(out), errAttr(err))
+		return size, false
+	}
+	journal.record(appLogger.rule, in, out, f.Compress, f.Encrypt != nil)
+	removeSentinels(in, f.Ready.sentinel())
+	appLogger.CountMoved(balOut, size)
+	appLogger.DebugAttrs("Moved", srcAttr(in), dstAttr(out), bytesAttr(size), durationAttr(time.Since(start)))
+	return size, true
+}
+
+// localMove is how a file is written into a local output folder, by moveInto for a run
+// and by apply for a plan entry.
+type localMove struct {
+	dirs     dirSettings
+	preserve *preserveOptions
+	compress *compressOptions
+	encrypt  *encryptOptions
+	follow   bool // in is a link that stands for its target, which is copied
+	verify   bool
+}
 
-		err = os.Rename(in, out)
+// move moves in to out, creating out's folder: a rename, or a copy when the file is
+// transformed, a followed link or on another filesystem. With verify, in is hashed
+// first, out is checked against it (a renamed file that does not match is moved back)
+// and recorded in its folder's manifest. On failure it returns what failed, for the log.
+func (m localMove) move(in, out string) (string, error) {
+	if err := makeDirs(filepath.Dir(out), m.dirs); err != nil {
+		return "mkdir failed", err
+	}
+	var special bool
+	if fi, err := os.Lstat(in); err == nil {
+		special = isSpecial(fi.Mode())
+	}
+
+	var sum string
+	var err error
+	if m.verify {
+		if sum, err = sha256File(in); err != nil {
+			return "hash failed", err
+		}
+	}
+
+	copied := m.compress != nil || m.encrypt != nil || m.follow
+	var written string
+	if copied {
+		written, err = copyMove(in, out, m.preserve, m.compress, m.encrypt, sum)
+	} else if err = os.Rename(in, out); isCrossDevice(err) && special {
+		err = fmt.Errorf("special files are only renamed, not copied to another filesystem: %w", err)
+	} else if isCrossDevice(err) {
+		copied = true
+		written, err = copyMove(in, out, m.preserve, nil, nil, sum)
+	}
+	if err != nil {
+		return "move failed", err
+	}
+	if m.verify {
+		if copied {
+			// copyMove already compared the copy with sum
+			err = appendManifest(filepath.Dir(out), filepath.Base(out), written)
+		} else {
+			err = verifyMove(in, out, sum)
+		}
 		if err != nil {
-			appLogger.Error("rename failed: %v", err)
+			return "verification failed", err
 		}
 	}
-	wg.Done()
+	return "", nil
 }
 
-func createOutputPath(appLogger *AppLogger, inPath, outPath, fileToMove, folderType string) string {
-	fi, err := os.Stat(filepath.Join(inPath, fileToMove))
+// createOutputPathTypes lists the folderType values understood by createOutputPath.
+var createOutputPathTypes = map[string]bool{"1": true, "2": true, "3": true, "4": true, "5": true}
+
+// createOutputPath returns the folder under outPath for fileToMove, by the time of a
+// symbolic link itself unless follow is set.
+func createOutputPath(appLogger *AppLogger, inPath, outPath, fileToMove, folderType string, follow bool) string {
+	stat := os.Lstat
+	if follow {
+		stat = os.Stat
+	}
+	fi, err := stat(filepath.Join(inPath, fileToMove))
 	if err != nil {
-		appLogger.Error("failed to stat file %s: %v", fileToMove, err)
+		appLogger.ErrorAttrs("failed to stat file", srcAttr(filepath.Join(inPath, fileToMove)), errAttr(err))
 		return ""
 	}
+	return outputFolder(outPath, fi, folderType)
+}
 
+// outputFolder returns the folder under outPath that a file belongs in for folderType,
+// or "" for an unknown folderType. It does not touch the filesystem.
+func outputFolder(outPath string, fi os.FileInfo, folderType string) string {
 	mTime := fi.ModTime()
 
 	year := strconv.Itoa(mTime.Year())
@@ -255,21 +477,21 @@ func createOutputPath(appLogger *AppLogger, inPath, outPath, fileToMove, folderT
 	switch folderType {
 	// 1 uses file mod time as the folder YYYY\MM\Day DD format
 	case "1":
-		return filepath.Join(outPath, year, month, day)
+		return joinLoc(outPath, year, month, day)
 
 	// 2 uses the extension as the folder
 	case "2":
 		if len(ext) > 1 {
-			return filepath.Join(outPath, ext[1])
+			return joinLoc(outPath, ext[1])
 		}
 		return outPath
 
 	// 3 uses the extension as the folder and then groups by year
 	case "3":
 		if len(ext) > 1 {
-			return filepath.Join(outPath, ext[1], year)
+			return joinLoc(outPath, ext[1], year)
 		}
-		return filepath.Join(outPath, year)
+		return joinLoc(outPath, year)
 
 	// 4 will go to the root of defaultOut - ie moves files to the root of the output path
 	case "4":
@@ -277,26 +499,69 @@ func createOutputPath(appLogger *AppLogger, inPath, outPath, fileToMove, folderT
 
 	// 5 uses mod time as the folder in YYYYMM format
 	case "5":
-		return filepath.Join(outPath, mTime.Format("200601"))
+		return joinLoc(outPath, mTime.Format("200601"))
 
 	default:
 		return ""
 	}
 }
 
-// getFolders loads config and performs migration from legacy delete rules.
+// getFolders loads config, performs migration from legacy delete rules and validates the
+// result.
 func getFolders(appLogger *AppLogger) []folder {
-	raw, err := os.ReadFile("config.json")
+	folders, _, err := loadFolders(configPath, appLogger)
+	if err == nil {
+		err = validateFolders(folders)
+	}
+	if err != nil {
+		appLogger.Error("%v", err)
+		os.Exit(exitConfig)
+	}
+	return folders
+}
+
+// parseFolders reads and migrates the rules file at path without writing anything back.
+// needsSave reports whether the file uses legacy settings that loadFolders would rewrite.
+func parseFolders(path string, appLogger *AppLogger) (folders []folder, needsSave bool, err error) {
+	raw, err := readConfig(path)
 	if err != nil {
-		appLogger.Error("getFolders read error: %v", err)
-		os.Exit(1)
+		return nil, false, err
 	}
+	return migrateFolders(raw, appLogger)
+}
 
-	migrated, needsSave, err := migrateConfig(raw, appLogger)
+// readConfig returns the content of the rules file at path.
+func readConfig(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
 	if err != nil {
-		appLogger.Error("migration failed: %v", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("getFolders read error: %w", err)
 	}
+	return raw, nil
+}
+
+// migrateFolders parses the rules file content raw, migrating legacy settings.
+func migrateFolders(raw []byte, appLogger *AppLogger) ([]folder, bool, error) {
+	folders, needsSave, err := migrateConfig(raw, appLogger)
+	if err != nil {
+		return nil, false, fmt.Errorf("migration failed: %w: %w", errInvalidConfig, err)
+	}
+	return folders, needsSave, nil
+}
+
+// loadFolders reads and migrates the rules file at path, writing the migrated form back when needed.
+// Failures are reported to the caller instead of exiting, so it can be used for reloads. It
+// also returns the SHA-256 of the file as it was read, which the run report names as the
+// config that ran even if the file is migrated or edited afterwards.
+func loadFolders(path string, appLogger *AppLogger) ([]folder, string, error) {
+	raw, err := readConfig(path)
+	if err != nil {
+		return nil, "", err
+	}
+	migrated, needsSave, err := migrateFolders(raw, appLogger)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(raw)
 
 	// Write back the migrated config if changes were made
 	if needsSave {
@@ -304,15 +569,117 @@ func getFolders(appLogger *AppLogger) []folder {
 		if err != nil {
 			appLogger.Error("failed to marshal migrated config: %v", err)
 		} else {
-			if err := os.WriteFile("config.json", configBytes, 0600); err != nil {
+			if err := os.WriteFile(path, configBytes, 0600); err != nil {
 				appLogger.Error("failed to write migrated config: %v", err)
 			} else {
-				appLogger.Info("Updated config.json with migrated settings")
+				appLogger.Info("Updated %s with migrated settings", path)
 			}
 		}
 	}
 
-	return migrated
+	return migrated, hex.EncodeToString(sum[:]), nil
+}
+
+// validateFolders checks that a rule set is runnable. Rule names must be unique because
+// long-running mode tracks rules by name across reloads.
+func validateFolders(folders []folder) error {
+	seen := make(map[string]bool, len(folders))
+	for i := range folders {
+		f := &folders[i]
+		if f.Name == "" {
+			return fmt.Errorf("%w: rule %d: name is required", errInvalidConfig, i)
+		}
+		if seen[f.Name] {
+			return fmt.Errorf("%w: rule %q: duplicate name", errInvalidConfig, f.Name)
+		}
+		seen[f.Name] = true
+		if f.Input == "" || f.Input == "." {
+			return fmt.Errorf("%w: rule %q: input is required", errInvalidConfig, f.Name)
+		}
+		if err := validateLocation(f.Input); err != nil {
+			return fmt.Errorf("%w: rule %q: input: %v", errInvalidConfig, f.Name, err)
+		}
+		if f.DeleteRemote && !isRemote(f.Input) {
+			return fmt.Errorf("%w: rule %q: deleteRemote needs a remote input", errInvalidConfig, f.Name)
+		}
+		if err := validateAction(f); err != nil {
+			return fmt.Errorf("%w: rule %q: %v", errInvalidConfig, f.Name, err)
+		}
+		if err := validateCompanions(f); err != nil {
+			return fmt.Errorf("%w: rule %q: %v", errInvalidConfig, f.Name, err)
+		}
+		if err := validateRename(f); err != nil {
+			return fmt.Errorf("%w: rule %q: %v", errInvalidConfig, f.Name, err)
+		}
+		for _, out := range f.Output {
+			if err := validateLocation(out); err != nil {
+				return fmt.Errorf("%w: rule %q: output: %v", errInvalidConfig, f.Name, err)
+			}
+			if isRemote(out) && f.Bundle != nil {
+				return fmt.Errorf("%w: rule %q: bundle needs local outputs; %s is remote", errInvalidConfig, f.Name, out)
+			}
+			if isRemote(out) && f.Scan.special() == "include" {
+				return fmt.Errorf("%w: rule %q: scan.special include needs local outputs; %s is remote", errInvalidConfig, f.Name, out)
+			}
+			if isRemote(out) && f.Verify {
+				return fmt.Errorf("%w: rule %q: verify needs local outputs; uploads to %s are size-checked instead", errInvalidConfig, f.Name, out)
+			}
+			if f.DeleteOlderThan > 0 && !keepsModTime(out) {
+				return fmt.Errorf("%w: rule %q: deleteOlderThan cannot age files on %s, which sets its own modification times", errInvalidConfig, f.Name, out)
+			}
+		}
+		switch {
+		case strings.EqualFold(f.FolderType, "delete"):
+			if f.DeleteOlderThan <= 0 {
+				return fmt.Errorf("%w: rule %q: delete rules need deleteOlderThan > 0", errInvalidConfig, f.Name)
+			}
+		case createOutputPathTypes[f.FolderType]:
+			if len(f.Output) == 0 {
+				return fmt.Errorf("%w: rule %q: at least one output is required", errInvalidConfig, f.Name)
+			}
+		default:
+			return fmt.Errorf("%w: rule %q: unknown folderType %q", errInvalidConfig, f.Name, f.FolderType)
+		}
+		if _, err := newDirSettings(f); err != nil {
+			return err
+		}
+		if f.Compress != nil {
+			if err := f.Compress.validate(); err != nil {
+				return fmt.Errorf("%w: rule %q: %v", errInvalidConfig, f.Name, err)
+			}
+		}
+		if f.Bundle != nil {
+			if f.Compress != nil {
+				return fmt.Errorf("%w: rule %q: compress and bundle cannot be combined; compress the bundle with bundle.compress", errInvalidConfig, f.Name)
+			}
+			if err := f.Bundle.validate(); err != nil {
+				return fmt.Errorf("%w: rule %q: %v", errInvalidConfig, f.Name, err)
+			}
+		}
+		if err := f.Scan.validate(); err != nil {
+			return fmt.Errorf("%w: rule %q: %v", errInvalidConfig, f.Name, err)
+		}
+		if f.Scan.special() == "include" && (f.Verify || f.Compress != nil || f.Encrypt != nil || f.Bundle != nil || f.action() != "move") {
+			return fmt.Errorf("%w: rule %q: special files are never read; scan.special include cannot be combined with verify, compress, encrypt, bundle or a copy or link action", errInvalidConfig, f.Name)
+		}
+		if f.Ready != nil {
+			if err := f.Ready.validate(); err != nil {
+				return fmt.Errorf("%w: rule %q: %v", errInvalidConfig, f.Name, err)
+			}
+			if isRemote(f.Input) && (f.Ready.NotOpen || f.Ready.Sentinel != "") {
+				return fmt.Errorf("%w: rule %q: ready.notOpen and ready.sentinel need a local input; downloads are only staged once complete", errInvalidConfig, f.Name)
+			}
+		}
+		if f.Encrypt != nil {
+			if f.Bundle != nil {
+				return fmt.Errorf("%w: rule %q: encrypt and bundle cannot be combined; bundles are appended to and must stay readable", errInvalidConfig, f.Name)
+			}
+			if err := f.Encrypt.validate(); err != nil {
+				return fmt.Errorf("%w: rule %q: %v", errInvalidConfig, f.Name, err)
+			}
+		}
+	}
+	return nil
 }
 
 // migrateConfig updates legacy configs by converting removeOlderThan to DeleteOlderThan
@@ -329,6 +696,9 @@ func migrateConfig(raw []byte, appLogger *AppLogger) ([]folder, bool, error) {
 
 	for _, m := range entries {
 		f := parseFolder(m)
+		if err := decodeOptions(m, &f); err != nil {
+			return nil, false, fmt.Errorf("rule %q: %w", f.Name, err)
+		}
 
 		// Check if legacy field exists (indicates migration needed)
 		if _, hasLegacy := m["removeOlderThan"]; hasLegacy && f.RemoveOlderThan > 0 {
@@ -361,13 +731,52 @@ func isDeleteRule(m map[string]any) bool {
 	return false
 }
 
+// decodeOptions fills the structured (object-valued) rule options from m.
+func decodeOptions(m map[string]any, f *folder) error {
+	decode := func(key string, dst any) error {
+		v, ok := m[key]
+		if !ok || v == nil {
+			return nil
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(dst); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		return nil
+	}
+	if err := decode("preserve", &f.Preserve); err != nil {
+		return err
+	}
+	if err := decode("compress", &f.Compress); err != nil {
+		return err
+	}
+	if err := decode("bundle", &f.Bundle); err != nil {
+		return err
+	}
+	if err := decode("encrypt", &f.Encrypt); err != nil {
+		return err
+	}
+	if err := decode("scan", &f.Scan); err != nil {
+		return err
+	}
+	if err := decode("ready", &f.Ready); err != nil {
+		return err
+	}
+	return decode("sanitize", &f.Sanitize)
+}
+
 func parseFolder(m map[string]any) folder {
 	f := folder{}
 	if v, ok := m["name"].(string); ok {
 		f.Name = v
 	}
 	if v, ok := m["input"].(string); ok {
-		f.Input = filepath.Clean(v)
+		f.Input = cleanLoc(v)
 	}
 	if v, ok := m["extension"].(string); ok {
 		f.Extension = v
@@ -378,10 +787,35 @@ func parseFolder(m map[string]any) folder {
 	if v, ok := m["dryRun"].(bool); ok {
 		f.DryRun = v
 	}
+	if v, ok := m["verify"].(bool); ok {
+		f.Verify = v
+	}
+	if v, ok := m["deleteRemote"].(bool); ok {
+		f.DeleteRemote = v
+	}
+	if v, ok := m["action"].(string); ok {
+		f.Action = v
+	}
+	if v, ok := m["renameTemplate"].(string); ok {
+		f.RenameTemplate = v
+	}
+	if v, ok := m["dirMode"].(string); ok {
+		f.DirMode = v
+	}
+	if v, ok := m["dirOwner"].(string); ok {
+		f.DirOwner = v
+	}
 	if arr, ok := m["output"].([]any); ok {
 		for _, o := range arr {
 			if s, ok := o.(string); ok {
-				f.Output = append(f.Output, filepath.Clean(s))
+				f.Output = append(f.Output, cleanLoc(s))
+			}
+		}
+	}
+	if arr, ok := m["companions"].([]any); ok {
+		for _, c := range arr {
+			if s, ok := c.(string); ok {
+				f.Companions = append(f.Companions, s)
 			}
 		}
 	}
@@ -399,7 +833,7 @@ func parseFolder(m map[string]any) folder {
 }
 
 func header() {
-	log.Println("Sloth: Running")
+	log.Printf("Sloth %s: Running", version)
 	log.Println("----------------------")
 	if dryRun {
 		log.Println("DRY-RUN mode enabled: no filesystem changes will be made")
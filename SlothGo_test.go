@@ -61,7 +61,7 @@ func TestCreateOutputPath(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			logger := NewAppLogger(true)
-			result := createOutputPath(logger, tempDir, outputPath(), "test.pdf", tt.folderType)
+			result := createOutputPath(logger, tempDir, outputPath(), "test.pdf", tt.folderType, false)
 			if result != tt.expected {
 				t.Errorf("createOutputPath() = %v, want %v", result, tt.expected)
 			}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ruleActions lists the values of a rule's action. Everything but "move" leaves the
+// source in the input, for another consumer, and places it in the output layout too.
+var ruleActions = map[string]bool{"move": true, "copy": true, "hardlink": true, "symlink": true, "reflink": true}
+
+// action returns the rule's action, "move" when unset.
+func (f *folder) action() string {
+	if f.Action == "" {
+		return "move"
+	}
+	return f.Action
+}
+
+// action returns the op's action, "move" when unset.
+func (op *planOp) action() string {
+	if op.Action == "" {
+		return "move"
+	}
+	return op.Action
+}
+
+// validateAction checks that the rule's action is known and fits its other options.
+func validateAction(f *folder) error {
+	a := f.action()
+	if !ruleActions[a] {
+		return fmt.Errorf("unknown action %q: must be move, copy, hardlink, symlink or reflink", f.Action)
+	}
+	if a == "move" {
+		return nil
+	}
+	switch {
+	case f.Bundle != nil:
+		return fmt.Errorf("action %s cannot be combined with bundle", a)
+	case isRemote(f.Input):
+		return fmt.Errorf("action %s needs a local input; files from a remote input are always moved", a)
+	case a != "copy" && (f.Compress != nil || f.Encrypt != nil):
+		return fmt.Errorf("action %s cannot be combined with compress or encrypt; use copy", a)
+	case f.DeleteOlderThan > 0:
+		return fmt.Errorf("action %s cannot be combined with deleteOlderThan; the source stays in the input and would be placed again once retention deleted it", a)
+	}
+	if a != "copy" {
+		for _, out := range f.Output {
+			if isRemote(out) {
+				return fmt.Errorf("action %s needs local outputs; %s is remote", a, out)
+			}
+		}
+	}
+	return nil
+}
+
+// alreadyPlaced reports whether a copy or link action already placed src at dst, so a
+// later run does not redo it while src waits in the input for its other consumer.
+// transformed copies differ in size, so only their time is compared.
+func alreadyPlaced(action, src, dst string, transformed bool) bool {
+	si, err := os.Stat(src)
+	if err != nil {
+		return false
+	}
+	di, err := lstatLoc(dst)
+	if err != nil {
+		return false
+	}
+	switch action {
+	case "hardlink":
+		return os.SameFile(si, di)
+	case "symlink":
+		abs, err := filepath.Abs(src)
+		target, lerr := os.Readlink(dst)
+		return err == nil && lerr == nil && target == abs
+	default:
+		// Copies keep src's mtime (or get a later one), and are up to date unless src
+		// changed after they were made.
+		return !di.ModTime().Before(si.ModTime()) && (transformed || di.Size() == si.Size())
+	}
+}
+
+// placedOn returns the output on which the rule's copy or link action already placed the
+// file fi from input, or "". The source stays in the input, so every run sees it again;
+// it goes back to the output it is on, not to the one the balancer picks next.
+func (f *folder) placedOn(input string, fi fs.FileInfo, companions []string) string {
+	a := f.action()
+	if a == "move" || len(f.Output) < 2 {
+		return ""
+	}
+	t, err := f.renameTokensOf(input, fi)
+	if err != nil {
+		return ""
+	}
+	src := filepath.Join(input, fi.Name())
+	suffix := f.Compress.ext() + f.Encrypt.ext()
+	transformed := f.Compress != nil || f.Encrypt != nil
+	for _, out := range f.Output {
+		outFolder := outputFolder(out, fi, f.FolderType)
+		if outFolder == "" {
+			return ""
+		}
+		names := f.outNames(t, companions, func(src, n string) bool {
+			return f.nameTaken(filepath.Join(input, src), joinLoc(outFolder, n+suffix))
+		})
+		if alreadyPlaced(a, src, joinLoc(outFolder, names[0]+suffix), transformed) {
+			return out
+		}
+	}
+	return ""
+}
+
+// placeFile performs a non-move action from src to dst, replacing dst. If want is set,
+// the placed data is checked against it. It returns the SHA-256 of dst for the
+// manifest, or want where dst shares src's data.
+func placeFile(action, src, dst string, p *preserveOptions, c *compressOptions, e *encryptOptions, want string) (string, error) {
+	switch action {
+	case "copy":
+		return copyFile(src, dst, p, c, e, want)
+	case "hardlink":
+		return want, replaceWith(dst, func(tmp string) error { return os.Link(src, tmp) })
+	case "symlink":
+		abs, err := filepath.Abs(src)
+		if err != nil {
+			return "", err
+		}
+		return want, replaceWith(dst, func(tmp string) error { return os.Symlink(abs, tmp) })
+	case "reflink":
+		if err := reflinkFile(src, dst, p); err != nil {
+			return "", err
+		}
+		if want == "" {
+			return "", nil
+		}
+		got, err := sha256File(dst)
+		if err == nil && got != want {
+			err = fmt.Errorf("%w: source %s, clone %s", errChecksumMismatch, want, got)
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+		return got, err
+	}
+	return "", fmt.Errorf("unknown action %q", action)
+}
+
+// replaceWith creates a link at a temporary name next to dst with create and renames it
+// over dst, so an existing dst is replaced like a move would replace it.
+func replaceWith(dst string, create func(tmp string) error) error {
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".link.tmp")
+	os.Remove(tmp)
+	if err := create(tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// reflinkFile clones src to dst. The clone shares src's blocks until either changes, and
+// gets src's metadata like a copy.
+func reflinkFile(src, dst string, p *preserveOptions) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	err = cloneFile(tmp, in)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = applyMetadata(src, tmp.Name(), fi, p)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("reflink: %w", err)
+	}
+	return nil
+}
+
+// place is move for the copy and link actions: it places in at out, leaving in where it
+// is. With verify, the placed data is checked against in's hash and recorded in the
+// manifest of out's folder.
+func (m localMove) place(action, in, out string) (string, error) {
+	if err := makeDirs(filepath.Dir(out), m.dirs); err != nil {
+		return "mkdir failed", err
+	}
+	var sum string
+	var err error
+	if m.verify {
+		if sum, err = sha256File(in); err != nil {
+			return "hash failed", err
+		}
+	}
+	written, err := placeFile(action, in, out, m.preserve, m.compress, m.encrypt, sum)
+	if err != nil {
+		return action + " failed", err
+	}
+	if m.verify {
+		if err := appendManifest(filepath.Dir(out), filepath.Base(out), written); err != nil {
+			return "verification failed", err
+		}
+	}
+	return "", nil
+}
+
+// placeAction is moveInto for the copy and link actions: it places in at out and leaves
+// in where it is. Files already placed by an earlier run are skipped. It reports whether
+// in is now in place.
+func placeAction(appLogger *AppLogger, f *folder, dirs dirSettings, action, in, out, balOut string, size int64, localDryRun bool) bool {
+	transformed := f.Compress != nil || f.Encrypt != nil
+	if alreadyPlaced(action, in, out, transformed) {
+		appLogger.CountSkipped(1)
+		appLogger.DebugAttrs("Already in place", srcAttr(in), dstAttr(out))
+		return true
+	}
+	if localDryRun {
+		appLogger.CountMoved(balOut, size)
+		appLogger.InfoAttrs("[DRY-RUN] Would create folder", dstAttr(dirLoc(out)))
+		appLogger.InfoAttrs("[DRY-RUN] Would "+action, srcAttr(in), dstAttr(out), bytesAttr(size))
+		return true
+	}
+
+	start := time.Now()
+	if isRemote(out) {
+		if err := uploadFile(in, out, f.Compress, f.Encrypt); err != nil {
+			appLogger.CountFailed(balOut)
+			appLogger.ErrorAttrs("upload failed", srcAttr(in), dstAttr(out), errAttr(err))
+			return false
+		}
+	} else {
+		m := localMove{dirs: dirs, preserve: f.Preserve, compress: f.Compress, encrypt: f.Encrypt, verify: f.Verify}
+		if what, err := m.place(action, in, out); err != nil {
+			appLogger.CountFailed(balOut)
+			appLogger.ErrorAttrs(what, srcAttr(in), dstAttr(out), errAttr(err))
+			return false
+		}
+	}
+	journal.recordAction(appLogger.rule, action, in, out, f.Compress, f.Encrypt != nil)
+	appLogger.CountMoved(balOut, size)
+	appLogger.DebugAttrs("Placed "+action, srcAttr(in), dstAttr(out), bytesAttr(size), durationAttr(time.Since(start)))
+	return true
+}
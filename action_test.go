@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRuleActions(t *testing.T) {
+	day := time.Date(2024, 3, 5, 9, 0, 0, 0, time.Local)
+	for _, action := range []string{"copy", "hardlink", "symlink", "reflink"} {
+		t.Run(action, func(t *testing.T) {
+			dir := t.TempDir()
+			in := filepath.Join(dir, "in")
+			out := filepath.Join(dir, "out")
+			for _, d := range []string{in, out} {
+				if err := os.MkdirAll(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			src := writeAged(t, in, "scan.pdf", "%PDF", day)
+
+			j, err := openJournal(filepath.Join(dir, "state"), runInfo{ID: action, Start: time.Now()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			journal = j
+			defer func() { journal = nil }()
+
+			rule := folder{Name: "Scans", Input: in, Output: []string{out}, Extension: ".pdf", FolderType: "5", Action: action}
+			if err := validateFolders([]folder{rule}); err != nil {
+				t.Fatal(err)
+			}
+			al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+			processFolder(al, &Balancer{}, &rule)
+			placed := filepath.Join(out, "202403", "scan.pdf")
+			if action == "reflink" && al.counters.errorsCount.Load() != 0 {
+				t.Skip("reflink is not supported by the filesystem of", dir)
+			}
+			if n := al.counters.errorsCount.Load(); n != 0 {
+				t.Fatalf("%d errors", n)
+			}
+			if data, err := os.ReadFile(placed); err != nil || string(data) != "%PDF" {
+				t.Fatalf("placed file = %q, %v", data, err)
+			}
+			if _, err := os.Stat(src); err != nil {
+				t.Fatalf("source was not left in place: %v", err)
+			}
+			si, _ := os.Stat(src)
+			pi, _ := os.Lstat(placed)
+			switch action {
+			case "hardlink":
+				if !os.SameFile(si, pi) {
+					t.Error("hard link is not the source's inode")
+				}
+			case "symlink":
+				if target, err := os.Readlink(placed); err != nil || target != src {
+					t.Errorf("symlink target = %q, %v", target, err)
+				}
+			default:
+				if os.SameFile(si, pi) || !pi.ModTime().Equal(day) {
+					t.Errorf("copy is not a separate file with the source's mtime: %v", pi.ModTime())
+				}
+			}
+
+			// The source is still there, so the next run sees it and skips it.
+			processFolder(al, &Balancer{}, &rule)
+			if n := al.counters.stats.rule("Scans").skipped.Load(); n != 1 {
+				t.Errorf("skipped = %d on the second run, want 1", n)
+			}
+			j.Close()
+
+			if restored, skipped := undoJournal(j.path, al, false); restored != 1 || skipped != 0 {
+				t.Fatalf("restored=%d skipped=%d", restored, skipped)
+			}
+			if _, err := os.Lstat(placed); !os.IsNotExist(err) {
+				t.Errorf("undo left the placed file: %v", err)
+			}
+			if data, err := os.ReadFile(src); err != nil || string(data) != "%PDF" {
+				t.Errorf("undo touched the source: %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestPlanCopyAction(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	day := time.Date(2024, 3, 5, 9, 0, 0, 0, time.Local)
+	src := writeAged(t, in, "a.csv", "1", day)
+	rules := []folder{{Name: "Copy", Input: in, Output: []string{out}, Extension: ".csv", FolderType: "4", Action: "copy"}}
+
+	plan := buildPlan(rules)
+	if ops := plan.Rules[0].Ops; len(ops) != 1 || ops[0].Op != "move" || ops[0].Action != "copy" {
+		t.Fatalf("ops = %+v", ops)
+	}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	if stale := applyPlan(al, plan, false); len(stale) != 0 {
+		t.Fatalf("stale entries: %+v", stale)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("applied copy removed the source: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "a.csv")); err != nil || string(data) != "1" {
+		t.Errorf("applied copy = %q, %v", data, err)
+	}
+	if ops := buildPlan(rules).Rules[0].Ops; len(ops) != 0 {
+		t.Errorf("file already copied was planned again: %+v", ops)
+	}
+}
+
+func TestCopyPlacedOnce(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	outA := filepath.Join(dir, "outA")
+	outB := filepath.Join(dir, "outB")
+	for _, d := range []string{in, outA, outB} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeAged(t, in, "a.pdf", "a", time.Now().Add(-time.Hour))
+	rule := folder{Name: "Copy", Input: in, Output: []string{outA, outB}, Extension: ".pdf", FolderType: "4", Action: "copy"}
+	if err := validateFolders([]folder{rule}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Watch mode keeps one balancer across passes, so each pass picks the next output.
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	b := &Balancer{}
+	for range 3 {
+		processFolder(al, b, &rule)
+		if ops := buildPlan([]folder{rule}).Rules[0].Ops; len(ops) != 0 {
+			t.Errorf("copied file was planned again: %+v", ops)
+		}
+	}
+	copies := 0
+	for _, out := range []string{outA, outB} {
+		if _, err := os.Stat(filepath.Join(out, "a.pdf")); err == nil {
+			copies++
+		}
+	}
+	if copies != 1 {
+		t.Errorf("a.pdf copied to %d outputs, want 1", copies)
+	}
+	if n := al.counters.stats.rule("Copy").skipped.Load(); n != 2 {
+		t.Errorf("skipped = %d, want 2", n)
+	}
+}
+
+func TestActionValidation(t *testing.T) {
+	base := folder{Name: "r", Input: "in", Output: []string{"out"}, FolderType: "4"}
+	for name, mod := range map[string]func(*folder){
+		"unknown action":      func(f *folder) { f.Action = "clone" },
+		"bundle with copy":    func(f *folder) { f.Action = "copy"; f.Bundle = &bundleOptions{Format: "zip"} },
+		"compressed link":     func(f *folder) { f.Action = "hardlink"; f.Compress = &compressOptions{Format: "gzip"} },
+		"remote input":        func(f *folder) { f.Action = "copy"; f.Input = "s3://bucket/in" },
+		"link to remote out":  func(f *folder) { f.Action = "symlink"; f.Output = []string{"s3://bucket/out"} },
+		"copy with retention": func(f *folder) { f.Action = "copy"; f.DeleteOlderThan = 30 },
+	} {
+		f := base
+		mod(&f)
+		if validateFolders([]folder{f}) == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+	f := base
+	f.Action, f.Compress = "copy", &compressOptions{Format: "gzip"}
+	if err := validateFolders([]folder{f}); err != nil {
+		t.Errorf("compressed copy: %v", err)
+	}
+}
+
+func TestDeleteRuleDoesNotFollowSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	other := filepath.Join(dir, "other")
+	root := filepath.Join(dir, "root")
+	for _, d := range []string{other, root} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().AddDate(0, 0, -30)
+	kept := writeAged(t, other, "keep.log", "x", old)
+	if err := os.Symlink(other, filepath.Join(root, "tree")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(kept, filepath.Join(root, "old.log")); err != nil {
+		t.Fatal(err)
+	}
+
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	deleteFiles(root, ".log", false, 7, nil, al, false)
+	if _, err := os.Stat(kept); err != nil {
+		t.Fatalf("file outside the delete root was removed: %v", err)
+	}
+}
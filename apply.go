@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// savePlan writes plan as JSON for `sloth apply`. The file is written atomically.
+func savePlan(path string, plan *runPlan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func loadPlan(path string) (*runPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan runPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("%w: plan %s: %w", errInvalidConfig, path, err)
+	}
+	return &plan, nil
+}
+
+// staleEntry is a plan entry that apply refused because the filesystem changed since planning.
+type staleEntry struct {
+	Rule   string
+	Op     planOp
+	Reason string
+}
+
+// planApplier executes a saved plan. In dry-run mode it remembers the destinations it
+// would have moved files to, so later deletes of those files are not reported as stale.
+// Bundles it (would have) written are remembered too, since their size changes.
+type planApplier struct {
+	logger  *AppLogger
+	dryRun  bool
+	pending map[string]bool
+	bundled map[string]bool
+	stale   []staleEntry
+}
+
+// applyPlan executes the operations of plan in order and returns the entries it refused.
+func applyPlan(appLogger *AppLogger, plan *runPlan, dryRun bool) []staleEntry {
+	a := &planApplier{logger: appLogger, dryRun: dryRun, pending: make(map[string]bool), bundled: make(map[string]bool)}
+	for i := range plan.Rules {
+		if appLogger.Interrupted() {
+			appLogger.Warn("Interrupted: skipping remaining %d rule(s)", len(plan.Rules)-i)
+			break
+		}
+		a.rule(&plan.Rules[i])
+	}
+	return a.stale
+}
+
+func (a *planApplier) rule(rp *rulePlan) {
+	ruleLog := a.logger.WithRule(rp.Name)
+	ruleLog.StartRule()
+	defer ruleLog.FinishRule()
+
+	for _, e := range rp.Errors {
+		ruleLog.Warn("plan recorded a problem for this rule: %s", e)
+	}
+	dryRun := a.dryRun || rp.DryRun
+	// Consecutive "bundle" entries for the same archive are written in one pass.
+	var batch []*planOp
+	flush := func() {
+		if len(batch) > 0 {
+			a.bundle(ruleLog, batch, dryRun)
+			batch = nil
+		}
+	}
+	for i := 0; i < len(rp.Ops); i++ {
+		if ruleLog.Interrupted() {
+			ruleLog.CountSkipped(len(rp.Ops) - i + len(batch))
+			return
+		}
+		op := &rp.Ops[i]
+		// A file and its companions are applied, or skipped, as one unit.
+		group := []*planOp{op}
+		for j := i + 1; op.Op == "move" && j < len(rp.Ops) && rp.Ops[j].CompanionOf == op.Src; j++ {
+			group = append(group, &rp.Ops[j])
+		}
+		i += len(group) - 1
+		if a.staleGroup(ruleLog, rp.Name, group) {
+			continue
+		}
+		if op.Op == "bundle" {
+			if len(batch) > 0 && batch[0].Dst != op.Dst {
+				flush()
+			}
+			batch = append(batch, op)
+			continue
+		}
+		flush()
+		for j, op := range group {
+			if !a.apply(ruleLog, op, dryRun) {
+				// Like moveFile, keep the companions of a file that failed in the input.
+				ruleLog.CountSkipped(len(group) - j - 1)
+				break
+			}
+		}
+	}
+	flush()
+}
+
+// staleGroup records the ops of group as stale if any of them is, and reports whether
+// it did.
+func (a *planApplier) staleGroup(ruleLog *AppLogger, rule string, group []*planOp) bool {
+	var stale *planOp
+	var reason string
+	for _, op := range group {
+		if reason = a.check(op); reason != "" {
+			stale = op
+			break
+		}
+	}
+	if stale == nil {
+		return false
+	}
+	for _, op := range group {
+		why := reason
+		if op != stale {
+			why = "goes with " + filepath.Base(stale.Src) + ": " + reason
+		}
+		a.stale = append(a.stale, staleEntry{Rule: rule, Op: *op, Reason: why})
+		ruleLog.CountSkipped(1)
+		ruleLog.WarnAttrs("stale plan entry skipped", slog.String("op", op.Op), srcAttr(op.Src), dstAttr(op.Dst), slog.String("reason", why))
+	}
+	return true
+}
+
+// bundle packs the sources of ops, which share a destination archive, into it.
+func (a *planApplier) bundle(ruleLog *AppLogger, ops []*planOp, dryRun bool) {
+	g := &bundleGroup{path: ops[0].Dst, target: ops[0].Target, sentinel: ops[0].Sentinel}
+	for _, op := range ops {
+		fi, err := os.Stat(op.Src)
+		if err != nil {
+			ruleLog.CountFailed(op.Target)
+			ruleLog.ErrorAttrs("failed to stat file", srcAttr(op.Src), errAttr(err))
+			continue
+		}
+		g.files = append(g.files, bundleFile{src: op.Src, name: filepath.Base(op.Src), fi: fi})
+	}
+	if len(g.files) == 0 {
+		return
+	}
+	dirs, err := ops[0].dirs(ruleLog.rule)
+	if err != nil {
+		ruleLog.CountFailed(g.target)
+		ruleLog.ErrorAttrs("mkdir failed", dstAttr(filepath.Dir(g.path)), errAttr(err))
+		return
+	}
+	a.bundled[g.path] = true
+	commitBundle(ruleLog, g, ops[0].Bundle, dirs, dryRun, nil)
+}
+
+// download stages the remote file of a "download" op, with the same state tracking as a
+// run of the rule.
+func (a *planApplier) download(ruleLog *AppLogger, op *planOp) {
+	staging := filepath.Dir(op.Dst)
+	st, err := storageFor(op.Src)
+	var fi os.FileInfo
+	if err == nil {
+		fi, err = st.Stat(op.Src)
+	}
+	if err == nil {
+		err = os.MkdirAll(staging, 0755)
+	}
+	var state *fetchState
+	if err == nil {
+		state, err = loadFetchState(staging)
+	}
+	if err != nil {
+		ruleLog.CountFailed(op.Target)
+		ruleLog.ErrorAttrs("download failed", srcAttr(op.Src), dstAttr(op.Dst), errAttr(err))
+		return
+	}
+	fetchFile(ruleLog, st, state, staging, remoteFile{loc: op.Src, fi: fi}, op.DeleteRemote)
+}
+
+// check returns why op can no longer be applied as planned, or "".
+func (a *planApplier) check(op *planOp) string {
+	isDir := func(p string) bool {
+		if isRemote(p) {
+			_, err := storageFor(p)
+			return err == nil
+		}
+		fi, err := os.Stat(p)
+		return (err == nil && fi.IsDir()) || a.pending[p]
+	}
+	source := func() string {
+		stat := lstatLoc
+		if op.Follow {
+			stat = os.Stat
+		}
+		fi, err := stat(op.Src)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && a.pending[op.Src]:
+			return ""
+		case err != nil:
+			return "source is gone"
+		case !op.Source.matches(fi):
+			return "source changed since planning"
+		}
+		return ""
+	}
+
+	switch op.Op {
+	case "mkdir":
+		if op.Target != "" && !isDir(op.Target) {
+			return "output root is missing"
+		}
+		if op.Target == "" && !isDir(filepath.Dir(op.Dst)) {
+			return "parent directory is missing"
+		}
+	case "download":
+		if reason := source(); reason != "" {
+			return reason
+		}
+		if _, err := os.Lstat(op.Dst); err == nil && op.Conflict == "" {
+			return "destination appeared since planning"
+		}
+	case "move":
+		if !isDir(op.Target) {
+			return "output root is missing"
+		}
+		if reason := source(); reason != "" {
+			return reason
+		}
+		if _, err := lstatLoc(op.Dst); err == nil && op.Conflict == "" {
+			return "destination appeared since planning"
+		}
+	case "bundle":
+		if !isDir(op.Target) {
+			return "output root is missing"
+		}
+		if op.Bundle == nil {
+			return "bundle format is missing"
+		}
+		return source()
+	case "delete":
+		if root, err := filepath.Abs(op.Target); err == nil && !isRemote(op.Target) && filepath.Dir(root) == root {
+			return "refusing to delete from a filesystem root"
+		}
+		if a.bundled[op.Src] {
+			return ""
+		}
+		return source()
+	default:
+		return fmt.Sprintf("unknown operation %q", op.Op)
+	}
+	return ""
+}
+
+// apply applies op and reports whether it succeeded.
+func (a *planApplier) apply(ruleLog *AppLogger, op *planOp, dryRun bool) bool {
+	switch op.Op {
+	case "mkdir":
+		if dryRun {
+			a.pending[op.Dst] = true
+			ruleLog.InfoAttrs("[DRY-RUN] Would create folder", dstAttr(op.Dst))
+			return true
+		}
+		dirs, err := op.dirs(ruleLog.rule)
+		if err == nil {
+			// check made sure an output root's parent exists, so this creates only the
+			// last component, like processFolder.
+			err = makeDirs(op.Dst, dirs)
+		}
+		if err != nil {
+			ruleLog.CountFailed(op.Target)
+			ruleLog.ErrorAttrs("mkdir failed", dstAttr(op.Dst), errAttr(err))
+			return false
+		}
+		ruleLog.DebugAttrs("Created folder", dstAttr(op.Dst))
+
+	case "download":
+		if dryRun {
+			a.pending[op.Dst] = true
+			ruleLog.InfoAttrs("[DRY-RUN] Would download", srcAttr(op.Src), dstAttr(op.Dst), bytesAttr(op.size()))
+			return true
+		}
+		a.download(ruleLog, op)
+		return true
+
+	case "move":
+		if dryRun {
+			a.pending[op.Dst] = true
+			ruleLog.CountMoved(op.Target, op.size())
+			ruleLog.InfoAttrs("[DRY-RUN] Would "+op.action(), srcAttr(op.Src), dstAttr(op.Dst), bytesAttr(op.size()))
+			return true
+		}
+		if op.Action != "" {
+			return a.place(ruleLog, op)
+		}
+		if isRemote(op.Dst) {
+			if err := uploadMove(op.Src, op.Dst, op.Compress, op.Encrypt); err != nil {
+				ruleLog.CountFailed(op.Target)
+				ruleLog.ErrorAttrs("upload failed", srcAttr(op.Src), dstAttr(op.Dst), errAttr(err))
+				return false
+			}
+		} else if what, err := op.writeLocal(ruleLog.rule); err != nil {
+			ruleLog.CountFailed(op.Target)
+			ruleLog.ErrorAttrs(what, srcAttr(op.Src), dstAttr(op.Dst), errAttr(err))
+			return false
+		}
+		journal.record(ruleLog.rule, op.Src, op.Dst, op.Compress, op.Encrypt != nil)
+		removeSentinels(op.Src, op.Sentinel)
+		ruleLog.CountMoved(op.Target, op.size())
+		ruleLog.DebugAttrs("Moved", srcAttr(op.Src), dstAttr(op.Dst), bytesAttr(op.size()))
+
+	case "delete":
+		if dryRun {
+			ruleLog.CountDeleted(op.Target, op.size())
+			ruleLog.InfoAttrs("[DRY-RUN] Would delete", srcAttr(op.Src), bytesAttr(op.size()))
+			return true
+		}
+		st, err := storageFor(op.Src)
+		if err == nil {
+			err = st.Delete(op.Src)
+		}
+		if err != nil {
+			ruleLog.CountFailed(op.Target)
+			ruleLog.ErrorAttrs("delete failed", srcAttr(op.Src), errAttr(err))
+			return false
+		}
+		if !isRemote(op.Src) {
+			removeBundleIndex(op.Src)
+			if err := removeFromManifest(op.Src); err != nil {
+				ruleLog.WarnAttrs("manifest not updated", srcAttr(op.Src), errAttr(err))
+			}
+		}
+		ruleLog.CountDeleted(op.Target, op.size())
+		ruleLog.InfoAttrs("Deleted", srcAttr(op.Src), bytesAttr(op.size()))
+	}
+	return true
+}
+
+// printStale lists the plan entries apply refused.
+func printStale(w io.Writer, stale []staleEntry) {
+	if len(stale) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Stale plan entries skipped (%d):\n", len(stale))
+	for _, s := range stale {
+		path := s.Op.Src
+		if path == "" {
+			path = s.Op.Dst
+		}
+		fmt.Fprintf(w, "  %s: %s %s: %s\n", s.Rule, s.Op.Op, path, s.Reason)
+	}
+}
+
+// place applies a move op of a copy or link action, which leaves Src in the input, and
+// reports whether it succeeded.
+func (a *planApplier) place(ruleLog *AppLogger, op *planOp) bool {
+	var what string
+	var err error
+	if isRemote(op.Dst) {
+		what, err = "upload failed", uploadFile(op.Src, op.Dst, op.Compress, op.Encrypt)
+	} else {
+		what, err = op.writeLocal(ruleLog.rule)
+	}
+	if err != nil {
+		ruleLog.CountFailed(op.Target)
+		ruleLog.ErrorAttrs(what, srcAttr(op.Src), dstAttr(op.Dst), errAttr(err))
+		return false
+	}
+	journal.recordAction(ruleLog.rule, op.Action, op.Src, op.Dst, op.Compress, op.Encrypt != nil)
+	ruleLog.CountMoved(op.Target, op.size())
+	ruleLog.DebugAttrs("Placed "+op.Action, srcAttr(op.Src), dstAttr(op.Dst), bytesAttr(op.size()))
+	return true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApplyPlan(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	if err := os.MkdirAll(in, 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().AddDate(0, 0, -100)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		p := filepath.Join(in, name)
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if name == "c.txt" {
+			if err := os.Chtimes(p, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	folders := []folder{{Name: "Archive", Input: in, Output: []string{out}, Extension: ".txt", FolderType: "4", DeleteOlderThan: 30}}
+
+	planPath := filepath.Join(dir, "plan.json")
+	if err := savePlan(planPath, buildPlan(folders)); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := loadPlan(planPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry-run apply changes nothing and finds nothing stale, including the
+	// delete of c.txt after its simulated move.
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	if stale := applyPlan(al, plan, true); len(stale) != 0 {
+		t.Fatalf("dry-run stale = %+v", stale)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatal("dry-run apply created the output directory")
+	}
+
+	// b.txt changes after planning and must be left alone.
+	if err := os.WriteFile(filepath.Join(in, "b.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	al = newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	stale := applyPlan(al, plan, false)
+	if len(stale) != 1 || stale[0].Op.Src != filepath.Join(in, "b.txt") || stale[0].Reason != "source changed since planning" {
+		t.Fatalf("stale = %+v", stale)
+	}
+	if _, err := os.Stat(filepath.Join(out, "a.txt")); err != nil {
+		t.Errorf("a.txt not moved: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(in, "b.txt")); string(data) != "changed" {
+		t.Errorf("b.txt touched: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(out, "c.txt")); !os.IsNotExist(err) {
+		t.Errorf("old c.txt not deleted after move: %v", err)
+	}
+	rules, _ := al.counters.stats.snapshot()
+	if c := rules[0].countsSnapshot; c.Moved != 2 || c.Deleted != 1 || c.Skipped != 1 {
+		t.Errorf("counts = %+v", c)
+	}
+
+	// Applying the same plan again finds every entry stale.
+	al = newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	if stale := applyPlan(al, plan, false); len(stale) != 4 {
+		t.Errorf("re-apply stale = %d entries, want 4", len(stale))
+	}
+}
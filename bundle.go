@@ -0,0 +1,785 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// bundleOptions makes a rule pack its matched files into one archive per output folder
+// instead of moving them one by one. In config it is either a shorthand ("zip", "tar",
+// "tar.gz", "tar.zst", "tar.xz") or {"format": "tar", "compress": ...}.
+type bundleOptions struct {
+	Format   string           `json:"format"`             // "tar" or "zip"
+	Compress *compressOptions `json:"compress,omitempty"` // tar only
+}
+
+// bundleIndexSuffix names the member index written next to each bundle.
+const bundleIndexSuffix = ".index.jsonl"
+
+func (b *bundleOptions) UnmarshalJSON(data []byte) error {
+	var shorthand string
+	if err := json.Unmarshal(data, &shorthand); err == nil {
+		parsed := bundleOptionsFor("." + shorthand)
+		if parsed == nil {
+			return fmt.Errorf("unknown bundle format %q", shorthand)
+		}
+		*b = *parsed
+		return nil
+	}
+	type plain bundleOptions
+	return json.Unmarshal(data, (*plain)(b))
+}
+
+func (b *bundleOptions) validate() error {
+	switch b.Format {
+	case "tar":
+		if b.Compress != nil {
+			return b.Compress.validate()
+		}
+	case "zip":
+		if b.Compress != nil {
+			return errors.New("bundle: zip archives are always deflated; compress is for tar only")
+		}
+	default:
+		return fmt.Errorf("bundle format %q: must be tar or zip", b.Format)
+	}
+	return nil
+}
+
+func (b *bundleOptions) String() string {
+	if b.Compress == nil {
+		return b.Format
+	}
+	return "tar, " + b.Compress.String()
+}
+
+// ext returns the archive suffix, e.g. ".tar.zst".
+func (b *bundleOptions) ext() string {
+	if b.Format == "zip" {
+		return ".zip"
+	}
+	return ".tar" + b.Compress.ext()
+}
+
+// bundleOptionsFor returns the bundle format of an archive by its suffix, or nil if the
+// name is not a bundle.
+func bundleOptionsFor(path string) *bundleOptions {
+	if strings.HasSuffix(path, ".zip") {
+		return &bundleOptions{Format: "zip"}
+	}
+	b := &bundleOptions{Format: "tar"}
+	if format := formatOfCompressed(path); format != "" {
+		b.Compress = &compressOptions{Format: format}
+		path = strings.TrimSuffix(path, compressFormats[format].ext)
+	}
+	if !strings.HasSuffix(path, ".tar") {
+		return nil
+	}
+	return b
+}
+
+// bundlePath names the archive that stands in for the folder rel (as computed by
+// outputFolder) under the output root out: "2024/1/Day 2" becomes "2024/1/Day 2.log.tar.zst".
+// Folder types that put files at the root are bundled under the rule name. The rule's
+// extension stays in the name so retention can tell whose bundle it is.
+func bundlePath(out, rel string, f *folder) string {
+	dir := filepath.Join(out, rel)
+	if dir == filepath.Clean(out) {
+		dir = filepath.Join(out, f.Name)
+	}
+	return dir + f.Extension + f.Bundle.ext()
+}
+
+// bundleMember is one file inside a bundle, as listed in its index.
+type bundleMember struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// bundleWriter writes members to a tar (optionally compressed) or zip stream.
+type bundleWriter struct {
+	tw      *tar.Writer
+	zw      *zip.Writer
+	cw      io.WriteCloser // compressor under tw, if any
+	w       io.Writer      // the stream under tw and cw
+	trailer []byte         // written by Close; see tarTrailer
+}
+
+func newBundleWriter(w io.Writer, b *bundleOptions) (*bundleWriter, error) {
+	if b.Format == "zip" {
+		return &bundleWriter{zw: zip.NewWriter(w)}, nil
+	}
+	trailer, err := tarTrailer(b.Compress)
+	if err != nil {
+		return nil, err
+	}
+	bw := &bundleWriter{w: w, trailer: trailer}
+	if b.Compress != nil {
+		cw, err := b.Compress.writer(w)
+		if err != nil {
+			return nil, err
+		}
+		bw.cw, w = cw, cw
+	}
+	bw.tw = tar.NewWriter(w)
+	return bw, nil
+}
+
+// tarTrailer returns the bytes a tar bundle ends with: the two zero blocks that end a tar
+// archive, compressed in a frame of their own if the bundle is compressed. gzip, zstd and
+// xz read concatenated frames as one stream, so an append can cut the trailer off, write
+// the new members in a new frame and put the trailer back after them.
+func tarTrailer(c *compressOptions) ([]byte, error) {
+	zeros := make([]byte, 2*512)
+	if c == nil {
+		return zeros, nil
+	}
+	var buf bytes.Buffer
+	cw, err := c.writer(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cw.Write(zeros); err != nil {
+		return nil, err
+	}
+	if err := cw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// add writes m.Size bytes of r as member m and returns their SHA-256.
+func (bw *bundleWriter) add(m bundleMember, mode os.FileMode, r io.Reader) (string, error) {
+	var w io.Writer
+	if bw.zw != nil {
+		hdr := &zip.FileHeader{Name: m.Name, Method: zip.Deflate, Modified: m.ModTime}
+		hdr.SetMode(mode.Perm())
+		zf, err := bw.zw.CreateHeader(hdr)
+		if err != nil {
+			return "", err
+		}
+		w = zf
+	} else {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: m.Name, Size: m.Size, Mode: int64(mode.Perm()), ModTime: m.ModTime}
+		if err := bw.tw.WriteHeader(hdr); err != nil {
+			return "", err
+		}
+		w = bw.tw
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(w, h), r, m.Size); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Close ends the archive. The members of a tar bundle are flushed, and their compressed
+// frame closed, before the trailer is written.
+func (bw *bundleWriter) Close() error {
+	if bw.zw != nil {
+		return bw.zw.Close()
+	}
+	if err := bw.tw.Flush(); err != nil {
+		return err
+	}
+	if bw.cw != nil {
+		if err := bw.cw.Close(); err != nil {
+			return err
+		}
+	}
+	_, err := bw.w.Write(bw.trailer)
+	return err
+}
+
+// memberFunc is called with each member read from a bundle and a reader of its content.
+type memberFunc func(m bundleMember, mode os.FileMode, r io.Reader) error
+
+// readBundle calls fn for each regular member of the bundle at path, in archive order.
+func readBundle(path string, b *bundleOptions, fn memberFunc) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if b.Format == "zip" {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, fi.Size())
+		if err != nil {
+			return err
+		}
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(bundleMember{Name: zf.Name, Size: int64(zf.UncompressedSize64), ModTime: zf.Modified}, zf.Mode(), rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return readTar(f, b.Compress, fn)
+}
+
+// readTar calls fn for each regular member of the tar stream r, compressed with c if set.
+func readTar(r io.Reader, c *compressOptions, fn memberFunc) error {
+	if c != nil {
+		dr, err := decompressReader(c.Format, r)
+		if err != nil {
+			return err
+		}
+		defer dr.Close()
+		r = dr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(bundleMember{Name: hdr.Name, Size: hdr.Size, ModTime: hdr.ModTime}, hdr.FileInfo().Mode(), tr); err != nil {
+			return err
+		}
+	}
+}
+
+// bundleFile is a matched input file on its way into a bundle.
+type bundleFile struct {
+	src  string
+	name string
+	fi   os.FileInfo
+}
+
+// writeBundle adds files to the bundle at path, creating it if needed, and updates its
+// index. Sources are left alone. A tar bundle is appended to in place; a zip bundle, or a
+// tar bundle that cannot be appended to, is rewritten.
+func writeBundle(path string, b *bundleOptions, files []bundleFile) error {
+	if b.Format == "tar" {
+		if index, err := readBundleIndex(path); err == nil {
+			if err := appendBundle(path, b, index, files); !errors.Is(err, errNotAppendable) {
+				return err
+			}
+		}
+	}
+	return rewriteBundle(path, b, files)
+}
+
+// errNotAppendable is returned by appendBundle for a bundle it has to leave to rewriteBundle.
+var errNotAppendable = errors.New("bundle cannot be appended to in place")
+
+// appendBundle adds files to the tar bundle at path, whose index lists index, without
+// rewriting its members. The new members are written over the trailer and followed by a
+// new one; a file with the same name as a member is added again and wins on extraction.
+// Only the appended part is read back and checked against the hashes taken while
+// writing. If anything fails, the bundle is cut back to its old end.
+func appendBundle(path string, b *bundleOptions, index []bundleMember, files []bundleFile) (err error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errNotAppendable, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	trailer, err := tarTrailer(b.Compress)
+	if err != nil {
+		return err
+	}
+	at, err := trailerOffset(f, fi.Size(), b, trailer, index)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if terr := f.Truncate(at); terr == nil {
+				f.WriteAt(trailer, at)
+			}
+			os.Chtimes(path, fi.ModTime(), fi.ModTime())
+		}
+	}()
+
+	if _, err := f.Seek(at, io.SeekStart); err != nil {
+		return err
+	}
+	bw, err := newBundleWriter(f, b)
+	if err != nil {
+		return err
+	}
+	added, err := addFiles(bw, files)
+	if err != nil {
+		return err
+	}
+	if err := bw.Close(); err != nil {
+		return err
+	}
+	end, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(end); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	appended := io.NewSectionReader(f, at, end-at)
+	if err := verifyMembers(func(fn memberFunc) error { return readTar(appended, b.Compress, fn) }, added); err != nil {
+		return err
+	}
+	index = latestMembers(append(index, added...))
+	if err := os.Chtimes(path, newestMember(index), newestMember(index)); err != nil {
+		return err
+	}
+	return writeBundleIndex(path, index)
+}
+
+// trailerOffset returns where the trailer of the tar bundle f, of the given size, starts.
+// A plain tar is read header by header, seeking over member data, and its members must be
+// the ones in index. A compressed tar cannot be read without decompressing all of it, so
+// it must end with trailer as this writer writes it; one written by another tool or with
+// other compress settings does not.
+func trailerOffset(f *os.File, size int64, b *bundleOptions, trailer []byte, index []bundleMember) (int64, error) {
+	if b.Compress != nil {
+		at := size - int64(len(trailer))
+		if at < 0 {
+			return 0, fmt.Errorf("%w: too short", errNotAppendable)
+		}
+		end := make([]byte, len(trailer))
+		if _, err := f.ReadAt(end, at); err != nil {
+			return 0, err
+		}
+		if !bytes.Equal(end, trailer) {
+			return 0, fmt.Errorf("%w: it does not end with a %s trailer", errNotAppendable, b)
+		}
+		return at, nil
+	}
+
+	var at int64
+	names := make(map[string]bool, len(index))
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", errNotAppendable, err)
+		}
+		if hdr.Typeflag == tar.TypeGNUSparse || hdr.PAXRecords["GNU.sparse.major"] != "" {
+			return 0, fmt.Errorf("%w: sparse member %q", errNotAppendable, hdr.Name)
+		}
+		pos, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		at = pos + (hdr.Size+511)/512*512
+		if hdr.Typeflag == tar.TypeReg {
+			names[hdr.Name] = true
+		}
+	}
+	if len(names) != len(index) {
+		return 0, fmt.Errorf("%w: %d members, %d in the index", errNotAppendable, len(names), len(index))
+	}
+	for _, m := range index {
+		if !names[m.Name] {
+			return 0, fmt.Errorf("%w: %q is in the index but not in the bundle", errNotAppendable, m.Name)
+		}
+	}
+	return at, nil
+}
+
+// rewriteBundle writes the bundle at path anew with its existing members and files.
+// Members are carried over, except those replaced by a file of the same name. The new
+// archive is written to a temp file, read back and checked against the hashes taken while
+// writing, and only then renamed over path.
+func rewriteBundle(path string, b *bundleOptions, files []bundleFile) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	bw, err := newBundleWriter(tmp, b)
+	if err != nil {
+		return err
+	}
+
+	adding := make(map[string]bool, len(files))
+	for _, f := range files {
+		adding[f.name] = true
+	}
+	var written []bundleMember
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+		err := readBundle(path, b, func(m bundleMember, memberMode os.FileMode, r io.Reader) error {
+			if adding[m.Name] {
+				return nil
+			}
+			sum, err := bw.add(m, memberMode, r)
+			m.SHA256 = sum
+			written = append(written, m)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("read existing bundle: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	added, err := addFiles(bw, files)
+	if err != nil {
+		return err
+	}
+	written = append(written, added...)
+
+	if err := bw.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := verifyMembers(func(fn memberFunc) error { return readBundle(tmp.Name(), b, fn) }, written); err != nil {
+		return err
+	}
+	index := latestMembers(written)
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), newestMember(index), newestMember(index)); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	ok = true
+	return writeBundleIndex(path, index)
+}
+
+// addFiles writes files to bw and returns them as members.
+func addFiles(bw *bundleWriter, files []bundleFile) ([]bundleMember, error) {
+	added := make([]bundleMember, 0, len(files))
+	for _, f := range files {
+		in, err := os.Open(f.src)
+		if err != nil {
+			return nil, err
+		}
+		m := bundleMember{Name: f.name, Size: f.fi.Size(), ModTime: f.fi.ModTime()}
+		m.SHA256, err = bw.add(m, f.fi.Mode(), in)
+		in.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.src, err)
+		}
+		added = append(added, m)
+	}
+	return added, nil
+}
+
+// latestMembers drops the members that a later one of the same name replaces.
+func latestMembers(ms []bundleMember) []bundleMember {
+	last := make(map[string]int, len(ms))
+	for i, m := range ms {
+		last[m.Name] = i
+	}
+	var kept []bundleMember
+	for i, m := range ms {
+		if last[m.Name] == i {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// newestMember returns the mtime of the newest member. A bundle gets it as its own mtime,
+// so retention ages a bundle by its most recent content.
+func newestMember(index []bundleMember) time.Time {
+	var newest time.Time
+	for _, m := range index {
+		if m.ModTime.After(newest) {
+			newest = m.ModTime
+		}
+	}
+	return newest
+}
+
+// verifyMembers checks that each, which reads members like readBundle, yields exactly want.
+func verifyMembers(each func(fn memberFunc) error, want []bundleMember) error {
+	i := 0
+	err := each(func(m bundleMember, _ os.FileMode, r io.Reader) error {
+		if i >= len(want) || m.Name != want[i].Name {
+			return fmt.Errorf("%w: unexpected member %q", errChecksumMismatch, m.Name)
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return err
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != want[i].SHA256 {
+			return fmt.Errorf("%w: member %s: wrote %s, read back %s", errChecksumMismatch, m.Name, want[i].SHA256, got)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if i != len(want) {
+		return fmt.Errorf("%w: %d of %d members read back", errChecksumMismatch, i, len(want))
+	}
+	return nil
+}
+
+// readBundleIndex returns the members listed in the index next to the bundle at path.
+func readBundleIndex(path string) ([]bundleMember, error) {
+	f, err := os.Open(path + bundleIndexSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var index []bundleMember
+	dec := json.NewDecoder(f)
+	for {
+		var m bundleMember
+		if err := dec.Decode(&m); err == io.EOF {
+			return index, nil
+		} else if err != nil {
+			return nil, err
+		}
+		index = append(index, m)
+	}
+}
+
+// writeBundleIndex replaces the index next to the bundle at path with one JSON line per member.
+func writeBundleIndex(path string, index []bundleMember) error {
+	var sb strings.Builder
+	enc := json.NewEncoder(&sb)
+	for _, m := range index {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	tmp := path + bundleIndexSuffix + ".tmp"
+	if err := os.WriteFile(tmp, []byte(sb.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path+bundleIndexSuffix)
+}
+
+// removeBundleIndex deletes the index of path, if path was a bundle.
+func removeBundleIndex(path string) {
+	if bundleOptionsFor(path) != nil {
+		_ = os.Remove(path + bundleIndexSuffix)
+	}
+}
+
+// extractMember restores member of the bundle at path to dst with its mode and mtime.
+// The bundle itself is not changed.
+func extractMember(path, member, dst string) error {
+	b := bundleOptionsFor(path)
+	if b == nil {
+		return fmt.Errorf("%s is not a bundle", path)
+	}
+	found := false
+	err := readBundle(path, b, func(m bundleMember, mode os.FileMode, r io.Reader) error {
+		if m.Name != member {
+			return nil
+		}
+		found = true
+		tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(tmp, r); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+		if err := os.Chmod(tmp.Name(), mode.Perm()); err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+		if err := os.Chtimes(tmp.Name(), m.ModTime, m.ModTime); err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+		return os.Rename(tmp.Name(), dst)
+	})
+	if err == nil && !found {
+		err = fmt.Errorf("%w: %s has no member %q", os.ErrNotExist, path, member)
+	}
+	return err
+}
+
+// bundleGroup is the files of one rule pass that go into the same bundle.
+type bundleGroup struct {
+	path     string // the archive
+	target   string // output root it is on
+	files    []bundleFile
+	sentinel string // marker suffix removed with each packed file; see ready.go
+}
+
+// groupBundles assigns files to bundles by the folder createOutputPath would have put
+// them in. A bundle that already exists on one of the outputs is appended to; new
+// bundles get an output from the balancer.
+func groupBundles(b *Balancer, f *folder, files []bundleFile) ([]*bundleGroup, error) {
+	byFolder := make(map[string]*bundleGroup)
+	var groups []*bundleGroup
+	for _, bf := range files {
+		rel := outputFolder("", bf.fi, f.FolderType)
+		g := byFolder[rel]
+		if g == nil {
+			g = &bundleGroup{}
+			for _, out := range f.Output {
+				if p := bundlePath(out, rel, f); fileExists(p) {
+					g.path, g.target = p, out
+					break
+				}
+			}
+			if g.path == "" {
+				target, err := b.Next(f.Output)
+				if err != nil {
+					return nil, err
+				}
+				g.path, g.target = bundlePath(target, rel, f), target
+			}
+			byFolder[rel] = g
+			groups = append(groups, g)
+		}
+		g.files = append(g.files, bf)
+	}
+	return groups, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// bundleFiles packs a rule's matched files into bundles instead of moving them one by one.
+func bundleFiles(appLogger *AppLogger, b *Balancer, f *folder, dirs dirSettings, names []string, localDryRun bool) {
+	appLogger.InfoAttrs("Bundling files", slog.Int("files", len(names)), slog.String("bundle", f.Bundle.String()), slog.Bool("dryRun", localDryRun))
+	prog := startProgress(appLogger, len(names))
+	defer prog.finish()
+
+	files := make([]bundleFile, 0, len(names))
+	for _, name := range names {
+		src := filepath.Join(f.Input, name)
+		fi, err := os.Stat(src)
+		if err != nil {
+			appLogger.CountFailed("")
+			appLogger.ErrorAttrs("failed to stat file", srcAttr(src), errAttr(err))
+			prog.add(0)
+			continue
+		}
+		files = append(files, bundleFile{src: src, name: name, fi: fi})
+	}
+	groups, err := groupBundles(b, f, files)
+	if err != nil {
+		appLogger.CountFailed("")
+		appLogger.ErrorAttrs("Balancer error", srcAttr(f.Input), errAttr(err))
+		return
+	}
+	for i, g := range groups {
+		g.sentinel = f.Ready.sentinel()
+		if appLogger.Interrupted() {
+			for _, rest := range groups[i:] {
+				appLogger.CountSkipped(len(rest.files))
+			}
+			return
+		}
+		commitBundle(appLogger, g, f.Bundle, dirs, localDryRun, prog)
+	}
+}
+
+// commitBundle writes g's files into its bundle, then removes the sources that did not
+// change while they were being read. Changed sources stay for the next run.
+func commitBundle(appLogger *AppLogger, g *bundleGroup, opts *bundleOptions, dirs dirSettings, localDryRun bool, prog *progress) {
+	if localDryRun {
+		for _, bf := range g.files {
+			appLogger.CountMoved(g.target, bf.fi.Size())
+			appLogger.InfoAttrs("[DRY-RUN] Would bundle", srcAttr(bf.src), dstAttr(g.path), bytesAttr(bf.fi.Size()))
+			prog.add(bf.fi.Size())
+		}
+		return
+	}
+
+	fail := func(msg string, err error) {
+		for range g.files {
+			appLogger.CountFailed(g.target)
+			prog.add(0)
+		}
+		appLogger.ErrorAttrs(msg, dstAttr(g.path), slog.Int("files", len(g.files)), errAttr(err))
+	}
+	if err := makeDirs(filepath.Dir(g.path), dirs); err != nil {
+		fail("mkdir failed", err)
+		return
+	}
+	start := time.Now()
+	if err := writeBundle(g.path, opts, g.files); err != nil {
+		fail("bundle failed", err)
+		return
+	}
+
+	for _, bf := range g.files {
+		size := bf.fi.Size()
+		if fi, err := os.Lstat(bf.src); err != nil || !fingerprintOf(bf.fi).matches(fi) {
+			appLogger.CountSkipped(1)
+			appLogger.WarnAttrs("source changed while bundling, keeping it", srcAttr(bf.src), dstAttr(g.path))
+			prog.add(0)
+			continue
+		}
+		if err := os.Remove(bf.src); err != nil {
+			appLogger.CountFailed(g.target)
+			appLogger.ErrorAttrs("remove after bundling failed", srcAttr(bf.src), errAttr(err))
+			prog.add(0)
+			continue
+		}
+		journal.recordMember(appLogger.rule, bf.src, g.path, bf.name)
+		removeSentinels(bf.src, g.sentinel)
+		appLogger.CountMoved(g.target, size)
+		prog.add(size)
+	}
+	appLogger.DebugAttrs("Bundled", dstAttr(g.path), slog.Int("files", len(g.files)), durationAttr(time.Since(start)))
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// writeAged creates dir/name with content and the given mtime.
+func writeAged(t *testing.T, dir, name, content string, mtime time.Time) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// bundleContents returns the members of a bundle and their content.
+func bundleContents(t *testing.T, path string) map[string]string {
+	t.Helper()
+	got := map[string]string{}
+	err := readBundle(path, bundleOptionsFor(path), func(m bundleMember, _ os.FileMode, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		got[m.Name] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return got
+}
+
+func readIndex(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path + bundleIndexSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var m bundleMember
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.SHA256 == "" || m.ModTime.IsZero() {
+			t.Errorf("incomplete index entry %+v", m)
+		}
+		names = append(names, m.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestBundleRulePacksAndAppends(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jan := time.Date(2024, 1, 10, 8, 0, 0, 0, time.Local)
+	feb := time.Date(2024, 2, 3, 8, 0, 0, 0, time.Local)
+	writeAged(t, in, "a.log", "a1", jan)
+	writeAged(t, in, "b.log", "b1", jan.Add(time.Hour))
+	writeAged(t, in, "c.log", "c1", feb)
+
+	rule := folder{Name: "Logs", Input: in, Output: []string{out}, Extension: ".log", FolderType: "5",
+		Bundle: &bundleOptions{Format: "tar", Compress: &compressOptions{Format: "zstd"}}}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	processFolder(al, &Balancer{}, &rule)
+	if n := al.counters.errorsCount.Load(); n != 0 {
+		t.Fatalf("%d errors while bundling", n)
+	}
+
+	janBundle := filepath.Join(out, "202401.log.tar.zst")
+	febBundle := filepath.Join(out, "202402.log.tar.zst")
+	if got := bundleContents(t, janBundle); len(got) != 2 || got["a.log"] != "a1" || got["b.log"] != "b1" {
+		t.Errorf("January bundle = %v", got)
+	}
+	if got := bundleContents(t, febBundle); len(got) != 1 || got["c.log"] != "c1" {
+		t.Errorf("February bundle = %v", got)
+	}
+	if fi, err := os.Stat(janBundle); err != nil || !fi.ModTime().Equal(jan.Add(time.Hour)) {
+		t.Errorf("bundle mtime = %v, %v; want newest member %v", fi.ModTime(), err, jan.Add(time.Hour))
+	}
+	if left, _ := os.ReadDir(in); len(left) != 0 {
+		t.Errorf("sources left in input: %d", len(left))
+	}
+
+	// A second run appends, replacing members of the same name.
+	writeAged(t, in, "a.log", "a2", jan.Add(2*time.Hour))
+	writeAged(t, in, "d.log", "d1", jan)
+	processFolder(al, &Balancer{}, &rule)
+	got := bundleContents(t, janBundle)
+	if len(got) != 3 || got["a.log"] != "a2" || got["b.log"] != "b1" || got["d.log"] != "d1" {
+		t.Errorf("appended bundle = %v", got)
+	}
+	if names := readIndex(t, janBundle); len(names) != 3 || names[0] != "a.log" || names[2] != "d.log" {
+		t.Errorf("index = %v", names)
+	}
+
+	// Retention deletes bundles by their mtime, along with their index.
+	deleteFiles(out, ".log", true, 30, nil, al, false)
+	for _, p := range []string{janBundle, janBundle + bundleIndexSuffix, febBundle} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s not removed by retention: %v", p, err)
+		}
+	}
+}
+
+func TestBundleAppendsInPlace(t *testing.T) {
+	for _, shorthand := range []string{"tar", "tar.gz", "tar.zst", "tar.xz"} {
+		t.Run(shorthand, func(t *testing.T) {
+			dir := t.TempDir()
+			b := bundleOptionsFor("." + shorthand)
+			path := filepath.Join(dir, "202401.log."+shorthand)
+			day := time.Date(2024, 1, 10, 8, 0, 0, 0, time.Local)
+			bundleFileOf := func(name, content string, mtime time.Time) bundleFile {
+				src := writeAged(t, dir, name, content, mtime)
+				fi, err := os.Stat(src)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return bundleFile{src: src, name: name, fi: fi}
+			}
+
+			if err := writeBundle(path, b, []bundleFile{bundleFileOf("a.log", "a1", day), bundleFileOf("b.log", "b1", day)}); err != nil {
+				t.Fatal(err)
+			}
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trailer, _ := tarTrailer(b.Compress)
+			oldFi, _ := os.Stat(path)
+
+			if err := writeBundle(path, b, []bundleFile{bundleFileOf("a.log", "a2", day.Add(time.Hour)), bundleFileOf("c.log", "c1", day)}); err != nil {
+				t.Fatal(err)
+			}
+			after, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			newFi, _ := os.Stat(path)
+			if !os.SameFile(oldFi, newFi) {
+				t.Error("bundle was replaced, not appended to")
+			}
+			kept := len(before) - len(trailer)
+			if len(after) <= len(before) || !bytes.Equal(after[:kept], before[:kept]) {
+				t.Error("existing members were rewritten")
+			}
+			if !bytes.HasSuffix(after, trailer) {
+				t.Error("appended bundle does not end with a trailer")
+			}
+			if got := bundleContents(t, path); len(got) != 3 || got["a.log"] != "a2" || got["b.log"] != "b1" || got["c.log"] != "c1" {
+				t.Errorf("appended bundle = %v", got)
+			}
+			if names := readIndex(t, path); len(names) != 3 {
+				t.Errorf("index = %v", names)
+			}
+			if !newFi.ModTime().Equal(day.Add(time.Hour)) {
+				t.Errorf("bundle mtime = %v, want newest member %v", newFi.ModTime(), day.Add(time.Hour))
+			}
+		})
+	}
+}
+
+func TestBundleRewrittenWhenNotAppendable(t *testing.T) {
+	dir := t.TempDir()
+	b := bundleOptionsFor(".tar.zst")
+	path := filepath.Join(dir, "202401.log.tar.zst")
+	day := time.Date(2024, 1, 10, 8, 0, 0, 0, time.Local)
+
+	// A bundle whose end-of-archive marker is inside its only frame, as other tools write it.
+	var buf bytes.Buffer
+	cw, err := b.Compress.writer(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(cw)
+	if err := tw.WriteHeader(&tar.Header{Name: "old.log", Size: 3, Mode: 0644, ModTime: day}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte("old"))
+	tw.Close()
+	cw.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeBundleIndex(path, []bundleMember{{Name: "old.log", Size: 3, ModTime: day, SHA256: "x"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	src := writeAged(t, dir, "new.log", "new", day)
+	fi, _ := os.Stat(src)
+	if err := writeBundle(path, b, []bundleFile{{src: src, name: "new.log", fi: fi}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := bundleContents(t, path); len(got) != 2 || got["old.log"] != "old" || got["new.log"] != "new" {
+		t.Errorf("rewritten bundle = %v", got)
+	}
+	trailer, _ := tarTrailer(b.Compress)
+	if after, _ := os.ReadFile(path); !bytes.HasSuffix(after, trailer) {
+		t.Error("rewritten bundle cannot be appended to")
+	}
+}
+
+func TestZipBundleUndo(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtime := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	src := writeAged(t, in, "scan.pdf", "%PDF", mtime)
+
+	stateDir := filepath.Join(dir, "state")
+	j, err := openJournal(stateDir, runInfo{ID: "b1", Start: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal = j
+	defer func() { journal = nil }()
+
+	rule := folder{Name: "Scans", Input: in, Output: []string{out}, Extension: ".pdf", FolderType: "4", Bundle: &bundleOptions{Format: "zip"}}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	processFolder(al, &Balancer{}, &rule)
+	j.Close()
+
+	bundle := filepath.Join(out, "Scans.pdf.zip")
+	if got := bundleContents(t, bundle); got["scan.pdf"] != "%PDF" {
+		t.Fatalf("zip bundle = %v", got)
+	}
+	if restored, skipped := undoJournal(j.path, al, false); restored != 1 || skipped != 0 {
+		t.Fatalf("restored=%d skipped=%d", restored, skipped)
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("restored mtime = %v, want %v", fi.ModTime(), mtime)
+	}
+}
+
+func TestPlanAndApplyBundle(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	day := time.Date(2024, 3, 5, 9, 0, 0, 0, time.Local)
+	writeAged(t, in, "x.csv", "x", day)
+	writeAged(t, in, "y.csv", "y", day)
+
+	rules := []folder{{Name: "Csv", Input: in, Output: []string{out}, Extension: ".csv", FolderType: "1", Bundle: &bundleOptions{Format: "tar"}}}
+	plan := buildPlan(rules)
+	bundle := filepath.Join(out, "2024", "3", "Day 5.csv.tar")
+	var ops []string
+	for _, op := range plan.Rules[0].Ops {
+		ops = append(ops, op.Op+" "+op.Dst)
+	}
+	want := []string{"mkdir " + filepath.Dir(bundle), "bundle " + bundle, "bundle " + bundle}
+	if len(ops) != len(want) {
+		t.Fatalf("ops = %v, want %v", ops, want)
+	}
+	for i := range want {
+		if ops[i] != want[i] {
+			t.Errorf("op %d = %q, want %q", i, ops[i], want[i])
+		}
+	}
+
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	if stale := applyPlan(al, plan, false); len(stale) != 0 {
+		t.Fatalf("stale entries: %+v", stale)
+	}
+	if got := bundleContents(t, bundle); len(got) != 2 || got["x.csv"] != "x" {
+		t.Errorf("applied bundle = %v", got)
+	}
+}
+
+func TestBundleOptionsConfig(t *testing.T) {
+	for shorthand, want := range map[string]string{"zip": ".zip", "tar": ".tar", "tar.gz": ".tar.gz", "tar.zst": ".tar.zst", "tar.xz": ".tar.xz"} {
+		var b bundleOptions
+		if err := json.Unmarshal([]byte(`"`+shorthand+`"`), &b); err != nil || b.ext() != want {
+			t.Errorf("%q: ext %q, %v; want %q", shorthand, b.ext(), err, want)
+		}
+	}
+	var b bundleOptions
+	if err := json.Unmarshal([]byte(`"rar"`), &b); err == nil {
+		t.Error("rar: expected error")
+	}
+	if err := json.Unmarshal([]byte(`{"format":"tar","compress":{"format":"xz","level":9}}`), &b); err != nil || b.validate() != nil || b.Compress.Level != 9 {
+		t.Errorf("object form: %+v, %v", b, err)
+	}
+	zipCompressed := bundleOptions{Format: "zip", Compress: &compressOptions{Format: "gzip"}}
+	if zipCompressed.validate() == nil {
+		t.Error("zip with compress: expected validation error")
+	}
+	err := validateFolders([]folder{{Name: "r", Input: "in", Output: []string{"out"}, FolderType: "4",
+		Compress: &compressOptions{Format: "gzip"}, Bundle: &bundleOptions{Format: "tar"}}})
+	if err == nil {
+		t.Error("compress with bundle: expected validation error")
+	}
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// cliOptions holds the flags shared by every subcommand.
+type cliOptions struct {
+	dryRun       bool
+	watch        bool
+	interval     time.Duration
+	report       string
+	reportFormat string
+	metricsAddr  string
+	metricsFile  string
+	failOnWarn   bool
+	planFormat   string
+	planOut      string
+	interactive  bool
+	progress     string
+	progressInt  time.Duration
+	confirmMoves int
+	stateDir     string
+	exclude      stringList
+	identity     stringList
+	log          logConfig
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// subcommand is one `sloth <name>` entry point. args are the positional arguments after the name.
+type subcommand struct {
+	summary string
+	run     func(opts *cliOptions, args []string) int
+}
+
+var subcommands map[string]subcommand
+
+func init() {
+	subcommands = map[string]subcommand{
+		"run":      {"run all rules, or only those named (default command)", cmdRun},
+		"plan":     {"print every mkdir, move and delete the selected rules would perform", cmdPlan},
+		"apply":    {"execute exactly the operations of a plan saved with 'plan -o'", cmdApply},
+		"validate": {"check the rules file and exit", cmdValidate},
+		"list":     {"print the selected rules with their effective settings", cmdList},
+		"undo":     {"move files from the last run (or the given run id) back to their inputs", cmdUndo},
+		"stats":    {"print the statistics of the last run", cmdStats},
+		"verify":   {"recheck the SHA256SUMS manifests written by rules with verify", cmdVerify},
+		"decrypt":  {"restore .age files written by rules with encrypt", cmdDecrypt},
+		"version":  {"print build information", cmdVersion},
+	}
+}
+
+func newFlagSet(opts *cliOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet("sloth", flag.ContinueOnError)
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "simulate all operations without changing the filesystem")
+	fs.BoolVar(&opts.watch, "watch", false, "keep running and re-run rules every --interval, reloading config on change or SIGHUP")
+	fs.DurationVar(&opts.interval, "interval", 5*time.Minute, "delay between rule passes in --watch mode")
+	fs.StringVar(&configPath, "config", configPath, "path to the rules file")
+	fs.StringVar(&opts.report, "report", "", "write a machine-readable run report to this path at the end of the run")
+	fs.StringVar(&opts.reportFormat, "report-format", "", "run report format: json or junit (default: junit for .xml paths, else json)")
+	fs.StringVar(&opts.metricsAddr, "metrics-addr", "", "serve Prometheus metrics on this address (e.g. :9477) in --watch mode")
+	fs.StringVar(&opts.metricsFile, "metrics-textfile", "", "write Prometheus metrics to this file for the node_exporter textfile collector")
+	fs.BoolVar(&opts.failOnWarn, "fail-on-warn", false, "exit with code 2 if any warnings were logged")
+	fs.StringVar(&opts.stateDir, "state-dir", envOr("SLOTH_STATE_DIR", "state"), "directory for the undo journal, last run statistics and files downloaded from remote inputs")
+	fs.StringVar(&opts.planFormat, "plan-format", "tree", "plan output: tree or json")
+	fs.StringVar(&opts.planOut, "o", "", "plan: also save the plan as JSON to this file for 'sloth apply'; decrypt: write files into this directory")
+	fs.BoolVar(&opts.interactive, "interactive", false, "ask before each rule that deletes files or moves more than --confirm-moves files")
+	fs.IntVar(&opts.confirmMoves, "confirm-moves", 100, "in --interactive mode, confirm rules moving more than this many files")
+	fs.StringVar(&opts.progress, "progress", "auto", "progress output: auto (bar on a terminal, log lines otherwise), bar, log or off")
+	fs.DurationVar(&opts.progressInt, "progress-interval", 30*time.Second, "delay between progress log lines")
+	fs.Var(&opts.exclude, "exclude-rule", "skip rules matching this name or glob (repeatable)")
+	fs.Var(&opts.identity, "identity", "decrypt: age identity file (repeatable; SLOTH_AGE_IDENTITY may hold a key too)")
+	opts.log = defaultLogConfig()
+	opts.log.registerFlags(fs)
+
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: sloth [command] [flags] [rule...]\n\nCommands:\n")
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		for _, name := range []string{"run", "plan", "apply", "validate", "list", "undo", "stats", "verify", "decrypt", "version"} {
+			fmt.Fprintf(tw, "  %s\t%s\n", name, subcommands[name].summary)
+		}
+		tw.Flush()
+		fmt.Fprintf(out, "\nRules are selected by name or glob pattern (e.g. 'Archive*').\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseInterleaved parses flags that may appear before or after positional arguments.
+func parseInterleaved(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positionals []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positionals, nil
+		}
+		positionals = append(positionals, args[0])
+		args = args[1:]
+	}
+}
+
+// runCLI dispatches to a subcommand and returns the process exit code.
+func runCLI(args []string) int {
+	opts := &cliOptions{}
+	fs := newFlagSet(opts)
+	positionals, err := parseInterleaved(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitConfig
+	}
+	incomingDir = filepath.Join(opts.stateDir, "incoming")
+
+	name := "run"
+	if len(positionals) > 0 {
+		if _, ok := subcommands[positionals[0]]; ok {
+			name, positionals = positionals[0], positionals[1:]
+		} else if positionals[0] == "help" {
+			fs.SetOutput(os.Stdout)
+			fs.Usage()
+			return exitOK
+		} else {
+			fmt.Fprintf(os.Stderr, "unknown command %q (rule names go after the command, e.g. 'sloth run %s')\n", positionals[0], positionals[0])
+			fs.Usage()
+			return exitConfig
+		}
+	}
+
+	// Allow env override (SLOTH_DRY_RUN=1)
+	if os.Getenv("SLOTH_DRY_RUN") == "1" {
+		opts.dryRun = true
+	}
+	dryRun = opts.dryRun
+	return subcommands[name].run(opts, positionals)
+}
+
+// startLogger creates the app logger for commands that operate on rules.
+func startLogger(opts *cliOptions) (*AppLogger, bool) {
+	appLogger, err := newAppLogger(dryRun, opts.log)
+	if err != nil {
+		appLogger.Error("invalid log flags: %v", err)
+		return appLogger, false
+	}
+	return appLogger, true
+}
+
+// ruleSelector picks rules by exact name or glob pattern. An empty include list selects
+// every rule; exclude patterns are applied afterwards.
+type ruleSelector struct {
+	include []string
+	exclude []string
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if p == name {
+			return true
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the selected rules in config order. Include patterns that match nothing
+// are reported as config errors so typos don't silently run nothing.
+func (s ruleSelector) apply(folders []folder) ([]folder, error) {
+	for _, p := range append(append([]string(nil), s.include...), s.exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("%w: rule pattern %q: %w", errInvalidConfig, p, err)
+		}
+	}
+	for _, p := range s.include {
+		found := false
+		for i := range folders {
+			if matchesAny(folders[i].Name, []string{p}) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: no rule matches %q", errInvalidConfig, p)
+		}
+	}
+
+	var selected []folder
+	for i := range folders {
+		name := folders[i].Name
+		if len(s.include) > 0 && !matchesAny(name, s.include) {
+			continue
+		}
+		if matchesAny(name, s.exclude) {
+			continue
+		}
+		selected = append(selected, folders[i])
+	}
+	return selected, nil
+}
+
+// runSession is the logger, undo journal and end-of-run reporting shared by the
+// commands that change files.
+type runSession struct {
+	opts    *cliOptions
+	logger  *AppLogger
+	run     runInfo
+	journal *moveJournal
+}
+
+func startSession(opts *cliOptions) (*runSession, bool) {
+	if !opts.log.Quiet {
+		header()
+	}
+	appLogger, ok := startLogger(opts)
+	if !ok {
+		return nil, false
+	}
+	s := &runSession{opts: opts, logger: appLogger, run: newRunInfo()}
+	appLogger.Info("Start time: %s (run %s)", s.run.Start.Format(time.RFC3339), s.run.ID)
+
+	if !dryRun {
+		j, err := openJournal(opts.stateDir, s.run)
+		if err != nil {
+			appLogger.Warn("undo journal disabled: %v", err)
+		} else {
+			s.journal = j
+			journal = j
+		}
+	}
+	return s, true
+}
+
+// finish logs the summary and writes the run statistics, metrics and report.
+func (s *runSession) finish() {
+	end := time.Now()
+	s.logger.Summary(end.Sub(s.run.Start))
+	rep := s.logger.Report(s.run, end)
+	if err := saveLastReport(s.opts.stateDir, rep); err != nil {
+		s.logger.Warn("failed to save run statistics: %v", err)
+	}
+	if s.opts.metricsFile != "" {
+		if err := writeMetricsFile(s.opts.metricsFile, s.logger); err != nil {
+			s.logger.Error("failed to write metrics textfile: %v", err)
+		}
+	}
+	if s.opts.report != "" {
+		if err := writeReport(s.opts.report, s.opts.reportFormat, rep); err != nil {
+			s.logger.Error("failed to write run report: %v", err)
+		}
+	}
+}
+
+// close stops journaling once no more files will be moved.
+func (s *runSession) close() {
+	if s.journal != nil {
+		journal = nil
+		_ = s.journal.Close()
+	}
+}
+
+// setupProgress configures progressOutput from --progress. In auto mode the bar is used
+// only for single runs on a terminal; watch mode runs rules concurrently and logs instead.
+func setupProgress(opts *cliOptions) error {
+	bar := false
+	switch strings.ToLower(opts.progress) {
+	case "off":
+		return nil
+	case "auto":
+		bar = !opts.watch && !opts.log.Quiet && isTerminal(os.Stderr)
+	case "bar":
+		bar = true
+	case "log":
+	default:
+		return fmt.Errorf("%w: progress %q: must be auto, bar, log or off", errInvalidConfig, opts.progress)
+	}
+	if !bar && opts.progressInt <= 0 {
+		return fmt.Errorf("%w: --progress-interval must be positive", errInvalidConfig)
+	}
+	s := &progressSettings{interval: opts.progressInt}
+	if bar {
+		s.bar = &barWriter{out: consoleOut}
+		consoleOut = s.bar
+	}
+	progressOutput = s
+	return nil
+}
+
+// cmdRun runs the selected rules once, or continuously with --watch.
+func cmdRun(opts *cliOptions, args []string) int {
+	s, ok := startSession(opts)
+	if !ok {
+		return exitConfig
+	}
+	defer s.close()
+	appLogger := s.logger
+	sel := ruleSelector{include: args, exclude: opts.exclude}
+
+	defer func(out io.Writer) {
+		progressOutput = nil
+		consoleOut = out
+	}(consoleOut)
+	if err := setupProgress(opts); err != nil {
+		appLogger.Error("%v", err)
+		return exitConfig
+	}
+
+	balancer := &Balancer{}
+	if opts.interactive {
+		if opts.watch {
+			appLogger.Error("%v", fmt.Errorf("%w: --interactive cannot be combined with --watch", errInvalidConfig))
+			return exitConfig
+		}
+		confirm = newConfirmer(os.Stdin, os.Stderr, opts.confirmMoves)
+		defer func() { confirm = nil }()
+	}
+	if opts.watch {
+		finish := s.finish
+		if opts.metricsAddr != "" {
+			srv := serveMetrics(opts.metricsAddr, appLogger)
+			finish = func() {
+				s.finish()
+				_ = srv.Close()
+			}
+		}
+		if err := runWatch(appLogger, balancer, &s.run, opts.interval, sel, finish); err != nil {
+			appLogger.Error("%v", err)
+			return exitConfig
+		}
+		return appLogger.ExitCode(opts.failOnWarn)
+	}
+	if opts.metricsAddr != "" {
+		appLogger.Warn("--metrics-addr is only used in --watch mode; use --metrics-textfile for single runs")
+	}
+
+	folders, sum, err := loadFolders(configPath, appLogger)
+	s.run.ConfigHash = sum
+	if err == nil {
+		err = validateFolders(folders)
+	}
+	if err == nil {
+		folders, err = sel.apply(folders)
+	}
+	if err != nil {
+		appLogger.Error("%v", err)
+		return exitConfig
+	}
+	appLogger.SetOutputs(ruleOutputs(folders))
+	stopSignals := handleInterrupts(appLogger)
+
+	// Use index loop to avoid implicit memory aliasing of range variable when taking its address
+	for i := range folders {
+		if appLogger.Interrupted() {
+			appLogger.Warn("Interrupted: skipping remaining %d rule(s)", len(folders)-i)
+			break
+		}
+		processFolder(appLogger, balancer, &folders[i])
+	}
+
+	stopSignals()
+	s.finish()
+	return appLogger.ExitCode(opts.failOnWarn)
+}
+
+// cmdPlan prints every operation the selected rules would perform. It only reads the
+// filesystem and logs to the console, so it works without a logs/ directory.
+func cmdPlan(opts *cliOptions, args []string) int {
+	opts.log.Sink = "none"
+	appLogger, ok := startLogger(opts)
+	if !ok {
+		return exitConfig
+	}
+	folders, _, err := parseFolders(configPath, appLogger)
+	if err == nil {
+		err = validateFolders(folders)
+	}
+	if err == nil {
+		folders, err = ruleSelector{include: args, exclude: opts.exclude}.apply(folders)
+	}
+	if err != nil {
+		appLogger.Error("%v", err)
+		return exitConfig
+	}
+
+	plan := buildPlan(folders)
+	if err := writePlan(os.Stdout, plan, opts.planFormat); err != nil {
+		appLogger.Error("%v", err)
+		return exitConfig
+	}
+	if opts.planOut != "" {
+		if err := savePlan(opts.planOut, plan); err != nil {
+			appLogger.Error("failed to save plan: %v", err)
+			return exitFailures
+		}
+		fmt.Fprintf(os.Stderr, "Plan saved to %s; run 'sloth apply %s' to execute it\n", opts.planOut, opts.planOut)
+	}
+	if plan.problems() > 0 {
+		return exitFailures
+	}
+	return exitOK
+}
+
+// cmdValidate loads and validates the rules file without running or rewriting it.
+func cmdValidate(opts *cliOptions, args []string) int {
+	appLogger, ok := startLogger(opts)
+	if !ok {
+		return exitConfig
+	}
+	folders, _, err := parseFolders(configPath, appLogger)
+	if err == nil {
+		err = validateFolders(folders)
+	}
+	if err == nil {
+		folders, err = ruleSelector{include: args, exclude: opts.exclude}.apply(folders)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", configPath, err)
+		return exitConfig
+	}
+	fmt.Printf("%s: OK (%d rules)\n", configPath, len(folders))
+	return exitOK
+}
+
+// cmdList prints the selected rules with their effective settings.
+func cmdList(opts *cliOptions, args []string) int {
+	appLogger, ok := startLogger(opts)
+	if !ok {
+		return exitConfig
+	}
+	folders, _, err := parseFolders(configPath, appLogger)
+	if err == nil {
+		folders, err = ruleSelector{include: args, exclude: opts.exclude}.apply(folders)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", configPath, err)
+		return exitConfig
+	}
+	printRules(os.Stdout, folders)
+	return exitOK
+}
+
+// folderTypeNames describes each folderType for `sloth list`.
+var folderTypeNames = map[string]string{
+	"1":      "YYYY/M/Day D by modified date",
+	"2":      "by extension",
+	"3":      "by extension, then year",
+	"4":      "output root",
+	"5":      "YYYYMM by modified date",
+	"delete": "delete from input",
+}
+
+func printRules(w io.Writer, folders []folder) {
+	tw := tabwriter.NewWriter(w, 0, 4, 1, ' ', 0)
+	for i := range folders {
+		f := &folders[i]
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		ext := f.Extension
+		if ext == "" {
+			ext = "(all files)"
+		}
+		retention := "off"
+		if f.DeleteOlderThan > 0 {
+			retention = fmt.Sprintf("%d days", f.DeleteOlderThan)
+		}
+		fmt.Fprintf(tw, "%s\n", f.Name)
+		fmt.Fprintf(tw, "  input:\t%s\n", f.Input)
+		if isRemote(f.Input) && !strings.EqualFold(f.FolderType, "delete") {
+			fmt.Fprintf(tw, "  deleteRemote:\t%v\n", f.DeleteRemote)
+		}
+		fmt.Fprintf(tw, "  output:\t%s\n", strings.Join(f.Output, ", "))
+		fmt.Fprintf(tw, "  extension:\t%s\n", ext)
+		if len(f.Companions) > 0 {
+			fmt.Fprintf(tw, "  companions:\t%s\n", strings.Join(f.Companions, ", "))
+		}
+		fmt.Fprintf(tw, "  folderType:\t%s (%s)\n", f.FolderType, folderTypeNames[strings.ToLower(f.FolderType)])
+		if !strings.EqualFold(f.FolderType, "delete") {
+			fmt.Fprintf(tw, "  action:\t%s\n", f.action())
+		}
+		if f.RenameTemplate != "" {
+			fmt.Fprintf(tw, "  renameTemplate:\t%s\n", f.RenameTemplate)
+		}
+		fmt.Fprintf(tw, "  deleteOlderThan:\t%s\n", retention)
+		fmt.Fprintf(tw, "  dryRun:\t%v\n", dryRun || f.DryRun)
+		fmt.Fprintf(tw, "  verify:\t%v\n", f.Verify)
+		if f.Compress != nil {
+			fmt.Fprintf(tw, "  compress:\t%s\n", f.Compress)
+		}
+		if f.Bundle != nil {
+			fmt.Fprintf(tw, "  bundle:\t%s\n", f.Bundle)
+		}
+		if f.Encrypt != nil {
+			fmt.Fprintf(tw, "  encrypt:\t%s\n", f.Encrypt)
+		}
+		if f.Scan != nil {
+			fmt.Fprintf(tw, "  scan:\t%s\n", f.Scan)
+		}
+		if f.Ready != nil {
+			fmt.Fprintf(tw, "  ready:\t%s\n", f.Ready)
+		}
+		if f.Sanitize != nil {
+			fmt.Fprintf(tw, "  sanitize:\t%s\n", f.Sanitize)
+		}
+	}
+	tw.Flush()
+}
+
+// cmdUndo reverses the moves recorded in a run's journal.
+func cmdUndo(opts *cliOptions, args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: sloth undo [run-id]")
+		return exitConfig
+	}
+	appLogger, ok := startLogger(opts)
+	if !ok {
+		return exitConfig
+	}
+	runID := ""
+	if len(args) == 1 {
+		runID = args[0]
+	}
+	path, err := findJournal(opts.stateDir, runID)
+	if err != nil {
+		appLogger.Error("%v", err)
+		return exitConfig
+	}
+	restored, skipped := undoJournal(path, appLogger, dryRun)
+	fmt.Printf("undo %s: restored %d file(s), skipped %d\n", path, restored, skipped)
+	return appLogger.ExitCode(opts.failOnWarn)
+}
+
+// cmdStats prints the statistics saved by the last run.
+func cmdStats(opts *cliOptions, _ []string) int {
+	rep, err := loadLastReport(opts.stateDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "no run statistics in %s: %v\n", opts.stateDir, err)
+		return exitConfig
+	}
+	printReport(os.Stdout, rep)
+	return exitOK
+}
+
+// cmdApply executes a plan saved with `sloth plan -o`, refusing entries whose files
+// changed since planning.
+func cmdApply(opts *cliOptions, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sloth apply <plan.json>")
+		return exitConfig
+	}
+	plan, err := loadPlan(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot load plan: %v\n", err)
+		return exitConfig
+	}
+
+	s, ok := startSession(opts)
+	if !ok {
+		return exitConfig
+	}
+	defer s.close()
+	appLogger := s.logger
+	if plan.ConfigHash != "" && hashFile(plan.ConfigPath) != plan.ConfigHash {
+		appLogger.Warn("%s changed since the plan was made; applying the plan as saved", plan.ConfigPath)
+	}
+	appLogger.Info("Applying plan %s created %s", args[0], plan.Created.Format(time.RFC3339))
+	appLogger.SetOutputs(planOutputs(plan))
+
+	stopSignals := handleInterrupts(appLogger)
+	stale := applyPlan(appLogger, plan, dryRun)
+	stopSignals()
+	s.finish()
+
+	printStale(os.Stdout, stale)
+	code := appLogger.ExitCode(opts.failOnWarn)
+	if code == exitOK && len(stale) > 0 {
+		code = exitFailures
+	}
+	return code
+}
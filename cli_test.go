@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseInterleaved(t *testing.T) {
+	opts := &cliOptions{}
+	fs := newFlagSet(opts)
+	fs.SetOutput(io.Discard)
+	got, err := parseInterleaved(fs, []string{"--quiet", "run", "Photos*", "--exclude-rule", "Photos-Raw", "Docs", "--dry-run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"run", "Photos*", "Docs"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("positionals = %v, want %v", got, want)
+	}
+	if !opts.dryRun || !opts.log.Quiet {
+		t.Errorf("flags after positionals not parsed: dryRun=%v quiet=%v", opts.dryRun, opts.log.Quiet)
+	}
+	if want := (stringList{"Photos-Raw"}); !reflect.DeepEqual(opts.exclude, want) {
+		t.Errorf("exclude = %v, want %v", opts.exclude, want)
+	}
+}
+
+func TestRuleSelector(t *testing.T) {
+	folders := []folder{{Name: "Photos"}, {Name: "Photos-Raw"}, {Name: "Docs"}, {Name: "Logs"}}
+	names := func(fs []folder) []string {
+		var out []string
+		for _, f := range fs {
+			out = append(out, f.Name)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name    string
+		sel     ruleSelector
+		want    []string
+		wantErr bool
+	}{
+		{"all", ruleSelector{}, []string{"Photos", "Photos-Raw", "Docs", "Logs"}, false},
+		{"exact names keep config order", ruleSelector{include: []string{"Logs", "Docs"}}, []string{"Docs", "Logs"}, false},
+		{"glob", ruleSelector{include: []string{"Photos*"}}, []string{"Photos", "Photos-Raw"}, false},
+		{"exclude", ruleSelector{include: []string{"Photos*"}, exclude: []string{"*-Raw"}}, []string{"Photos"}, false},
+		{"exclude only", ruleSelector{exclude: []string{"Photos*"}}, []string{"Docs", "Logs"}, false},
+		{"unmatched include", ruleSelector{include: []string{"Photo"}}, nil, true},
+		{"bad pattern", ruleSelector{exclude: []string{"["}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.sel.apply(folders)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidConfig) {
+					t.Fatalf("err = %v, want errInvalidConfig", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(names(got), tt.want) {
+				t.Errorf("selected %v, want %v", names(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestRunCLIUsageErrors(t *testing.T) {
+	for _, args := range [][]string{{"--no-such-flag"}, {"bogus"}} {
+		if code := runCLI(args); code != exitConfig {
+			t.Errorf("runCLI(%q) = %d, want %d", args, code, exitConfig)
+		}
+	}
+}
+
+// TestRunRejectsInvalidConfig checks that run validates the rules like validate and plan
+// do, before any file is touched.
+func TestRunRejectsInvalidConfig(t *testing.T) {
+	base := t.TempDir()
+	in := filepath.Join(base, "in")
+	if err := os.MkdirAll(in, 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(in, "a.log")
+	if err := os.WriteFile(src, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := filepath.Join(base, "config.json")
+	rules := `[{"name":"Logs","input":"` + filepath.ToSlash(in) + `","output":["` + filepath.ToSlash(filepath.Join(base, "out")) + `"],"extension":".log","folderType":"4","compress":{"format":"xz","level":12}}]`
+	if err := os.WriteFile(cfg, []byte(rules), 0600); err != nil {
+		t.Fatal(err)
+	}
+	origWD, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWD) }()
+	if err := os.Chdir(base); err != nil {
+		t.Fatal(err)
+	}
+	defer func(p string) { configPath = p }(configPath)
+
+	if code := runCLI([]string{"--quiet", "--config", cfg, "run"}); code != exitConfig {
+		t.Errorf("run with an invalid rule = %d, want %d", code, exitConfig)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("input file touched: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "out")); !os.IsNotExist(err) {
+		t.Errorf("output created: %v", err)
+	}
+}
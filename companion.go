@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Companions are sidecar files that belong to a primary file by name, like the
+// "doc.xml" of a scanned "doc.pdf" or the "IMG_0001.xmp" of a raw "IMG_0001.CR2". A
+// rule's companions list their extensions; each primary file is moved together with its
+// companions, to the same output and folder, and is deferred with them when any of them
+// is not ready.
+
+// validateCompanions checks the rule's companion extensions.
+func validateCompanions(f *folder) error {
+	for _, ext := range f.Companions {
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 || strings.ContainsAny(ext, `/\`) {
+			return fmt.Errorf("companions: %q must be an extension like .xmp", ext)
+		}
+		if ext == f.Extension {
+			return fmt.Errorf("companions: %s is the rule's own extension", ext)
+		}
+		if ext == f.Ready.sentinel() {
+			return fmt.Errorf("companions: %s is the ready.sentinel suffix; markers are never moved", ext)
+		}
+	}
+	if len(f.Companions) > 0 && f.Bundle != nil {
+		return errors.New("companions and bundle cannot be combined; bundles already keep a rule's files together")
+	}
+	return nil
+}
+
+// isCompanionExt reports whether name has one of the rule's companion extensions.
+func (f *folder) isCompanionExt(name string) bool {
+	return slices.Contains(f.Companions, filepath.Ext(name))
+}
+
+// listsFile reports whether the rule's input scan lists name: files of the rule's
+// extension, and of its companions' extensions.
+func (f *folder) listsFile(name string) bool {
+	return f.Extension == "" || filepath.Ext(name) == f.Extension || f.isCompanionExt(name)
+}
+
+// scanRule is scanInput for the rule's input: with companions, it also lists the files
+// of their extensions.
+func scanRule(input string, f *folder) ([]fs.FileInfo, []skippedEntry, error) {
+	if len(f.Companions) == 0 {
+		return scanInput(input, f.Extension, f.Scan)
+	}
+	files, skipped, err := scanInput(input, "", f.Scan)
+	files = slices.DeleteFunc(files, func(fi fs.FileInfo) bool { return !f.listsFile(fi.Name()) })
+	skipped = slices.DeleteFunc(skipped, func(s skippedEntry) bool { return !f.listsFile(filepath.Base(s.path)) })
+	return files, skipped, err
+}
+
+// companionNames returns the names a companion of the file name can have: its stem or
+// its full name with each companion extension, e.g. "IMG.xmp" and "IMG.CR2.xmp".
+func (f *folder) companionNames(name string) []string {
+	var names []string
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	for _, ext := range f.Companions {
+		for _, c := range []string{stem + ext, name + ext} {
+			if c != name && !slices.Contains(names, c) {
+				names = append(names, c)
+			}
+		}
+	}
+	return names
+}
+
+// fileGroup is a file the rule acts on and the companions that go with it.
+type fileGroup struct {
+	fi         fs.FileInfo
+	companions []fs.FileInfo
+}
+
+// size returns the number of files in g.
+func (g fileGroup) size() int { return 1 + len(g.companions) }
+
+// groupFiles groups the files of a scan of the rule's input by name. Files of the rule's
+// extension each start a group that takes in their companions; without an extension,
+// every file that is not a companion of another does. A companion is only ever in one
+// group, and one without a primary file is left alone when the rule has an extension.
+func (f *folder) groupFiles(files []fs.FileInfo) []fileGroup {
+	if len(f.Companions) == 0 {
+		groups := make([]fileGroup, len(files))
+		for i, fi := range files {
+			groups[i] = fileGroup{fi: fi}
+		}
+		return groups
+	}
+	byName := map[string]fs.FileInfo{}
+	for _, fi := range files {
+		byName[fi.Name()] = fi
+	}
+	claimed := map[string]bool{}
+	var groups []fileGroup
+	primary := func(fi fs.FileInfo) {
+		g := fileGroup{fi: fi}
+		claimed[fi.Name()] = true
+		for _, c := range f.companionNames(fi.Name()) {
+			if ci, ok := byName[c]; ok && !claimed[c] {
+				claimed[c] = true
+				g.companions = append(g.companions, ci)
+			}
+		}
+		groups = append(groups, g)
+	}
+	for _, fi := range files {
+		if !f.isCompanionExt(fi.Name()) && (f.Extension == "" || filepath.Ext(fi.Name()) == f.Extension) {
+			primary(fi)
+		}
+	}
+	if f.Extension == "" {
+		for _, fi := range files {
+			if !claimed[fi.Name()] {
+				primary(fi)
+			}
+		}
+	}
+	return groups
+}
+
+// deferredGroup is a group that is not ready yet, and why.
+type deferredGroup struct {
+	fileGroup
+	reason string
+}
+
+// sortReadyGroups is sortReady for groups: a group is ready when all its files are, and
+// is deferred as a whole otherwise.
+func sortReadyGroups(r *readyOptions, dir string, groups []fileGroup, interrupted func() bool) ([]fileGroup, []deferredGroup, error) {
+	if r == nil {
+		return groups, nil, nil
+	}
+	var files []fs.FileInfo
+	for _, g := range groups {
+		files = append(files, g.fi)
+		files = append(files, g.companions...)
+	}
+	ready, deferred, err := sortReady(r, dir, files, interrupted)
+	isReady := map[string]bool{}
+	for _, fi := range ready {
+		isReady[fi.Name()] = true
+	}
+	why := map[string]string{}
+	for _, d := range deferred {
+		why[d.fi.Name()] = d.reason
+	}
+
+	var readyGroups []fileGroup
+	var deferredGroups []deferredGroup
+	for _, g := range groups {
+		if !isReady[g.fi.Name()] {
+			if reason, ok := why[g.fi.Name()]; ok {
+				deferredGroups = append(deferredGroups, deferredGroup{g, reason})
+			}
+			continue // a sentinel marker
+		}
+		reason := ""
+		for _, c := range g.companions {
+			if !isReady[c.Name()] {
+				reason = "companion " + c.Name() + ": " + why[c.Name()]
+				break
+			}
+		}
+		if reason != "" {
+			deferredGroups = append(deferredGroups, deferredGroup{g, reason})
+		} else {
+			readyGroups = append(readyGroups, g)
+		}
+	}
+	return readyGroups, deferredGroups, err
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompanionsMoveTogether(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	outA := filepath.Join(dir, "outA")
+	outB := filepath.Join(dir, "outB")
+	for _, d := range []string{in, outA, outB} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-time.Hour)
+	for _, n := range []string{"doc1.pdf", "doc1.xml", "doc2.pdf", "doc2.pdf.xml", "orphan.xml"} {
+		writeAged(t, in, n, n, old)
+	}
+
+	rule := folder{Name: "Scans", Input: in, Output: []string{outA, outB}, Extension: ".pdf", FolderType: "4", Companions: []string{".xml"}}
+	if err := validateFolders([]folder{rule}); err != nil {
+		t.Fatal(err)
+	}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	processFolder(al, &Balancer{}, &rule)
+	if n := al.counters.errorsCount.Load(); n != 0 {
+		t.Fatalf("%d errors", n)
+	}
+
+	for primary, companion := range map[string]string{"doc1.pdf": "doc1.xml", "doc2.pdf": "doc2.pdf.xml"} {
+		var out string
+		for _, o := range []string{outA, outB} {
+			if _, err := os.Stat(filepath.Join(o, primary)); err == nil {
+				out = o
+			}
+		}
+		if out == "" {
+			t.Fatalf("%s not moved", primary)
+		}
+		if _, err := os.Stat(filepath.Join(out, companion)); err != nil {
+			t.Errorf("%s not moved next to %s: %v", companion, primary, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(in, "orphan.xml")); err != nil {
+		t.Errorf("companion without a primary file was moved: %v", err)
+	}
+}
+
+func TestGroupFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, n := range []string{"IMG.CR2", "IMG.xmp", "IMG.CR2.xmp", "other.xmp", "notes.txt", "notes.xmp", "lone.xmp"} {
+		writeAged(t, dir, n, "", time.Now())
+	}
+	files, _, err := scanInput(dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupNames := func(groups []fileGroup) string {
+		var s []string
+		for _, g := range groups {
+			names := []string{g.fi.Name()}
+			for _, c := range g.companions {
+				names = append(names, c.Name())
+			}
+			s = append(s, strings.Join(names, "+"))
+		}
+		return strings.Join(s, " ")
+	}
+
+	raw := folder{Extension: ".CR2", Companions: []string{".xmp"}}
+	var listed []fs.FileInfo
+	for _, fi := range files {
+		if raw.listsFile(fi.Name()) {
+			listed = append(listed, fi)
+		}
+	}
+	if got, want := groupNames(raw.groupFiles(listed)), "IMG.CR2+IMG.xmp+IMG.CR2.xmp"; got != want {
+		t.Errorf("extension .CR2: groups %q, want %q", got, want)
+	}
+	all := folder{Companions: []string{".xmp"}}
+	if got, want := groupNames(all.groupFiles(files)), "IMG.CR2+IMG.xmp+IMG.CR2.xmp notes.txt+notes.xmp lone.xmp other.xmp"; got != want {
+		t.Errorf("all files: groups %q, want %q", got, want)
+	}
+}
+
+func TestCompanionDefersGroup(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pdf := writeAged(t, in, "scan.pdf", "%PDF", time.Now().Add(-time.Hour))
+	xml := writeAged(t, in, "scan.xml", "<scan/>", time.Now())
+
+	rule := folder{Name: "Scans", Input: in, Output: []string{out}, Extension: ".pdf", FolderType: "4", Companions: []string{".xml"}, Ready: &readyOptions{MinAge: "10m"}}
+	if err := validateFolders([]folder{rule}); err != nil {
+		t.Fatal(err)
+	}
+	if ops := buildPlan([]folder{rule}).Rules[0].Ops; len(ops) != 0 {
+		t.Errorf("plan moves a group that is not ready: %+v", ops)
+	}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	processFolder(al, &Balancer{}, &rule)
+	for _, p := range []string{pdf, xml} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("%s moved before its companion was ready: %v", filepath.Base(p), err)
+		}
+	}
+	if n := al.counters.stats.rule("Scans").skipped.Load(); n != 2 {
+		t.Errorf("skipped = %d, want 2", n)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(xml, old, old); err != nil {
+		t.Fatal(err)
+	}
+	plan := buildPlan([]folder{rule})
+	var dsts []string
+	for _, op := range plan.Rules[0].Ops {
+		dsts = append(dsts, op.Op+" "+op.Dst)
+	}
+	if got, want := strings.Join(dsts, ", "), "move "+filepath.Join(out, "scan.pdf")+", move "+filepath.Join(out, "scan.xml"); got != want {
+		t.Errorf("plan ops %q, want %q", got, want)
+	}
+}
+
+func TestCompanionJoinsOneGroup(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	outA := filepath.Join(dir, "outA")
+	outB := filepath.Join(dir, "outB")
+	for _, d := range []string{in, outA, outB} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range []string{"IMG.CR2", "IMG.jpg", "IMG.xmp"} {
+		writeAged(t, in, n, n, time.Now())
+	}
+
+	// Without an extension, IMG.CR2 and IMG.jpg both start a group; only one gets IMG.xmp.
+	rule := folder{Name: "Photos", Input: in, Output: []string{outA, outB}, FolderType: "4", Action: "copy", Companions: []string{".xmp"}}
+	if err := validateFolders([]folder{rule}); err != nil {
+		t.Fatal(err)
+	}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	processFolder(al, &Balancer{}, &rule)
+	copies := 0
+	for _, out := range []string{outA, outB} {
+		if _, err := os.Stat(filepath.Join(out, "IMG.xmp")); err == nil {
+			copies++
+		}
+	}
+	if copies != 1 {
+		t.Errorf("IMG.xmp placed %d times, want once", copies)
+	}
+
+	// A sidecar that turns up after the readiness check is not moved with the group.
+	late := folder{Name: "Late", Input: in, Output: []string{outA}, Extension: ".jpg", FolderType: "4", Companions: []string{".txt"}}
+	fi, err := os.Stat(filepath.Join(in, "IMG.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := late.groupFiles([]fs.FileInfo{fi})
+	sidecar := writeAged(t, in, "IMG.txt", "late", time.Now())
+	moveAll(al, &Balancer{}, &late, defaultDirSettings, groups, false)
+	if _, err := os.Stat(sidecar); err != nil {
+		t.Errorf("unchecked sidecar was moved: %v", err)
+	}
+}
+
+func TestApplySkipsStaleGroup(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-time.Hour)
+	pdf := writeAged(t, in, "scan.pdf", "%PDF", old)
+	xml := writeAged(t, in, "scan.xml", "<scan/>", old)
+
+	rule := folder{Name: "Scans", Input: in, Output: []string{out}, Extension: ".pdf", FolderType: "4", Companions: []string{".xml"}}
+	plan := buildPlan([]folder{rule})
+	if ops := plan.Rules[0].Ops; len(ops) != 2 || ops[1].CompanionOf != pdf {
+		t.Fatalf("plan ops: %+v", ops)
+	}
+	writeAged(t, in, "scan.pdf", "%PDF-1.7", time.Now())
+
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	if stale := applyPlan(al, plan, false); len(stale) != 2 {
+		t.Errorf("stale entries = %+v, want the file and its companion", stale)
+	}
+	for _, p := range []string{pdf, xml} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("%s moved without its group: %v", filepath.Base(p), err)
+		}
+	}
+}
+
+func TestCompanionValidation(t *testing.T) {
+	base := folder{Name: "r", Input: "in", Output: []string{"out"}, Extension: ".pdf", FolderType: "4"}
+	for name, mod := range map[string]func(*folder){
+		"no dot":          func(f *folder) { f.Companions = []string{"xml"} },
+		"own extension":   func(f *folder) { f.Companions = []string{".pdf"} },
+		"sentinel suffix": func(f *folder) { f.Companions = []string{".done"}; f.Ready = &readyOptions{Sentinel: ".done"} },
+		"with bundle": func(f *folder) {
+			f.Companions = []string{".xml"}
+			f.Bundle = &bundleOptions{Format: "zip"}
+		},
+	} {
+		f := base
+		mod(&f)
+		if validateFolders([]folder{f}) == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compressOptions makes a rule compress files into the output folder instead of
+// renaming them. In config it is either a format name or {"format": ..., "level": ...}.
+type compressOptions struct {
+	Format string `json:"format"`          // "gzip", "zstd" or "xz"
+	Level  int    `json:"level,omitempty"` // 0 (or unset) uses the format's default; see compressFormats
+}
+
+// compressFormats maps each format to its file suffix and level range. Level 0 is not in
+// any range; it stands for the format's default.
+var compressFormats = map[string]struct {
+	ext      string
+	min, max int
+}{
+	"gzip": {".gz", 1, 9},
+	"zstd": {".zst", 1, 22},
+	"xz":   {".xz", 1, 9},
+}
+
+func (c *compressOptions) UnmarshalJSON(b []byte) error {
+	var format string
+	if err := json.Unmarshal(b, &format); err == nil {
+		*c = compressOptions{Format: format}
+		return nil
+	}
+	type plain compressOptions
+	return json.Unmarshal(b, (*plain)(c))
+}
+
+func (c *compressOptions) validate() error {
+	f, ok := compressFormats[c.Format]
+	if !ok {
+		return fmt.Errorf("compress format %q: must be gzip, zstd or xz", c.Format)
+	}
+	if c.Level != 0 && (c.Level < f.min || c.Level > f.max) {
+		return fmt.Errorf("compress level %d: %s levels are %d-%d", c.Level, c.Format, f.min, f.max)
+	}
+	return nil
+}
+
+func (c *compressOptions) String() string {
+	if c.Level == 0 {
+		return c.Format
+	}
+	return fmt.Sprintf("%s (level %d)", c.Format, c.Level)
+}
+
+// ext returns the suffix appended to compressed files, or "" for a nil c.
+func (c *compressOptions) ext() string {
+	if c == nil {
+		return ""
+	}
+	return compressFormats[c.Format].ext
+}
+
+// xzDictCaps are the dictionary sizes of the xz presets 0-9. Preset 0 is not selectable,
+// since level 0 means the default (preset 6).
+var xzDictCaps = [...]int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// writer returns a compressing writer onto w. Closing it flushes the stream but not w.
+func (c *compressOptions) writer(w io.Writer) (io.WriteCloser, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	switch c.Format {
+	case "gzip":
+		level := gzip.DefaultCompression
+		if c.Level != 0 {
+			level = c.Level
+		}
+		return gzip.NewWriterLevel(w, level)
+	case "zstd":
+		level := zstd.SpeedDefault
+		if c.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(c.Level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	case "xz":
+		cfg := xz.WriterConfig{}
+		if c.Level != 0 {
+			cfg.DictCap = xzDictCaps[c.Level]
+		}
+		return cfg.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown compress format %q", c.Format)
+	}
+}
+
+// decompressReader returns a reader of the uncompressed content of r.
+func decompressReader(format string, r io.Reader) (io.ReadCloser, error) {
+	switch format {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case "xz":
+		x, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(x), nil
+	default:
+		return nil, fmt.Errorf("unknown compress format %q", format)
+	}
+}
+
+// verifyCompressed decompresses path and checks that the content hashes to want.
+func verifyCompressed(path, format, want string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := decompressReader(format, f)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errChecksumMismatch, err)
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return fmt.Errorf("%w: %v", errChecksumMismatch, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("%w: source %s, decompressed copy %s", errChecksumMismatch, want, got)
+	}
+	return nil
+}
+
+// decompressFile restores the compressed file src to dst with src's mode and times, then
+// removes src. `sloth undo` uses it to reverse compressed moves.
+func decompressFile(src, dst, format string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	r, err := decompressReader(format, in)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err := io.Copy(tmp, r); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := applyMetadata(src, tmp.Name(), fi, nil); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	ok = true
+	return os.Remove(src)
+}
+
+// formatOfCompressed returns the compress format of a file name by its suffix, or "".
+func formatOfCompressed(name string) string {
+	for format, f := range compressFormats {
+		if strings.HasSuffix(name, f.ext) {
+			return format
+		}
+	}
+	return ""
+}
+
+// wrapsOutputs reports whether the rule adds compression, bundle or encryption suffixes
+// to the files it places, for matchesExtension.
+func (f *folder) wrapsOutputs() bool {
+	return f.Compress != nil || f.Bundle != nil || f.Encrypt != nil
+}
+
+// matchesExtension reports whether name has the rule extension ext. With wrapped, for
+// rules that compress, bundle or encrypt, it also looks underneath those suffixes
+// ("app.log.zst.age" and "202401.log.tar.zst" match ".log"), so retention keeps working
+// on their outputs. An empty ext keeps its old meaning of files without an extension.
+func matchesExtension(name, ext string, wrapped bool) bool {
+	for {
+		if filepath.Ext(name) == ext {
+			return true
+		}
+		if ext == "" || !wrapped {
+			return false
+		}
+		trimmed := name
+		if format := formatOfCompressed(name); format != "" {
+			trimmed = strings.TrimSuffix(name, compressFormats[format].ext)
+		} else if s := filepath.Ext(name); s == ".tar" || s == ".zip" || s == ageExt {
+			trimmed = strings.TrimSuffix(name, s)
+		}
+		if trimmed == name {
+			return false
+		}
+		name = trimmed
+	}
+}
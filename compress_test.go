@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressedMoveRoundTrip(t *testing.T) {
+	content := strings.Repeat("2024-01-02 12:00:00 INFO request served\n", 500)
+	for _, c := range []compressOptions{{Format: "gzip"}, {Format: "zstd", Level: 19}, {Format: "xz", Level: 6}} {
+		t.Run(c.Format, func(t *testing.T) {
+			dir := t.TempDir()
+			in := filepath.Join(dir, "in")
+			out := filepath.Join(dir, "out")
+			for _, d := range []string{in, out} {
+				if err := os.MkdirAll(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			src := filepath.Join(in, "app.log")
+			if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			mtime := time.Now().AddDate(0, 0, -40)
+			if err := os.Chtimes(src, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+
+			f := &folder{Name: "Logs", Input: in, Output: []string{out}, Extension: ".log", FolderType: "4", Verify: true, Compress: &c}
+			al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true}).WithRule(f.Name)
+			moveFile(al, &Balancer{}, f, defaultDirSettings, "app.log", nil, false)
+			if n := al.counters.errorsCount.Load(); n != 0 {
+				t.Fatalf("%d errors during compressed move", n)
+			}
+			if _, err := os.Stat(src); !os.IsNotExist(err) {
+				t.Errorf("source not removed: %v", err)
+			}
+
+			dst := filepath.Join(out, "app.log"+c.ext())
+			fi, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Size() >= int64(len(content)) {
+				t.Errorf("compressed size %d >= original %d", fi.Size(), len(content))
+			}
+			if !fi.ModTime().Equal(mtime) {
+				t.Errorf("mtime = %v, want %v", fi.ModTime(), mtime)
+			}
+			fh, err := os.Open(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fh.Close()
+			r, err := decompressReader(c.Format, fh)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil || string(got) != content {
+				t.Errorf("decompressed content differs (err %v)", err)
+			}
+
+			// The manifest covers the compressed file as written.
+			if results, _, err := verifyTree(out); err != nil || len(results) != 1 || results[0].Status != "OK" {
+				t.Errorf("verifyTree = %+v, %v", results, err)
+			}
+
+			// Retention still recognizes the archive as an old .log file.
+			deleteFiles(out, ".log", true, 30, nil, al, false)
+			if _, err := os.Stat(dst); !os.IsNotExist(err) {
+				t.Errorf("compressed archive not deleted by retention: %v", err)
+			}
+		})
+	}
+}
+
+func TestUndoCompressedMove(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "data.csv")
+	dst := filepath.Join(dir, "data.csv.zst")
+	if err := os.WriteFile(src, []byte("a,b\n1,2\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := copyMove(src, dst, nil, &compressOptions{Format: "zstd"}, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	stateDir := filepath.Join(dir, "state")
+	j, err := openJournal(stateDir, runInfo{ID: "z1", Start: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.record("Csv", src, dst, &compressOptions{Format: "zstd"}, false)
+	j.Close()
+
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	if restored, skipped := undoJournal(j.path, al, false); restored != 1 || skipped != 0 {
+		t.Fatalf("restored=%d skipped=%d", restored, skipped)
+	}
+	if got, err := os.ReadFile(src); err != nil || string(got) != "a,b\n1,2\n" {
+		t.Errorf("restored content = %q, %v", got, err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("compressed file left behind: %v", err)
+	}
+}
+
+func TestVerifyCompressedDetectsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "x.gz")
+	if err := os.WriteFile(path, []byte("not gzip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyCompressed(path, "gzip", "00"); !errors.Is(err, errChecksumMismatch) {
+		t.Errorf("err = %v, want checksum mismatch", err)
+	}
+}
+
+func TestCompressOptionsConfig(t *testing.T) {
+	var f folder
+	m := map[string]any{"name": "r", "compress": "zstd"}
+	if err := decodeOptions(m, &f); err != nil || f.Compress == nil || f.Compress.Format != "zstd" {
+		t.Fatalf("shorthand: %+v, %v", f.Compress, err)
+	}
+	var c compressOptions
+	if err := json.Unmarshal([]byte(`{"format":"gzip","level":9}`), &c); err != nil || c != (compressOptions{"gzip", 9}) {
+		t.Fatalf("object: %+v, %v", c, err)
+	}
+
+	for _, bad := range []compressOptions{{Format: "bzip2"}, {Format: "gzip", Level: 10}, {Format: "zstd", Level: 23}, {Format: "xz", Level: 12}, {Format: "xz", Level: -1}} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("%+v: expected validation error", bad)
+		}
+		// Writers check the level too, so an unvalidated rule fails instead of panicking.
+		if _, err := bad.writer(io.Discard); err == nil {
+			t.Errorf("%+v: expected writer error", bad)
+		}
+	}
+}
+
+func TestMatchesExtension(t *testing.T) {
+	cases := []struct {
+		name, ext string
+		wrapped   bool
+		want      bool
+	}{
+		{"app.log", ".log", true, true},
+		{"app.log.zst", ".log", true, true},
+		{"app.log.gz", ".log", true, true},
+		{"app.csv.xz", ".log", true, false},
+		{"app.zst", ".zst", true, true},
+		{"app.txt", ".log", true, false},
+		// Rules that do not compress, bundle or encrypt leave other tools' archives alone.
+		{"app.log", ".log", false, true},
+		{"app.log.gz", ".log", false, false},
+		{"2024.log.tar", ".log", false, false},
+	}
+	for _, c := range cases {
+		if got := matchesExtension(c.name, c.ext, c.wrapped); got != c.want {
+			t.Errorf("matchesExtension(%q, %q, %v) = %v, want %v", c.name, c.ext, c.wrapped, got, c.want)
+		}
+	}
+}
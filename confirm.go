@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// confirmExamples is how many example paths the confirmation prompt shows per operation.
+const confirmExamples = 5
+
+// confirmer asks the operator before rules that delete files or move many of them
+// (--interactive). Answers: yes, no (skip the rule), all (stop asking), quit (stop the run).
+type confirmer struct {
+	in            *bufio.Reader
+	out           io.Writer
+	tty           bool // without a terminal every prompt gets the safe answer, no
+	moveThreshold int  // confirm rules moving more than this many files
+	all           bool
+}
+
+// confirm is the run's confirmer. It is nil unless --interactive is set, and a nil
+// confirmer approves everything.
+var confirm *confirmer
+
+func newConfirmer(in *os.File, out io.Writer, moveThreshold int) *confirmer {
+	fi, err := in.Stat()
+	tty := err == nil && fi.Mode()&os.ModeCharDevice != 0
+	return &confirmer{in: bufio.NewReader(in), out: out, tty: tty, moveThreshold: moveThreshold}
+}
+
+// rule reports whether f may run. Rules that need no confirmation, or every rule after
+// the operator answered "all", are approved without asking.
+func (c *confirmer) rule(ruleLog *AppLogger, f *folder) bool {
+	if c == nil || c.all {
+		return true
+	}
+	rp := newPlanner().rule(f)
+	var moves, deletes []planOp
+	var moveBytes, deleteBytes int64
+	for _, op := range rp.Ops {
+		switch op.Op {
+		case "move", "bundle":
+			moves = append(moves, op)
+			moveBytes += op.size()
+		case "delete":
+			deletes = append(deletes, op)
+			deleteBytes += op.size()
+		}
+	}
+	if len(deletes) == 0 && len(moves) <= c.moveThreshold {
+		return true
+	}
+	skip := func(reason string) bool {
+		ruleLog.CountSkipped(len(moves) + len(deletes))
+		ruleLog.Warn("Rule skipped: %s", reason)
+		return false
+	}
+	if !c.tty {
+		return skip("confirmation required but stdin is not a terminal")
+	}
+
+	fmt.Fprintf(c.out, "\nRule %q: delete %d file(s), %s; move %d file(s), %s\n",
+		f.Name, len(deletes), formatBytes(deleteBytes), len(moves), formatBytes(moveBytes))
+	printExamples(c.out, "-", deletes, func(op planOp) string { return op.Src })
+	printExamples(c.out, "+", moves, func(op planOp) string { return op.Src + " -> " + op.Dst })
+
+	for {
+		fmt.Fprint(c.out, "Proceed? [y]es / [n]o / [a]ll / [q]uit: ")
+		line, err := c.in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true
+		case "a", "all":
+			c.all = true
+			return true
+		case "n", "no":
+			return skip("declined by operator")
+		case "q", "quit":
+			ruleLog.Interrupt()
+			return skip("operator quit the run")
+		}
+		if err != nil {
+			fmt.Fprintln(c.out)
+			return skip("no answer from operator")
+		}
+	}
+}
+
+func printExamples(w io.Writer, sign string, ops []planOp, describe func(planOp) string) {
+	for i, op := range ops {
+		if i == confirmExamples {
+			fmt.Fprintf(w, "  ... and %d more\n", len(ops)-confirmExamples)
+			break
+		}
+		fmt.Fprintf(w, "  %s %s\n", sign, describe(op))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfirmerRule(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().AddDate(0, 0, -10)
+	for i := 0; i < 7; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("f%d.log", i))
+		if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cleanup := folder{Name: "Cleanup", Input: dir, FolderType: "delete", Extension: ".log", DeleteOlderThan: 1}
+	keep := folder{Name: "Keep", Input: dir, FolderType: "delete", Extension: ".log", DeleteOlderThan: 30}
+
+	tests := []struct {
+		name        string
+		input       string
+		tty         bool
+		want        []bool // answers for cleanup, keep, cleanup
+		interrupted bool
+	}{
+		{"yes", "y\n", true, []bool{true, true, false}, false},
+		{"no then yes", "no\nyes\n", true, []bool{false, true, true}, false},
+		{"retry on garbage", "maybe\ny\nn\n", true, []bool{true, true, false}, false},
+		{"all stops asking", "a\n", true, []bool{true, true, true}, false},
+		{"quit", "q\n", true, []bool{false, true, false}, true},
+		{"not a terminal", "y\n", false, []bool{false, true, false}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			c := &confirmer{in: bufio.NewReader(strings.NewReader(tt.input)), out: &out, tty: tt.tty, moveThreshold: 100}
+			al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+			var got []bool
+			for _, f := range []folder{cleanup, keep, cleanup} {
+				got = append(got, c.rule(al.WithRule(f.Name), &f))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("answers = %v, want %v", got, tt.want)
+					break
+				}
+			}
+			if al.Interrupted() != tt.interrupted {
+				t.Errorf("interrupted = %v", al.Interrupted())
+			}
+			if tt.tty && !strings.Contains(out.String(), "delete 7 file(s)") || tt.tty && !strings.Contains(out.String(), "... and 2 more") {
+				t.Errorf("prompt:\n%s", out.String())
+			}
+		})
+	}
+
+	var nilConfirmer *confirmer
+	if !nilConfirmer.rule(nil, &cleanup) {
+		t.Error("nil confirmer declined")
+	}
+}
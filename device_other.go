@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "io/fs"
+
+// deviceOf is not available here, so oneFilesystem has no effect.
+func deviceOf(fi fs.FileInfo) (uint64, bool) { return 0, false }
@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// deviceOf returns the ID of the filesystem holding the file described by fi.
+func deviceOf(fi fs.FileInfo) (uint64, bool) {
+	if fi, ok := fi.(followedInfo); ok {
+		return deviceOf(fi.FileInfo)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
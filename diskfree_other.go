@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "errors"
+
+func diskFree(string) (uint64, error) {
+	return 0, errors.New("free space is not available on this platform")
+}
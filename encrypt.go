@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+)
+
+// ageExt is appended to files encrypted by a rule.
+const ageExt = ".age"
+
+// encryptOptions makes a rule encrypt files to age recipients on the way to the output.
+// Recipient public keys are only read from a file or an environment variable, so they
+// can be rotated without editing the rules file.
+type encryptOptions struct {
+	RecipientsFile string `json:"recipientsFile,omitempty"` // one "age1..." key per line, # comments allowed
+	RecipientsEnv  string `json:"recipientsEnv,omitempty"`  // variable holding keys separated by whitespace or commas
+
+	once  sync.Once
+	rcpts []age.Recipient
+	err   error
+}
+
+func (e *encryptOptions) validate() error {
+	if e.RecipientsFile == "" && e.RecipientsEnv == "" {
+		return errors.New("encrypt: recipientsFile or recipientsEnv is required")
+	}
+	_, err := e.recipients()
+	return err
+}
+
+// recipients loads the recipients once per rule load.
+func (e *encryptOptions) recipients() ([]age.Recipient, error) {
+	e.once.Do(func() {
+		var keys []string
+		if e.RecipientsFile != "" {
+			data, err := os.ReadFile(e.RecipientsFile)
+			if err != nil {
+				e.err = fmt.Errorf("encrypt: %w", err)
+				return
+			}
+			keys = append(keys, string(data))
+		}
+		if e.RecipientsEnv != "" {
+			v := os.Getenv(e.RecipientsEnv)
+			if v == "" {
+				e.err = fmt.Errorf("encrypt: environment variable %s is empty", e.RecipientsEnv)
+				return
+			}
+			keys = append(keys, strings.Join(strings.FieldsFunc(v, func(r rune) bool {
+				return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+			}), "\n"))
+		}
+		e.rcpts, e.err = age.ParseRecipients(strings.NewReader(strings.Join(keys, "\n")))
+		if e.err != nil {
+			e.err = fmt.Errorf("encrypt: %w", e.err)
+		}
+	})
+	return e.rcpts, e.err
+}
+
+// ext returns the suffix appended to encrypted files, or "" for a nil e.
+func (e *encryptOptions) ext() string {
+	if e == nil {
+		return ""
+	}
+	return ageExt
+}
+
+func (e *encryptOptions) String() string {
+	var from []string
+	if e.RecipientsFile != "" {
+		from = append(from, e.RecipientsFile)
+	}
+	if e.RecipientsEnv != "" {
+		from = append(from, "$"+e.RecipientsEnv)
+	}
+	n, _ := e.recipients()
+	return fmt.Sprintf("age, %d recipient(s) from %s", len(n), strings.Join(from, ", "))
+}
+
+// writer returns an encrypting writer onto w. Closing it finishes the age stream but not w.
+func (e *encryptOptions) writer(w io.Writer) (io.WriteCloser, error) {
+	rcpts, err := e.recipients()
+	if err != nil {
+		return nil, err
+	}
+	return age.Encrypt(w, rcpts...)
+}
+
+// loadIdentities reads age identities from files and from SLOTH_AGE_IDENTITY, which holds
+// the key itself. Keys are never taken from the command line.
+func loadIdentities(files []string) ([]age.Identity, error) {
+	var ids []age.Identity
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := age.ParseIdentities(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		ids = append(ids, parsed...)
+	}
+	if v := os.Getenv("SLOTH_AGE_IDENTITY"); v != "" {
+		parsed, err := age.ParseIdentities(strings.NewReader(v))
+		if err != nil {
+			return nil, fmt.Errorf("SLOTH_AGE_IDENTITY: %w", err)
+		}
+		ids = append(ids, parsed...)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: no identity: use --identity FILE or set SLOTH_AGE_IDENTITY", errInvalidConfig)
+	}
+	return ids, nil
+}
+
+// decryptFile writes the plaintext of the age file src to dst with src's mode and times.
+// It refuses to overwrite dst; src is kept.
+func decryptFile(src, dst string, ids []age.Identity) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	r, err := age.Decrypt(in, ids...)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err := io.Copy(tmp, r); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := applyMetadata(src, tmp.Name(), fi, nil); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	ok = true
+	return nil
+}
+
+// cmdDecrypt restores files encrypted by rules with encrypt. Directories are searched
+// for .age files; plaintext is written next to each file, or into -o.
+func cmdDecrypt(opts *cliOptions, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sloth decrypt [--identity FILE] [-o DIR] <file.age|dir>...")
+		return exitConfig
+	}
+	ids, err := loadIdentities(opts.identity)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if opts.planOut != "" {
+		if err := os.MkdirAll(opts.planOut, 0755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+	}
+
+	var files []string
+	for _, arg := range args {
+		err := filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && (path == arg || strings.HasSuffix(path, ageExt)) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", arg, err)
+			return exitFailures
+		}
+	}
+
+	failed := 0
+	for _, src := range files {
+		dst := strings.TrimSuffix(src, ageExt)
+		if dst == src {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: not an %s file\n", src, ageExt)
+			continue
+		}
+		if opts.planOut != "" {
+			dst = filepath.Join(opts.planOut, filepath.Base(dst))
+		}
+		if err := decryptFile(src, dst, ids); err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %v\n", src, err)
+			continue
+		}
+		if opts.log.Verbose {
+			fmt.Printf("%s -> %s\n", src, dst)
+		}
+	}
+	fmt.Printf("%d file(s) decrypted, %d failed\n", len(files)-failed, failed)
+	if failed > 0 {
+		return exitFailures
+	}
+	return exitOK
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestEncryptedMoveAndDecrypt(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recipients := filepath.Join(dir, "pii.recipients")
+	if err := os.WriteFile(recipients, []byte("# PII archive\n"+id.Recipient().String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	content := "name,ssn\nJane,000-00-0000\n"
+	if err := os.WriteFile(filepath.Join(in, "people.csv"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &folder{Name: "PII", Input: in, Output: []string{out}, Extension: ".csv", FolderType: "4",
+		Compress: &compressOptions{Format: "gzip"}, Encrypt: &encryptOptions{RecipientsFile: recipients}}
+	if err := validateFolders([]folder{*f}); err != nil {
+		t.Fatal(err)
+	}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true}).WithRule(f.Name)
+	moveFile(al, &Balancer{}, f, defaultDirSettings, "people.csv", nil, false)
+	if n := al.counters.errorsCount.Load(); n != 0 {
+		t.Fatalf("%d errors during encrypted move", n)
+	}
+
+	enc := filepath.Join(out, "people.csv.gz.age")
+	data, err := os.ReadFile(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("Jane")) {
+		t.Fatal("output contains plaintext")
+	}
+	if !matchesExtension(filepath.Base(enc), ".csv", true) {
+		t.Error("retention would not match the encrypted file")
+	}
+
+	t.Setenv("SLOTH_AGE_IDENTITY", id.String())
+	opts := &cliOptions{}
+	if code := cmdDecrypt(opts, []string{out}); code != exitOK {
+		t.Fatalf("decrypt exit code %d", code)
+	}
+	fh, err := os.Open(filepath.Join(out, "people.csv.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	r, err := decompressReader("gzip", fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || string(got) != content {
+		t.Errorf("round trip = %q, %v", got, err)
+	}
+
+	// A second decrypt refuses to overwrite the restored file.
+	if code := cmdDecrypt(opts, []string{enc}); code != exitFailures {
+		t.Errorf("decrypt over existing file: exit code %d, want %d", code, exitFailures)
+	}
+}
+
+func TestEncryptRecipientsConfig(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PII_RECIPIENTS", id.Recipient().String()+", "+other.Recipient().String())
+	e := &encryptOptions{RecipientsEnv: "PII_RECIPIENTS"}
+	if rcpts, err := e.recipients(); err != nil || len(rcpts) != 2 {
+		t.Errorf("recipients from env = %d, %v", len(rcpts), err)
+	}
+
+	for name, bad := range map[string]*encryptOptions{
+		"none":      {},
+		"empty env": {RecipientsEnv: "SLOTH_TEST_UNSET_RECIPIENTS"},
+		"bad file":  {RecipientsFile: filepath.Join(t.TempDir(), "missing")},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+
+	if _, err := loadIdentities(nil); err == nil {
+		t.Error("loadIdentities without files or env: expected error")
+	}
+}
+
+func TestUndoSkipsEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "a.csv.age")
+	if err := os.WriteFile(dst, []byte("ciphertext"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j, err := openJournal(filepath.Join(dir, "state"), runInfo{ID: "e1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.record("PII", filepath.Join(dir, "a.csv"), dst, nil, true)
+	j.Close()
+
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	if restored, skipped := undoJournal(j.path, al, false); restored != 0 || skipped != 1 {
+		t.Errorf("restored=%d skipped=%d, want 0/1", restored, skipped)
+	}
+}
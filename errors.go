@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
+	"syscall"
+)
+
+// errorKind separates expected operational failures (a file vanished, a share is read-only,
+// the destination already exists) from internal bugs. Only internal errors get stack traces.
+type errorKind int
+
+const (
+	kindInternal errorKind = iota
+	kindNotFound
+	kindPermission
+	kindConflict
+	kindIO
+	kindConfig
+	kindGuard // a safety guard refused an operation
+
+	numErrorKinds // number of kinds, for per-kind counters
+)
+
+// errInvalidConfig marks failures caused by the content of the rules file.
+var errInvalidConfig = errors.New("invalid config")
+
+// errChecksumMismatch marks a file whose content changed between source and destination.
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// errStorage marks failures reported by a remote storage backend; see remoteStorage.
+var errStorage = errors.New("remote storage error")
+
+func (k errorKind) String() string {
+	switch k {
+	case kindNotFound:
+		return "not_found"
+	case kindPermission:
+		return "permission"
+	case kindConflict:
+		return "conflict"
+	case kindIO:
+		return "io"
+	case kindConfig:
+		return "config"
+	case kindGuard:
+		return "guard"
+	default:
+		return "internal"
+	}
+}
+
+// operational reports whether k is an expected environmental failure rather than a bug.
+func (k errorKind) operational() bool { return k != kindInternal }
+
+// classifyError maps err onto an errorKind using the wrapped fs/syscall errors.
+func classifyError(err error) errorKind {
+	var errno syscall.Errno
+	switch {
+	case errors.Is(err, errInvalidConfig):
+		return kindConfig
+	case errors.Is(err, errChecksumMismatch):
+		return kindIO
+	case errors.Is(err, fs.ErrNotExist):
+		return kindNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return kindPermission
+	case errors.Is(err, fs.ErrExist), errors.Is(err, syscall.ENOTEMPTY), errors.Is(err, syscall.EBUSY):
+		return kindConflict
+	case errors.As(err, &errno):
+		// Anything else the OS reported (disk full, cross-device, I/O error) is environmental.
+		return kindIO
+	case errors.Is(err, errStorage), errors.As(err, new(net.Error)):
+		// So are S3, SFTP and WebDAV errors, and the network under them (refused
+		// connections, timeouts, DNS failures).
+		return kindIO
+	default:
+		return kindInternal
+	}
+}
+
+// kindOf finds the first error among attrs (or printf args) and classifies it. Events that
+// carry no error value at all are treated as internal.
+func kindOf(attrs []slog.Attr, args []any) errorKind {
+	for _, a := range attrs {
+		if err, ok := a.Value.Any().(error); ok {
+			return classifyError(err)
+		}
+	}
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			return classifyError(err)
+		}
+	}
+	return kindInternal
+}
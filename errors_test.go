@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/sftp"
+	"github.com/studio-b12/gowebdav"
+)
+
+func TestRemoteStorageErrors(t *testing.T) {
+	dir := t.TempDir()
+	st := remoteStorage{localStorage{}}
+	_, err := st.Open(filepath.Join(dir, "missing"), 0)
+	if !errors.Is(err, errStorage) || !errors.Is(err, fs.ErrNotExist) || classifyError(err) != kindNotFound {
+		t.Errorf("Open of a missing file = %v, want a not-found storage error", err)
+	}
+	if _, err := st.Put(filepath.Join(dir, "a"), strings.NewReader("a"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	stop := errors.New("stop")
+	if err := st.List(dir, func(string, fs.FileInfo) error { return stop }); err != stop {
+		t.Errorf("List = %v, want the callback's error as it is", err)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	dir := t.TempDir()
+	_, notFound := os.Stat(filepath.Join(dir, "missing"))
+	existing := filepath.Join(dir, "exists")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	conflict := os.Mkdir(existing, 0755)
+
+	tests := []struct {
+		name string
+		err  error
+		want errorKind
+	}{
+		{"not found", notFound, kindNotFound},
+		{"wrapped not found", fmt.Errorf("stat: %w", notFound), kindNotFound},
+		{"permission", &os.PathError{Op: "open", Path: "/x", Err: os.ErrPermission}, kindPermission},
+		{"conflict", conflict, kindConflict},
+		{"config", fmt.Errorf("%w: rule 0: name is required", errInvalidConfig), kindConfig},
+		{"internal", errors.New("balancer: empty folders slice"), kindInternal},
+		{"s3", storageErr(minio.ErrorResponse{Code: "AccessDenied", Message: "Access Denied."}), kindIO},
+		{"sftp", fmt.Errorf("sftp://nas: %w", storageErr(&sftp.StatusError{Code: 4})), kindIO},
+		{"webdav", storageErr(&os.PathError{Op: "ReadDir", Path: "/x", Err: gowebdav.StatusError{Status: 502}}), kindIO},
+		{"remote not found", storageErr(&os.PathError{Op: "stat", Path: "/x", Err: os.ErrNotExist}), kindNotFound},
+		{"dns", fmt.Errorf("dial: %w", &net.DNSError{Err: "no such host", Name: "nas.invalid", IsNotFound: true}), kindIO},
+		{"refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, kindIO},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestConsoleErrors verifies operational errors print one line without a stack, internal
+// errors keep their stack, and --quiet hides operational errors.
+func TestConsoleErrors(t *testing.T) {
+	var console bytes.Buffer
+	orig := consoleOut
+	consoleOut = &console
+	defer func() { consoleOut = orig }()
+
+	_, notFound := os.Stat(filepath.Join(t.TempDir(), "missing.pdf"))
+
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{})
+	al.WithRule("Archive").ErrorAttrs("rename failed", srcAttr("/in/missing.pdf"), errAttr(notFound))
+	out := console.String()
+	if strings.Count(out, "\n") != 1 || strings.Contains(out, "STACK") {
+		t.Errorf("operational error should be a single line without stack, got:\n%s", out)
+	}
+	if !strings.HasPrefix(out, "ERROR: [Rule:Archive] rename failed") || !strings.Contains(out, "kind=not_found") {
+		t.Errorf("unexpected console line: %q", out)
+	}
+
+	console.Reset()
+	al.Error("Balancer error: %v", errors.New("balancer: empty folders slice"))
+	if !strings.Contains(console.String(), "STACK") {
+		t.Errorf("internal error should include a stack, got:\n%s", console.String())
+	}
+
+	console.Reset()
+	al.console = consoleQuiet
+	al.Error("delete failed: %v", notFound)
+	al.ErrorAttrs("upload failed", dstAttr("s3://bucket/a.pdf"), errAttr(storageErr(minio.ErrorResponse{Code: "SlowDown"})))
+	if console.Len() != 0 {
+		t.Errorf("quiet mode printed an operational error: %q", console.String())
+	}
+	if got := al.counters.errorsCount.Load(); got != 4 {
+		t.Errorf("errorsCount = %d, want 4", got)
+	}
+}
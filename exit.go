@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Process exit codes, so cron monitoring can tell outcomes apart.
+const (
+	exitOK          = 0 // everything succeeded
+	exitConfig      = 1 // invalid config or flags; nothing (or not everything) ran
+	exitFailures    = 2 // some operations failed (or warnings with --fail-on-warn)
+	exitInterrupted = 3 // stopped by SIGINT/SIGTERM before completing
+	exitAborted     = 4 // a safety guard refused to run a rule
+)
+
+// GuardAbort logs that a safety guard stopped an operation. The run exits with exitAborted.
+func (al *AppLogger) GuardAbort(msg string, attrs ...slog.Attr) {
+	al.counters.aborted.Store(true)
+	attrs = al.errorAttrs("safety guard: "+msg, attrs, kindGuard)
+	al.emit(slog.LevelError, "safety guard: "+msg, attrs)
+}
+
+// Interrupt asks running rules to stop feeding new files; in-flight files finish.
+func (al *AppLogger) Interrupt() { al.counters.interrupted.Store(true) }
+
+// Interrupted reports whether Interrupt has been called.
+func (al *AppLogger) Interrupted() bool { return al.counters.interrupted.Load() }
+
+// ExitCode derives the process exit code from the counters. Config errors win over guard
+// aborts, which win over interrupts, which win over ordinary failures.
+func (al *AppLogger) ExitCode(failOnWarn bool) int {
+	c := al.counters
+	switch {
+	case c.errorsByKind[kindConfig].Load() > 0:
+		return exitConfig
+	case c.aborted.Load():
+		return exitAborted
+	case c.interrupted.Load():
+		return exitInterrupted
+	case c.errorsCount.Load() > 0:
+		return exitFailures
+	case failOnWarn && c.warningsCount.Load() > 0:
+		return exitFailures
+	default:
+		return exitOK
+	}
+}
+
+// handleInterrupts makes the first SIGINT/SIGTERM stop the run gracefully and a second
+// one exit immediately. The returned function stops listening.
+func handleInterrupts(appLogger *AppLogger) func() {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			appLogger.Warn("Received %v, finishing in-flight files (send again to exit now)", sig)
+			appLogger.Interrupt()
+		case <-done:
+			return
+		}
+		select {
+		case <-sigs:
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
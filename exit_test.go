@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	_, notFound := os.Stat(filepath.Join(t.TempDir(), "missing"))
+	tests := []struct {
+		name       string
+		setup      func(al *AppLogger)
+		failOnWarn bool
+		want       int
+	}{
+		{"clean run", func(al *AppLogger) {}, false, exitOK},
+		{"warning only", func(al *AppLogger) { al.Warn("careful") }, false, exitOK},
+		{"warning with fail-on-warn", func(al *AppLogger) { al.Warn("careful") }, true, exitFailures},
+		{"failed operation", func(al *AppLogger) { al.Error("rename failed: %v", notFound) }, false, exitFailures},
+		{"interrupted", func(al *AppLogger) { al.Error("x: %v", errors.New("boom")); al.Interrupt() }, false, exitInterrupted},
+		{"guard abort", func(al *AppLogger) { al.Interrupt(); al.GuardAbort("nope") }, false, exitAborted},
+		{"config error", func(al *AppLogger) {
+			al.GuardAbort("nope")
+			al.Error("%v", fmt.Errorf("%w: bad rule", errInvalidConfig))
+		}, false, exitConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+			tt.setup(al)
+			if got := al.ExitCode(tt.failOnWarn); got != tt.want {
+				t.Errorf("ExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMissingOutputParentAborts verifies the output-parent guard maps to exitAborted and
+// moves nothing.
+func TestMissingOutputParentAborts(t *testing.T) {
+	base := t.TempDir()
+	inputDir := filepath.Join(base, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(inputDir, "a.txt")
+	if err := os.WriteFile(src, []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	rule := folder{Name: "Guarded", Input: inputDir, Output: []string{filepath.Join(base, "unmounted", "archive")}, Extension: ".txt", FolderType: "4"}
+	processFolder(al, &Balancer{}, &rule)
+
+	if got := al.ExitCode(false); got != exitAborted {
+		t.Errorf("ExitCode() = %d, want %d", got, exitAborted)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("source should not have moved: %v", err)
+	}
+}
+
+// TestInterruptSkipsRemainingFiles verifies no files are fed to workers once interrupted.
+func TestInterruptSkipsRemainingFiles(t *testing.T) {
+	base := t.TempDir()
+	inputDir := filepath.Join(base, "input")
+	outDir := filepath.Join(base, "out")
+	for _, d := range []string{inputDir, outDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte("a"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	al.Interrupt()
+	rule := folder{Name: "Stopped", Input: inputDir, Output: []string{outDir}, Extension: ".txt", FolderType: "4"}
+	processFolder(al, &Balancer{}, &rule)
+
+	rules, total := al.counters.stats.snapshot()
+	if len(rules) != 1 || total.Moved != 0 || total.Skipped != 2 {
+		t.Errorf("unexpected totals after interrupt: %+v", total)
+	}
+	if got := al.ExitCode(false); got != exitInterrupted {
+		t.Errorf("ExitCode() = %d, want %d", got, exitInterrupted)
+	}
+}
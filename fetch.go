@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// incomingDir holds the files downloaded from remote inputs until the rule moves them,
+// one directory per rule. It lives under --state-dir.
+var incomingDir = filepath.Join("state", "incoming")
+
+// stagingDir is where a rule with a remote input downloads files to. Its contents are
+// what the rest of the rule treats as the input.
+func stagingDir(rule string) string {
+	return filepath.Join(incomingDir, strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, rule))
+}
+
+// partialDir holds incomplete downloads; the rule never sees them as input.
+func partialDir(staging string) string { return filepath.Join(staging, ".partial") }
+
+// remoteStamp identifies one version of a remote file.
+type remoteStamp struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+func stampOf(fi fs.FileInfo) remoteStamp {
+	return remoteStamp{Size: fi.Size(), ModTime: fi.ModTime()}
+}
+
+func (s remoteStamp) matches(fi fs.FileInfo) bool {
+	return s.Size == fi.Size() && s.ModTime.Equal(fi.ModTime())
+}
+
+// fetchState is saved next to a staging directory. Fetched keeps remote files that are
+// left in place (no deleteRemote) from being downloaded again; Partial records which
+// version each partial download belongs to, so it is only resumed for that version.
+type fetchState struct {
+	path    string
+	Fetched map[string]remoteStamp `json:"fetched"`
+	Partial map[string]remoteStamp `json:"partial"`
+}
+
+func loadFetchState(staging string) (*fetchState, error) {
+	s := &fetchState{path: staging + ".state.json", Fetched: map[string]remoteStamp{}, Partial: map[string]remoteStamp{}}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	return s, nil
+}
+
+func (s *fetchState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// remoteFile is a file found on a remote input.
+type remoteFile struct {
+	loc string
+	fi  fs.FileInfo
+}
+
+// listRemote returns the files directly under the rule's remote input that match it and
+// were not downloaded before in their current version. Records of remote files that
+// are gone are dropped from state.
+func listRemote(st storage, f *folder, state *fetchState) ([]remoteFile, error) {
+	var files []remoteFile
+	seen := map[string]bool{}
+	err := st.List(f.Input, func(loc string, fi fs.FileInfo) error {
+		if dirLoc(loc) != f.Input {
+			return nil // like local inputs, subfolders are not scanned
+		}
+		seen[loc] = true
+		if !f.listsFile(fi.Name()) {
+			return nil
+		}
+		if stamp, ok := state.Fetched[loc]; ok && stamp.matches(fi) {
+			return nil
+		}
+		files = append(files, remoteFile{loc: loc, fi: fi})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for loc := range state.Fetched {
+		if !seen[loc] {
+			delete(state.Fetched, loc)
+		}
+	}
+	return files, nil
+}
+
+// download copies rf into staging under its own name and returns the bytes received.
+// A partial download of the same version is resumed; one of another version is
+// discarded. The file gets the remote modification time, which the output layout uses.
+func download(st storage, state *fetchState, staging string, rf remoteFile) (int64, error) {
+	dst := filepath.Join(staging, rf.fi.Name())
+	if _, err := os.Lstat(dst); err == nil {
+		return 0, fmt.Errorf("%s is already staged and waiting to be moved", dst)
+	}
+	part := filepath.Join(partialDir(staging), rf.fi.Name())
+	if err := os.MkdirAll(filepath.Dir(part), 0755); err != nil {
+		return 0, err
+	}
+
+	var offset int64
+	if stamp, ok := state.Partial[rf.loc]; ok && stamp.matches(rf.fi) {
+		if pi, err := os.Stat(part); err == nil && pi.Size() <= rf.fi.Size() {
+			offset = pi.Size()
+		}
+	}
+	if offset == 0 {
+		if err := os.Remove(part); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, err
+		}
+	}
+	state.Partial[rf.loc] = stampOf(rf.fi)
+	if err := state.save(); err != nil {
+		return 0, err
+	}
+
+	n, err := fetchInto(st, rf.loc, part, offset)
+	if err != nil {
+		return n, err // the partial file is kept for the next run
+	}
+	if err := checkDownload(st, rf, part); err != nil {
+		os.Remove(part)
+		delete(state.Partial, rf.loc)
+		state.save()
+		return n, err
+	}
+	if err := os.Chtimes(part, rf.fi.ModTime(), rf.fi.ModTime()); err != nil {
+		return n, err
+	}
+	if err := os.Rename(part, dst); err != nil {
+		return n, err
+	}
+	delete(state.Partial, rf.loc)
+	state.Fetched[rf.loc] = stampOf(rf.fi)
+	return n, state.save()
+}
+
+// fetchInto appends loc, from offset on, to the local file part and syncs it.
+func fetchInto(st storage, loc, part string, offset int64) (int64, error) {
+	rc, err := st.Open(loc, offset)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	out, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, rc)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// checkDownload verifies a finished download: it must have the listed size, the remote
+// file must not have changed meanwhile, and its MD5 must match where the backend
+// reports one.
+func checkDownload(st storage, rf remoteFile, part string) error {
+	pi, err := os.Stat(part)
+	if err != nil {
+		return err
+	}
+	if pi.Size() != rf.fi.Size() {
+		return fmt.Errorf("%w: downloaded %d bytes, %s has %d", errChecksumMismatch, pi.Size(), rf.loc, rf.fi.Size())
+	}
+	now, err := st.Stat(rf.loc)
+	if err != nil {
+		return err
+	}
+	if !stampOf(rf.fi).matches(now) {
+		return fmt.Errorf("%s changed during the download", rf.loc)
+	}
+	if ri, ok := rf.fi.(remoteFileInfo); ok && ri.md5 != "" {
+		f, err := os.Open(part)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := md5.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != ri.md5 {
+			return fmt.Errorf("%w: %s has MD5 %s, download has %s", errChecksumMismatch, rf.loc, ri.md5, got)
+		}
+	}
+	return nil
+}
+
+// fetchFile downloads rf, removes it from the remote input when deleteRemote is set, and
+// logs the outcome. It reports whether the file is now staged.
+func fetchFile(appLogger *AppLogger, st storage, state *fetchState, staging string, rf remoteFile, deleteRemote bool) bool {
+	start := time.Now()
+	n, err := download(st, state, staging, rf)
+	if err != nil {
+		appLogger.CountFailed(dirLoc(rf.loc))
+		appLogger.ErrorAttrs("download failed", srcAttr(rf.loc), dstAttr(staging), bytesAttr(n), errAttr(err))
+		return false
+	}
+	appLogger.DebugAttrs("Downloaded", srcAttr(rf.loc), dstAttr(staging), bytesAttr(n), durationAttr(time.Since(start)))
+	if deleteRemote {
+		if err := st.Delete(rf.loc); err != nil {
+			appLogger.WarnAttrs("downloaded file could not be removed from the remote input", srcAttr(rf.loc), errAttr(err))
+		}
+	}
+	return true
+}
+
+// fetchRemote downloads the new files of a rule with a remote input into its staging
+// directory and returns that directory. Files staged by earlier runs that were not
+// moved yet stay there and are moved along with the new ones. Dry runs only list.
+func fetchRemote(appLogger *AppLogger, f *folder, localDryRun bool) (string, bool) {
+	staging := stagingDir(f.Name)
+	st, err := storageFor(f.Input)
+	if err != nil {
+		appLogger.ErrorAttrs("cannot connect to input", srcAttr(f.Input), errAttr(err))
+		return "", false
+	}
+	if !localDryRun {
+		if err := os.MkdirAll(staging, 0755); err != nil {
+			appLogger.ErrorAttrs("mkdir failed", dstAttr(staging), errAttr(err))
+			return "", false
+		}
+	}
+	state, err := loadFetchState(staging)
+	if err != nil {
+		appLogger.ErrorAttrs("failed to read download state", srcAttr(staging), errAttr(err))
+		return "", false
+	}
+	files, err := listRemote(st, f, state)
+	if err != nil {
+		appLogger.ErrorAttrs("ReadDir error", srcAttr(f.Input), errAttr(err))
+		return "", false
+	}
+
+	for i, rf := range files {
+		if appLogger.Interrupted() {
+			appLogger.CountSkipped(len(files) - i)
+			break
+		}
+		if localDryRun {
+			if i < dryRunSampleLimit {
+				appLogger.InfoAttrs("[DRY-RUN] Would download", srcAttr(rf.loc), dstAttr(staging), bytesAttr(rf.fi.Size()))
+			}
+			continue
+		}
+		fetchFile(appLogger, st, state, staging, rf, f.DeleteRemote)
+	}
+	if !localDryRun {
+		if err := state.save(); err != nil {
+			appLogger.WarnAttrs("failed to save download state", dstAttr(state.path), errAttr(err))
+		}
+	}
+	return staging, true
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useIncomingDir points incomingDir at a temporary directory for one test.
+func useIncomingDir(t *testing.T) string {
+	t.Helper()
+	prev := incomingDir
+	incomingDir = filepath.Join(t.TempDir(), "incoming")
+	t.Cleanup(func() { incomingDir = prev })
+	return incomingDir
+}
+
+func TestRemoteInputRule(t *testing.T) {
+	useIncomingDir(t)
+	dir := t.TempDir()
+	served := filepath.Join(dir, "served")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{filepath.Join(served, "drop", "sub"), out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	day := time.Date(2024, 3, 5, 9, 0, 0, 0, time.Local)
+	drop := filepath.Join(served, "drop")
+	writeAged(t, drop, "vendor.csv", "a,b\n", day)
+	writeAged(t, drop, "readme.txt", "not matched", day)
+	writeAged(t, filepath.Join(drop, "sub"), "nested.csv", "not scanned", day)
+
+	rule := folder{Name: "Vendor drop", Input: newWebDAVServer(t, served) + "/drop", Output: []string{out},
+		Extension: ".csv", FolderType: "1", DeleteRemote: true}
+	if err := validateFolders([]folder{rule}); err != nil {
+		t.Fatal(err)
+	}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	processFolder(al, &Balancer{}, &rule)
+	if n := al.counters.errorsCount.Load(); n != 0 {
+		t.Fatalf("%d errors during fetch", n)
+	}
+	moved := filepath.Join(out, "2024", "3", "Day 5", "vendor.csv")
+	if data, err := os.ReadFile(moved); err != nil || string(data) != "a,b\n" {
+		t.Fatalf("moved file = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(drop, "vendor.csv")); !os.IsNotExist(err) {
+		t.Errorf("remote file not deleted: %v", err)
+	}
+	for _, kept := range []string{filepath.Join(drop, "readme.txt"), filepath.Join(drop, "sub", "nested.csv")} {
+		if _, err := os.Stat(kept); err != nil {
+			t.Errorf("%s: %v", kept, err)
+		}
+	}
+
+	// Without deleteRemote the file stays, and is only fetched again once it changes.
+	rule.DeleteRemote = false
+	writeAged(t, drop, "orders.csv", "1\n", day)
+	processFolder(al, &Balancer{}, &rule)
+	second := filepath.Join(out, "2024", "3", "Day 5", "orders.csv")
+	if err := os.Remove(second); err != nil {
+		t.Fatalf("orders.csv not moved: %v", err)
+	}
+	processFolder(al, &Balancer{}, &rule)
+	if _, err := os.Stat(second); !os.IsNotExist(err) {
+		t.Errorf("unchanged remote file fetched twice: %v", err)
+	}
+	writeAged(t, drop, "orders.csv", "1\n2\n", day.Add(time.Hour))
+	processFolder(al, &Balancer{}, &rule)
+	if data, err := os.ReadFile(second); err != nil || string(data) != "1\n2\n" {
+		t.Errorf("changed remote file = %q, %v", data, err)
+	}
+	if n := al.counters.errorsCount.Load(); n != 0 {
+		t.Errorf("%d errors", n)
+	}
+}
+
+func TestDownloadResumesOrDiscardsPartial(t *testing.T) {
+	dir := t.TempDir()
+	src := writeAged(t, dir, "big.bin", "0123456789", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	fi, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf := remoteFile{loc: src, fi: fi}
+
+	for name, tc := range map[string]struct {
+		stamp remoteStamp
+		part  string
+		want  int64 // bytes transferred
+	}{
+		"resume":  {stamp: stampOf(fi), part: "0123", want: 6},
+		"discard": {stamp: remoteStamp{Size: 10}, part: "XXXX", want: 10},
+	} {
+		staging := filepath.Join(dir, name)
+		if err := os.MkdirAll(partialDir(staging), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(partialDir(staging), "big.bin"), []byte(tc.part), 0644); err != nil {
+			t.Fatal(err)
+		}
+		state, err := loadFetchState(staging)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state.Partial[src] = tc.stamp
+
+		n, err := download(localStorage{}, state, staging, rf)
+		if err != nil || n != tc.want {
+			t.Fatalf("%s: download = %d, %v; want %d bytes", name, n, err, tc.want)
+		}
+		staged := filepath.Join(staging, "big.bin")
+		if data, err := os.ReadFile(staged); err != nil || string(data) != "0123456789" {
+			t.Errorf("%s: staged = %q, %v", name, data, err)
+		}
+		if sfi, err := os.Stat(staged); err != nil || !sfi.ModTime().Equal(fi.ModTime()) {
+			t.Errorf("%s: staged mtime not taken from the remote file", name)
+		}
+		saved, _ := loadFetchState(staging)
+		if len(saved.Partial) != 0 || !saved.Fetched[src].matches(fi) {
+			t.Errorf("%s: saved state = %+v", name, saved)
+		}
+	}
+}
+
+func TestPlanRemoteInput(t *testing.T) {
+	useIncomingDir(t)
+	dir := t.TempDir()
+	served := filepath.Join(dir, "served")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{filepath.Join(served, "drop"), out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeAged(t, filepath.Join(served, "drop"), "a.csv", "a", time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local))
+	input := newWebDAVServer(t, served) + "/drop"
+	rules := []folder{{Name: "Drop", Input: input, Output: []string{out}, Extension: ".csv", FolderType: "5"}}
+
+	plan := buildPlan(rules)
+	staged := filepath.Join(stagingDir("Drop"), "a.csv")
+	var ops []string
+	for _, op := range plan.Rules[0].Ops {
+		ops = append(ops, op.Op+" "+op.Src+" "+op.Dst)
+	}
+	want := []string{
+		"download " + input + "/a.csv " + staged,
+		"mkdir  " + filepath.Join(out, "202406"),
+		"move " + staged + " " + filepath.Join(out, "202406", "a.csv"),
+	}
+	if len(ops) != len(want) {
+		t.Fatalf("ops = %q, want %q", ops, want)
+	}
+	for i := range want {
+		if ops[i] != want[i] {
+			t.Errorf("op %d = %q, want %q", i, ops[i], want[i])
+		}
+	}
+
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	if stale := applyPlan(al, plan, false); len(stale) != 0 {
+		t.Fatalf("stale entries: %+v", stale)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "202406", "a.csv")); err != nil || string(data) != "a" {
+		t.Errorf("applied move = %q, %v", data, err)
+	}
+}
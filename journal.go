@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// journalEntry records one completed move so `sloth undo` can reverse it.
+type journalEntry struct {
+	Time time.Time `json:"time"`
+	Rule string    `json:"rule,omitempty"`
+	Src  string    `json:"src"`
+	Dst  string    `json:"dst"`
+
+	Compress string `json:"compress,omitempty"` // format Dst was compressed with
+	Member   string `json:"member,omitempty"`   // name of the file inside the bundle Dst
+
+	Encrypted bool `json:"encrypted,omitempty"` // Dst is age-encrypted; undo needs `sloth decrypt`
+
+	Action string `json:"action,omitempty"` // copy or link action that left Src in place; undo removes Dst
+}
+
+// moveJournal appends one JSON line per move to <state-dir>/journal/<start>-<run id>.jsonl.
+type moveJournal struct {
+	mu   sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+	path string
+	n    int
+}
+
+// journal is the move journal of the current run. It is nil for dry runs, and its
+// methods are no-ops on nil.
+var journal *moveJournal
+
+func journalDir(stateDir string) string { return filepath.Join(stateDir, "journal") }
+
+// openJournal creates the journal file for run.
+func openJournal(stateDir string, run runInfo) (*moveJournal, error) {
+	dir := journalDir(stateDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, run.Start.UTC().Format("20060102T150405Z")+"-"+run.ID+".jsonl")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &moveJournal{f: f, enc: json.NewEncoder(f), path: path}, nil
+}
+
+// record appends a completed move, compressed with c if set. Each entry is written
+// straight to the file so a crash loses at most the entry being written.
+func (j *moveJournal) record(rule, src, dst string, c *compressOptions, encrypted bool) {
+	if j == nil {
+		return
+	}
+	e := journalEntry{Rule: rule, Src: src, Dst: dst, Encrypted: encrypted}
+	if c != nil {
+		e.Compress = c.Format
+	}
+	j.write(e)
+}
+
+// recordAction appends a file that a copy or link action placed at dst, leaving src.
+func (j *moveJournal) recordAction(rule, action, src, dst string, c *compressOptions, encrypted bool) {
+	if j == nil {
+		return
+	}
+	e := journalEntry{Rule: rule, Src: src, Dst: dst, Encrypted: encrypted, Action: action}
+	if c != nil {
+		e.Compress = c.Format
+	}
+	j.write(e)
+}
+
+// recordMember appends a file that was packed into bundle as member.
+func (j *moveJournal) recordMember(rule, src, bundle, member string) {
+	if j == nil {
+		return
+	}
+	j.write(journalEntry{Rule: rule, Src: src, Dst: bundle, Member: member})
+}
+
+func (j *moveJournal) write(e journalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e.Time = time.Now()
+	if err := j.enc.Encode(e); err == nil {
+		j.n++
+	}
+}
+
+// Close closes the journal, removing it if nothing was moved.
+func (j *moveJournal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	err := j.f.Close()
+	if j.n == 0 {
+		_ = os.Remove(j.path)
+	}
+	return err
+}
+
+// findJournal returns the journal for runID, or the most recent one if runID is empty.
+// Journals that were already undone are ignored.
+func findJournal(stateDir, runID string) (string, error) {
+	dir := journalDir(stateDir)
+	matches, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(matches)
+	for i := len(matches) - 1; i >= 0; i-- {
+		if runID == "" || strings.HasSuffix(matches[i], "-"+runID+".jsonl") {
+			return matches[i], nil
+		}
+	}
+	if runID != "" {
+		return "", fmt.Errorf("%w: no undo journal for run %s in %s", os.ErrNotExist, runID, dir)
+	}
+	return "", fmt.Errorf("%w: no undo journal in %s", os.ErrNotExist, dir)
+}
+
+func readJournal(path string) ([]journalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []journalEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			// A torn last line from a crash; everything before it is still valid.
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// undoJournal moves every journaled file back to its source, newest first. Entries whose
+// destination is gone or whose source path is occupied again are skipped. Files placed
+// by a copy or link action are removed instead, as long as their source is still there.
+// Once applied, the journal is renamed to .undone so it cannot be replayed.
+func undoJournal(path string, appLogger *AppLogger, dryRun bool) (restored, skipped int) {
+	entries, err := readJournal(path)
+	if err != nil {
+		appLogger.ErrorAttrs("failed to read undo journal", srcAttr(path), errAttr(err))
+		return 0, 0
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		ruleLog := appLogger.WithRule(e.Rule)
+		if isRemote(e.Dst) {
+			skipped++
+			ruleLog.WarnAttrs("undo: file was uploaded to remote storage, leaving it in place", srcAttr(e.Src), dstAttr(e.Dst))
+			continue
+		}
+		if _, err := os.Lstat(e.Dst); err != nil {
+			skipped++
+			ruleLog.WarnAttrs("undo: moved file is gone", srcAttr(e.Src), dstAttr(e.Dst), errAttr(err))
+			continue
+		}
+		if e.Action != "" {
+			if ok := undoAction(ruleLog, e, dryRun); ok {
+				restored++
+			} else {
+				skipped++
+			}
+			continue
+		}
+		if _, err := os.Lstat(e.Src); err == nil {
+			skipped++
+			ruleLog.WarnAttrs("undo: original path is occupied, leaving file in place", srcAttr(e.Src), dstAttr(e.Dst))
+			continue
+		} else if !errors.Is(err, os.ErrNotExist) {
+			skipped++
+			ruleLog.ErrorAttrs("undo: cannot check original path", srcAttr(e.Src), errAttr(err))
+			continue
+		}
+		if e.Encrypted {
+			skipped++
+			ruleLog.WarnAttrs("undo: file was encrypted, restore it with `sloth decrypt`", srcAttr(e.Src), dstAttr(e.Dst))
+			continue
+		}
+		if dryRun {
+			restored++
+			ruleLog.InfoAttrs("[DRY-RUN] Would restore", srcAttr(e.Dst), dstAttr(e.Src))
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(e.Src), 0755); err != nil {
+			skipped++
+			ruleLog.ErrorAttrs("undo: mkdir failed", dstAttr(filepath.Dir(e.Src)), errAttr(err))
+			continue
+		}
+		if e.Member != "" {
+			// The bundle may hold other files too, so the member is copied out and
+			// the bundle left as it is.
+			if err := extractMember(e.Dst, e.Member, e.Src); err != nil {
+				skipped++
+				ruleLog.ErrorAttrs("undo: extract failed", srcAttr(e.Dst), dstAttr(e.Src), errAttr(err))
+				continue
+			}
+		} else if e.Compress != "" {
+			if err := decompressFile(e.Dst, e.Src, e.Compress); err != nil {
+				skipped++
+				ruleLog.ErrorAttrs("undo: decompress failed", srcAttr(e.Dst), dstAttr(e.Src), errAttr(err))
+				continue
+			}
+		} else if err := os.Rename(e.Dst, e.Src); err != nil {
+			skipped++
+			ruleLog.ErrorAttrs("undo: rename failed", srcAttr(e.Dst), dstAttr(e.Src), errAttr(err))
+			continue
+		}
+		restored++
+		ruleLog.InfoAttrs("Restored", srcAttr(e.Dst), dstAttr(e.Src))
+	}
+
+	if !dryRun {
+		if err := os.Rename(path, strings.TrimSuffix(path, ".jsonl")+".undone"); err != nil {
+			appLogger.ErrorAttrs("failed to retire undo journal", srcAttr(path), errAttr(err))
+		}
+	}
+	return restored, skipped
+}
+
+// undoAction removes a file placed by a copy or link action. The source must still exist,
+// since a copy may be the only one left.
+func undoAction(ruleLog *AppLogger, e journalEntry, dryRun bool) bool {
+	if _, err := os.Stat(e.Src); err != nil {
+		ruleLog.WarnAttrs("undo: source of "+e.Action+" is gone, leaving file in place", srcAttr(e.Src), dstAttr(e.Dst), errAttr(err))
+		return false
+	}
+	if dryRun {
+		ruleLog.InfoAttrs("[DRY-RUN] Would remove", dstAttr(e.Dst))
+		return true
+	}
+	if err := os.Remove(e.Dst); err != nil {
+		ruleLog.ErrorAttrs("undo: remove failed", dstAttr(e.Dst), errAttr(err))
+		return false
+	}
+	ruleLog.InfoAttrs("Removed "+e.Action, srcAttr(e.Src), dstAttr(e.Dst))
+	return true
+}
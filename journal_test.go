@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalUndo(t *testing.T) {
+	dir := t.TempDir()
+	state := filepath.Join(dir, "state")
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out", "2024")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := runInfo{ID: "abc123", Start: time.Now()}
+	j, err := openJournal(state, run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a.txt was moved and can be restored; b.txt's original path is occupied again;
+	// c.txt was deleted from the archive since.
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if name != "c.txt" {
+			if err := os.WriteFile(filepath.Join(out, name), []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		j.record("Archive", filepath.Join(in, name), filepath.Join(out, name), nil, false)
+	}
+	if err := os.WriteFile(filepath.Join(in, "b.txt"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := findJournal(state, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := findJournal(state, "abc123"); err != nil || p != path {
+		t.Fatalf("findJournal by id = %q, %v; want %q", p, err, path)
+	}
+
+	var buf bytes.Buffer
+	al := newTestLogger(t, &buf, logConfig{Quiet: true})
+	restored, skipped := undoJournal(path, al, false)
+	if restored != 1 || skipped != 2 {
+		t.Errorf("restored=%d skipped=%d, want 1 and 2", restored, skipped)
+	}
+	if data, err := os.ReadFile(filepath.Join(in, "a.txt")); err != nil || string(data) != "a.txt" {
+		t.Errorf("a.txt not restored: %q, %v", data, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(in, "b.txt")); string(data) != "new" {
+		t.Errorf("b.txt overwritten: %q", data)
+	}
+	if !strings.Contains(buf.String(), "original path is occupied") {
+		t.Errorf("conflict not logged:\n%s", buf.String())
+	}
+	if _, err := findJournal(state, ""); err == nil {
+		t.Error("undone journal can still be found")
+	}
+}
+
+func TestJournalEmptyRemoved(t *testing.T) {
+	state := t.TempDir()
+	j, err := openJournal(state, runInfo{ID: "empty", Start: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findJournal(state, ""); err == nil {
+		t.Error("journal of a run without moves was kept")
+	}
+
+	var nilJournal *moveJournal
+	nilJournal.record("r", "a", "b", nil, false) // must not panic
+}
@@ -1,92 +1,430 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"runtime"
 	"runtime/debug"
+	"strings"
 	"sync/atomic"
 	"time"
+)
+
+// logConfig selects the log record format, level, destination and file rotation policy.
+type logConfig struct {
+	Format string // "text" (default) or "json"
+	Level  string // "debug", "info" (default), "warn" or "error"
+	Sink   string // "file" (default), "stdout", "syslog", "journald" or "none"
+
+	// Console echo on stderr, independent of the sink.
+	Quiet   bool // only internal errors
+	Verbose bool // also warnings and info events, with stacks for every error
+
+	// File sink only.
+	Dir         string        // directory for sloth.log and rotated files
+	RotateEvery time.Duration // start a new file after this period
+	MaxSizeMB   int64         // also rotate once the file reaches this size (0 = no size limit)
+	MaxBackups  int           // rotated files to keep (0 = unlimited)
+	MaxAge      time.Duration // remove rotated files older than this (0 = keep forever)
+	Compress    bool          // gzip rotated files
+}
+
+// defaultLogConfig returns the historical behavior (daily files in logs/, kept 30 days),
+// with format, level, sink and directory overridable by SLOTH_LOG_* environment variables.
+func defaultLogConfig() logConfig {
+	return logConfig{
+		Format:      envOr("SLOTH_LOG_FORMAT", "text"),
+		Level:       envOr("SLOTH_LOG_LEVEL", "info"),
+		Sink:        envOr("SLOTH_LOG_SINK", "file"),
+		Dir:         envOr("SLOTH_LOG_DIR", "logs"),
+		RotateEvery: 24 * time.Hour,
+		MaxAge:      30 * 24 * time.Hour,
+	}
+}
+
+// registerFlags binds cfg to command-line flags, using its current values as defaults.
+func (cfg *logConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Format, "log-format", cfg.Format, "log record format: text or json")
+	fs.StringVar(&cfg.Level, "log-level", cfg.Level, "minimum log level: debug, info, warn or error")
+	fs.StringVar(&cfg.Sink, "log-sink", cfg.Sink, "log destination: file, stdout, syslog, journald or none (console output only)")
+	fs.StringVar(&cfg.Dir, "log-dir", cfg.Dir, "directory for log files (file sink)")
+	fs.DurationVar(&cfg.RotateEvery, "log-rotate", cfg.RotateEvery, "start a new log file after this period")
+	fs.Int64Var(&cfg.MaxSizeMB, "log-max-size", cfg.MaxSizeMB, "also rotate when the log file reaches this many MB (0 = off)")
+	fs.IntVar(&cfg.MaxBackups, "log-max-backups", cfg.MaxBackups, "rotated log files to keep (0 = unlimited)")
+	fs.DurationVar(&cfg.MaxAge, "log-max-age", cfg.MaxAge, "remove rotated log files older than this (0 = keep forever)")
+	fs.BoolVar(&cfg.Compress, "log-compress", cfg.Compress, "gzip rotated log files")
+	fs.BoolVar(&cfg.Quiet, "quiet", cfg.Quiet, "console: print only internal errors")
+	fs.BoolVar(&cfg.Verbose, "verbose", cfg.Verbose, "console: also print info and warnings, with stacks for every error")
+}
+
+// consoleOut is where AppLogger echoes events for the operator (stderr).
+var consoleOut io.Writer = os.Stderr
 
-	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+// consoleVerbosity controls what AppLogger echoes to stderr.
+type consoleVerbosity int
+
+const (
+	consoleNormal  consoleVerbosity = iota // one line per error, stacks for internal errors
+	consoleQuiet                           // internal errors only
+	consoleVerbose                         // every event, stacks for every error
 )
 
-// AppLogger implements structured rotating logging with counters
-type AppLogger struct {
-	fileLogger *log.Logger
-	dryRun     bool
+func (cfg *logConfig) console() consoleVerbosity {
+	switch {
+	case cfg.Verbose:
+		return consoleVerbose
+	case cfg.Quiet:
+		return consoleQuiet
+	default:
+		return consoleNormal
+	}
+}
 
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// logCounters are shared by an AppLogger and every child created with WithRule.
+type logCounters struct {
 	filesProcessed atomic.Int64
 	rulesExecuted  atomic.Int64
 	errorsCount    atomic.Int64
 	warningsCount  atomic.Int64
+	errorsByKind   [numErrorKinds]atomic.Int64
+	aborted        atomic.Bool // a safety guard stopped a rule
+	interrupted    atomic.Bool // SIGINT/SIGTERM received
+	stats          *runStats
+	outputs        atomic.Pointer[[]string] // output roots of the loaded rules; see SetOutputs
+}
+
+func newLogCounters() *logCounters {
+	return &logCounters{stats: newRunStats()}
+}
+
+// AppLogger implements structured rotating logging with counters
+type AppLogger struct {
+	logger   *slog.Logger
+	dryRun   bool
+	counters *logCounters
+	console  consoleVerbosity
+	rule     string // set by WithRule, used to prefix console lines
 }
 
+// NewAppLogger creates a logger with the default configuration.
 func NewAppLogger(dryRun bool) *AppLogger {
-	// Ensure directory exists (log early if it fails, but continue so program can still run)
-	if err := os.MkdirAll("logs", 0755); err != nil {
-		log.Printf("failed to create logs directory: %v", err)
+	al, err := newAppLogger(dryRun, defaultLogConfig())
+	if err != nil {
+		log.Printf("invalid log config: %v", err)
 	}
+	return al
+}
 
-	// Remove existing symlink if it exists to avoid permission issues
-	// (allows rotatelogs to recreate with current user permissions)
-	symlinkPath := "logs/sloth.log"
-	if _, err := os.Lstat(symlinkPath); err == nil {
-		_ = os.Remove(symlinkPath)
+// newAppLogger creates a logger using cfg. An invalid cfg is reported but still yields a
+// usable logger (falling back to stderr) so the program can run.
+func newAppLogger(dryRun bool, cfg logConfig) (*AppLogger, error) {
+	sink, sinkErr := openLogSink(&cfg)
+	if sinkErr != nil {
+		// fallback to stderr to avoid losing records
+		sink = writerSink{os.Stderr}
+	}
+	out := &levelWriter{sink: sink}
+	inner, err := newLogHandler(out, cfg)
+	if err == nil {
+		err = sinkErr
 	}
+	h := &sinkHandler{inner: inner, out: out}
+	return &AppLogger{logger: slog.New(h), dryRun: dryRun, counters: newLogCounters(), console: cfg.console()}, err
+}
 
-	// Set up rotatelogs
-	rotator, err := rotatelogs.New(
-		"logs/sloth-%Y%m%d.log",
-		rotatelogs.WithLinkName("logs/sloth.log"),
-		rotatelogs.WithMaxAge(30*24*time.Hour),
-		rotatelogs.WithRotationTime(24*time.Hour),
-	)
-	if err != nil {
-		log.Printf("failed to create rotatelogs: %v", err)
-		// fallback to standard logger to avoid nil pointer
-		return &AppLogger{fileLogger: log.Default(), dryRun: dryRun}
+// newLogHandler builds the slog handler for cfg writing to w. On error it still returns a
+// text handler at info level.
+func newLogHandler(w io.Writer, cfg logConfig) (slog.Handler, error) {
+	var level slog.Level
+	var cfgErr error
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			cfgErr = fmt.Errorf("%w: log level %q: %w", errInvalidConfig, cfg.Level, err)
+			level = slog.LevelInfo
+		}
+	}
+
+	opts := &slog.HandlerOptions{AddSource: true, Level: level, ReplaceAttr: shortSource}
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), cfgErr
+	case "json":
+		return slog.NewJSONHandler(w, opts), cfgErr
+	default:
+		return slog.NewTextHandler(w, opts), fmt.Errorf("%w: log format %q: must be text or json", errInvalidConfig, cfg.Format)
+	}
+}
+
+// shortSource trims the source attribute to file:line, like log.Lshortfile.
+func shortSource(_ []string, a slog.Attr) slog.Attr {
+	if src, ok := a.Value.Any().(*slog.Source); ok && a.Key == slog.SourceKey {
+		a.Value = slog.StringValue(fmt.Sprintf("%s:%d", filepath.Base(src.File), src.Line))
+	}
+	return a
+}
+
+// Attribute helpers keep field names consistent across every log event.
+func srcAttr(path string) slog.Attr          { return slog.String("src", path) }
+func dstAttr(path string) slog.Attr          { return slog.String("dst", path) }
+func bytesAttr(n int64) slog.Attr            { return slog.Int64("bytes", n) }
+func durationAttr(d time.Duration) slog.Attr { return slog.Duration("duration", d) }
+func errAttr(err error) slog.Attr            { return slog.Any("error", err) }
+
+// WithRule returns a logger that tags every event with the rule name. Counters are shared
+// with the parent.
+func (al *AppLogger) WithRule(name string) *AppLogger {
+	child := *al
+	child.logger = al.logger.With(slog.String("rule", name))
+	child.rule = name
+	return &child
+}
+
+// emit writes a record with the source location of the public method's caller.
+// Every public logging method must call emit directly so the frame count stays fixed.
+func (al *AppLogger) emit(level slog.Level, msg string, attrs []slog.Attr) {
+	ctx := context.Background()
+	if !al.logger.Enabled(ctx, level) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:]) // skip Callers, emit and the public method
+	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	r.AddAttrs(attrs...)
+	_ = al.logger.Handler().Handle(ctx, r)
+}
+
+// echo prints a concise one-line version of an event to stderr, followed by a stack
+// trace when requested.
+func (al *AppLogger) echo(level slog.Level, msg string, attrs []slog.Attr, stack bool) {
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteString(": ")
+	if al.rule != "" {
+		fmt.Fprintf(&b, "[Rule:%s] ", al.rule)
 	}
+	b.WriteString(msg)
+	for _, a := range attrs {
+		if a.Key != "stack" {
+			fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		}
+	}
+	fmt.Fprintln(consoleOut, b.String())
+	if stack {
+		fmt.Fprintf(consoleOut, "STACK:\n%s\n", debug.Stack())
+	}
+}
+
+// errorAttrs classifies an error event, tags it with its kind (and a stack for internal
+// errors or debug logging) and echoes it to the console according to verbosity.
+func (al *AppLogger) errorAttrs(msg string, attrs []slog.Attr, kind errorKind) []slog.Attr {
+	al.counters.errorsCount.Add(1)
+	al.counters.errorsByKind[kind].Add(1)
+	wantStack := !kind.operational() || al.console == consoleVerbose ||
+		al.logger.Enabled(context.Background(), slog.LevelDebug)
+
+	al.counters.stats.addFailure(newFailureRecord(al.rule, msg, attrs, kind))
+	attrs = append(attrs, slog.String("kind", kind.String()))
+	if wantStack {
+		attrs = append(attrs, slog.String("stack", string(debug.Stack())))
+	}
+	if al.console != consoleQuiet || !kind.operational() {
+		al.echo(slog.LevelError, msg, attrs, wantStack)
+	}
+	return attrs
+}
+
+// newFailureRecord extracts the paths and error of an error event for the run report.
+func newFailureRecord(rule, msg string, attrs []slog.Attr, kind errorKind) failureRecord {
+	f := failureRecord{Time: time.Now(), Rule: rule, Message: msg, Kind: kind.String()}
+	for _, a := range attrs {
+		switch a.Key {
+		case "src":
+			f.Src = a.Value.String()
+		case "dst":
+			f.Dst = a.Value.String()
+		case "error":
+			f.Error = a.Value.String()
+		}
+	}
+	return f
+}
 
-	l := log.New(rotator, "", log.Ldate|log.Ltime|log.Lshortfile)
-	return &AppLogger{fileLogger: l, dryRun: dryRun}
+// DebugAttrs logs a per-file detail event.
+func (al *AppLogger) DebugAttrs(msg string, attrs ...slog.Attr) {
+	al.emit(slog.LevelDebug, msg, attrs)
+}
+
+// InfoAttrs logs an informational event with typed fields.
+func (al *AppLogger) InfoAttrs(msg string, attrs ...slog.Attr) {
+	al.emit(slog.LevelInfo, msg, attrs)
+	if al.console == consoleVerbose {
+		al.echo(slog.LevelInfo, msg, attrs, false)
+	}
+}
+
+// WarnAttrs logs a warning event with typed fields.
+func (al *AppLogger) WarnAttrs(msg string, attrs ...slog.Attr) {
+	al.counters.warningsCount.Add(1)
+	al.emit(slog.LevelWarn, msg, attrs)
+	if al.console == consoleVerbose {
+		al.echo(slog.LevelWarn, msg, attrs, false)
+	}
+}
+
+// ErrorAttrs logs an error event to file and prints a one-line summary to stderr.
+// Stack traces are only captured for internal errors or when debugging.
+func (al *AppLogger) ErrorAttrs(msg string, attrs ...slog.Attr) {
+	attrs = al.errorAttrs(msg, attrs, kindOf(attrs, nil))
+	al.emit(slog.LevelError, msg, attrs)
+}
+
+// Debug logs printf-style debug messages.
+func (al *AppLogger) Debug(format string, args ...any) {
+	al.emit(slog.LevelDebug, fmt.Sprintf(format, args...), nil)
 }
 
 // Info logs informational messages. In normal mode: high-level only should be used at rule start/end.
 // In dry-run mode it can be called for each simulated action.
 func (al *AppLogger) Info(format string, args ...any) {
-	al.fileLogger.Printf("INFO: "+format, args...)
+	msg := fmt.Sprintf(format, args...)
+	al.emit(slog.LevelInfo, msg, nil)
+	if al.console == consoleVerbose {
+		al.echo(slog.LevelInfo, msg, nil, false)
+	}
 }
 
-// Warn logs warnings to file only.
+// Warn logs warnings to file only (and to the console in verbose mode).
 func (al *AppLogger) Warn(format string, args ...any) {
-	al.warningsCount.Add(1)
-	al.fileLogger.Printf("WARN: "+format, args...)
+	al.counters.warningsCount.Add(1)
+	msg := fmt.Sprintf(format, args...)
+	al.emit(slog.LevelWarn, msg, nil)
+	if al.console == consoleVerbose {
+		al.echo(slog.LevelWarn, msg, nil, false)
+	}
 }
 
-// Error logs errors to file and also prints to stderr with stack trace for troubleshooting.
+// Error logs errors to file and prints a one-line summary to stderr. The error kind is
+// taken from the first error among args.
 func (al *AppLogger) Error(format string, args ...any) {
-	al.errorsCount.Add(1)
 	msg := fmt.Sprintf(format, args...)
-	al.fileLogger.Printf("ERROR: %s", msg)
-	// Console output with stack trace
-	fmt.Fprintf(os.Stderr, "ERROR: %s\nSTACK:\n%s\n", msg, debug.Stack())
+	attrs := al.errorAttrs(msg, nil, kindOf(nil, args))
+	al.emit(slog.LevelError, msg, attrs)
 }
 
 // CountFile increments the files processed counter.
-func (al *AppLogger) CountFile() { al.filesProcessed.Add(1) }
+func (al *AppLogger) CountFile() { al.counters.filesProcessed.Add(1) }
 
 // CountRule increments rules executed counter.
-func (al *AppLogger) CountRule() { al.rulesExecuted.Add(1) }
+func (al *AppLogger) CountRule() { al.counters.rulesExecuted.Add(1) }
+
+// ruleStats returns the stats bucket for the logger's rule (see WithRule).
+func (al *AppLogger) ruleStats() *ruleStats { return al.counters.stats.rule(al.rule) }
+
+// StartRule counts a rule execution and starts its wall-clock timer.
+func (al *AppLogger) StartRule() {
+	al.CountRule()
+	rs := al.ruleStats()
+	rs.mu.Lock()
+	rs.started = time.Now()
+	rs.mu.Unlock()
+}
+
+// FinishRule stops the rule timer and logs the outcome of the pass.
+func (al *AppLogger) FinishRule() {
+	rs := al.ruleStats()
+	rs.mu.Lock()
+	elapsed := time.Since(rs.started)
+	rs.duration += elapsed
+	rs.passes++
+	rs.passHist.observe(elapsed)
+	rs.mu.Unlock()
+
+	c := rs.snapshot()
+	al.InfoAttrs("Completed", countsAttrs(&c, durationAttr(elapsed))...)
+}
+
+// CountMoved records a file moved (or simulated in dry-run) to the given output target.
+func (al *AppLogger) CountMoved(target string, bytes int64) {
+	al.CountFile()
+	rs := al.ruleStats()
+	rs.moved.Add(1)
+	rs.bytesMoved.Add(bytes)
+	t := rs.target(target)
+	t.moved.Add(1)
+	t.bytesMoved.Add(bytes)
+}
 
-// Summary writes a final summary line.
+// CountDeleted records a file deleted (or simulated in dry-run) under the given root.
+func (al *AppLogger) CountDeleted(target string, bytes int64) {
+	al.CountFile()
+	rs := al.ruleStats()
+	rs.deleted.Add(1)
+	rs.bytesDeleted.Add(bytes)
+	t := rs.target(target)
+	t.deleted.Add(1)
+	t.bytesDeleted.Add(bytes)
+}
+
+// CountSkipped records n matching files that were intentionally not processed.
+func (al *AppLogger) CountSkipped(n int) {
+	al.ruleStats().skipped.Add(int64(n))
+}
+
+// CountFailed records a file whose move or delete failed. target may be empty when the
+// failure happened before an output was chosen.
+func (al *AppLogger) CountFailed(target string) {
+	al.CountFile()
+	rs := al.ruleStats()
+	rs.failed.Add(1)
+	if target != "" {
+		rs.target(target).failed.Add(1)
+	}
+}
+
+// countsAttrs renders a counts snapshot as log fields, followed by extra.
+func countsAttrs(c *countsSnapshot, extra ...slog.Attr) []slog.Attr {
+	return append([]slog.Attr{
+		slog.Int64("moved", c.Moved),
+		slog.Int64("deleted", c.Deleted),
+		slog.Int64("skipped", c.Skipped),
+		slog.Int64("failed", c.Failed),
+		slog.Int64("bytesMoved", c.BytesMoved),
+		slog.Int64("bytesDeleted", c.BytesDeleted),
+	}, extra...)
+}
+
+// Summary writes the per-rule and per-target breakdown followed by a final summary line.
 func (al *AppLogger) Summary(elapsed time.Duration) {
-	al.fileLogger.Printf("SUMMARY: rules=%d files=%d warnings=%d errors=%d elapsed=%.3fs dryRun=%v",
-		al.rulesExecuted.Load(),
-		al.filesProcessed.Load(),
-		al.warningsCount.Load(),
-		al.errorsCount.Load(),
-		elapsed.Seconds(),
-		al.dryRun,
-	)
+	rules, total := al.counters.stats.snapshot()
+	for i := range rules {
+		r := &rules[i]
+		ruleLog := al.WithRule(r.Name)
+		ruleLog.InfoAttrs("RULE SUMMARY", countsAttrs(&r.countsSnapshot, durationAttr(r.Duration), slog.Int("passes", r.Passes))...)
+		for j := range r.Targets {
+			tgt := &r.Targets[j]
+			ruleLog.InfoAttrs("TARGET SUMMARY", countsAttrs(&tgt.countsSnapshot, dstAttr(tgt.Path))...)
+		}
+	}
+
+	al.InfoAttrs("SUMMARY", countsAttrs(&total,
+		slog.Int64("rules", al.counters.rulesExecuted.Load()),
+		slog.Int64("files", al.counters.filesProcessed.Load()),
+		slog.Int64("warnings", al.counters.warningsCount.Load()),
+		slog.Int64("errors", al.counters.errorsCount.Load()),
+		durationAttr(elapsed),
+		slog.Bool("dryRun", al.dryRun),
+	)...)
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLogger(t *testing.T, buf *bytes.Buffer, cfg logConfig) *AppLogger {
+	t.Helper()
+	h, err := newLogHandler(buf, cfg)
+	if err != nil {
+		t.Fatalf("newLogHandler: %v", err)
+	}
+	return &AppLogger{logger: slog.New(h), counters: newLogCounters(), console: cfg.console()}
+}
+
+// TestAppLoggerJSONFields verifies that rule-scoped events carry typed fields in JSON output.
+func TestAppLoggerJSONFields(t *testing.T) {
+	var buf bytes.Buffer
+	al := newTestLogger(t, &buf, logConfig{Format: "json", Level: "info"})
+
+	al.WithRule("Archive").InfoAttrs("Moved",
+		srcAttr("/in/a.pdf"), dstAttr("/out/a.pdf"), bytesAttr(42), durationAttr(time.Second), errAttr(errors.New("boom")))
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, buf.String())
+	}
+	want := map[string]any{
+		"msg":      "Moved",
+		"level":    "INFO",
+		"rule":     "Archive",
+		"src":      "/in/a.pdf",
+		"dst":      "/out/a.pdf",
+		"bytes":    float64(42),
+		"duration": float64(time.Second),
+		"error":    "boom",
+	}
+	for k, v := range want {
+		if rec[k] != v {
+			t.Errorf("%s = %v, want %v", k, rec[k], v)
+		}
+	}
+	if src, _ := rec["source"].(string); !strings.HasPrefix(src, "logger_test.go:") {
+		t.Errorf("source = %q, want caller location in logger_test.go", src)
+	}
+}
+
+func TestAppLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	al := newTestLogger(t, &buf, logConfig{Level: "info"})
+	al.Debug("hidden %d", 1)
+	if buf.Len() != 0 {
+		t.Fatalf("debug record written at info level: %s", buf.String())
+	}
+
+	al = newTestLogger(t, &buf, logConfig{Level: "debug"})
+	al.Debug("shown %d", 2)
+	if !strings.Contains(buf.String(), "msg=\"shown 2\"") {
+		t.Fatalf("debug record missing at debug level: %s", buf.String())
+	}
+}
+
+func TestNewLogHandlerInvalid(t *testing.T) {
+	if _, err := newLogHandler(&bytes.Buffer{}, logConfig{Format: "xml"}); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+	if _, err := newLogHandler(&bytes.Buffer{}, logConfig{Level: "loud"}); err == nil {
+		t.Errorf("expected error for unknown level")
+	}
+}
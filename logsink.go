@@ -0,0 +1,232 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+)
+
+// logFilePrefix names rotated log files (logs/sloth-YYYYMMDD.log, .log.1, .log.gz, ...).
+const logFilePrefix = "sloth-"
+
+// noMaxAge is handed to rotatelogs so its own glob-based cleanup never fires;
+// retention (including compressed and size-generation files) is done by pruneLogs.
+const noMaxAge = 100 * 365 * 24 * time.Hour
+
+// levelSink receives one formatted record at a time along with its level, so that
+// syslog and journald can map it to a priority.
+type levelSink interface {
+	WriteLevel(level slog.Level, p []byte) error
+}
+
+// writerSink adapts a plain writer (file, stdout) that has no notion of priority.
+type writerSink struct{ w io.Writer }
+
+func (s writerSink) WriteLevel(_ slog.Level, p []byte) error {
+	_, err := s.w.Write(p)
+	return err
+}
+
+// levelWriter is the io.Writer handed to the slog handler. sinkHandler sets the level
+// before each record; slog's handlers issue exactly one Write per record.
+type levelWriter struct {
+	mu    sync.Mutex
+	level slog.Level
+	sink  levelSink
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	return len(p), w.sink.WriteLevel(w.level, p)
+}
+
+// sinkHandler wraps a text/JSON handler and forwards each record's level to the sink.
+type sinkHandler struct {
+	inner slog.Handler
+	out   *levelWriter
+}
+
+func (h *sinkHandler) Enabled(ctx context.Context, l slog.Level) bool { return h.inner.Enabled(ctx, l) }
+
+func (h *sinkHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	h.out.level = r.Level
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *sinkHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sinkHandler{inner: h.inner.WithAttrs(attrs), out: h.out}
+}
+
+func (h *sinkHandler) WithGroup(name string) slog.Handler {
+	return &sinkHandler{inner: h.inner.WithGroup(name), out: h.out}
+}
+
+// openLogSink returns the destination selected by cfg.Sink.
+func openLogSink(cfg *logConfig) (levelSink, error) {
+	switch strings.ToLower(cfg.Sink) {
+	case "", "file":
+		w, err := openRotatingFile(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return writerSink{w}, nil
+	case "stdout":
+		return writerSink{os.Stdout}, nil
+	case "none":
+		return writerSink{io.Discard}, nil
+	case "syslog":
+		return openSyslogSink()
+	case "journald":
+		return openJournaldSink()
+	default:
+		return nil, fmt.Errorf("%w: log sink %q: must be file, stdout, syslog, journald or none", errInvalidConfig, cfg.Sink)
+	}
+}
+
+// rotationPattern picks a strftime file pattern fine-grained enough for the rotation period;
+// rotatelogs only starts a new file when the formatted name changes.
+func rotationPattern(every time.Duration) string {
+	switch {
+	case every <= 0 || every%(24*time.Hour) == 0:
+		return logFilePrefix + "%Y%m%d.log"
+	case every%time.Hour == 0:
+		return logFilePrefix + "%Y%m%d%H.log"
+	default:
+		return logFilePrefix + "%Y%m%d%H%M.log"
+	}
+}
+
+// openRotatingFile sets up rotation by time and/or size in cfg.Dir, with retention and
+// optional gzip compression of rotated files.
+func openRotatingFile(cfg *logConfig) (io.Writer, error) {
+	// Ensure directory exists
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	// Remove existing symlink if it exists to avoid permission issues
+	// (allows rotatelogs to recreate with current user permissions)
+	linkPath := filepath.Join(cfg.Dir, "sloth.log")
+	if _, err := os.Lstat(linkPath); err == nil {
+		_ = os.Remove(linkPath)
+	}
+
+	rotateEvery := cfg.RotateEvery
+	if rotateEvery <= 0 {
+		rotateEvery = 24 * time.Hour
+	}
+	opts := []rotatelogs.Option{
+		rotatelogs.WithLinkName(linkPath),
+		rotatelogs.WithMaxAge(noMaxAge),
+		rotatelogs.WithRotationTime(rotateEvery),
+		rotatelogs.WithHandler(rotatelogs.HandlerFunc(func(e rotatelogs.Event) {
+			if ev, ok := e.(*rotatelogs.FileRotatedEvent); ok {
+				finishRotatedLog(cfg, ev.PreviousFile(), ev.CurrentFile())
+			}
+		})),
+	}
+	if cfg.MaxSizeMB > 0 {
+		opts = append(opts, rotatelogs.WithRotationSize(cfg.MaxSizeMB*1024*1024))
+	}
+
+	rotator, err := rotatelogs.New(filepath.Join(cfg.Dir, rotationPattern(cfg.RotateEvery)), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rotatelogs: %w", err)
+	}
+	pruneLogs(cfg.Dir, "", cfg.MaxBackups, cfg.MaxAge)
+	return rotator, nil
+}
+
+// finishRotatedLog compresses the file that was just closed (if enabled) and applies retention.
+func finishRotatedLog(cfg *logConfig, previous, current string) {
+	if previous != "" && cfg.Compress {
+		if err := gzipFile(previous); err != nil {
+			log.Printf("failed to compress rotated log %s: %v", previous, err)
+		}
+	}
+	pruneLogs(cfg.Dir, current, cfg.MaxBackups, cfg.MaxAge)
+}
+
+// gzipFile replaces path with path.gz, keeping its modification time for retention.
+func gzipFile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	gzPath := path + ".gz"
+	out, err := os.OpenFile(gzPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(gzPath)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(gzPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(gzPath)
+		return err
+	}
+	_ = os.Chtimes(gzPath, fi.ModTime(), fi.ModTime())
+	in.Close()
+	return os.Remove(path)
+}
+
+// pruneLogs removes rotated log files beyond maxBackups (newest kept) or older than maxAge.
+// Zero disables either limit. current is the file being written and is never removed.
+func pruneLogs(dir, current string, maxBackups int, maxAge time.Duration) {
+	if maxBackups <= 0 && maxAge <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	type rotated struct {
+		path    string
+		modTime time.Time
+	}
+	var files []rotated
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if !e.Type().IsRegular() || !strings.HasPrefix(e.Name(), logFilePrefix) || path == current {
+			continue
+		}
+		if fi, err := e.Info(); err == nil {
+			files = append(files, rotated{path, fi.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	cutoff := time.Now().Add(-maxAge)
+	for i, f := range files {
+		if (maxBackups > 0 && i >= maxBackups) || (maxAge > 0 && f.modTime.Before(cutoff)) {
+			_ = os.Remove(f.path)
+		}
+	}
+}
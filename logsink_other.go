@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package main
+
+import "errors"
+
+func openSyslogSink() (levelSink, error) {
+	return nil, errors.New("syslog sink is not supported on this platform")
+}
+
+func openJournaldSink() (levelSink, error) {
+	return nil, errors.New("journald sink is not supported on this platform")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLogDirConfigurable(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "custom")
+	cfg := defaultLogConfig()
+	cfg.Dir = dir
+
+	al, err := newAppLogger(false, cfg)
+	if err != nil {
+		t.Fatalf("newAppLogger: %v", err)
+	}
+	al.Info("hello")
+
+	data, err := os.ReadFile(filepath.Join(dir, "sloth.log"))
+	if err != nil {
+		t.Fatalf("read log via link: %v", err)
+	}
+	if len(data) == 0 {
+		t.Fatalf("log file is empty")
+	}
+}
+
+func TestPruneLogs(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	files := map[string]time.Duration{
+		"sloth-1.log":    0,
+		"sloth-2.log.gz": 1 * time.Hour,
+		"sloth-3.log.1":  2 * time.Hour,
+		"sloth-4.log":    48 * time.Hour,
+		"other.txt":      96 * time.Hour,
+	}
+	for name, age := range files {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		mt := now.Add(-age)
+		if err := os.Chtimes(p, mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Current file is never counted; keep 2 backups and nothing older than a day.
+	pruneLogs(dir, filepath.Join(dir, "sloth-1.log"), 2, 24*time.Hour)
+
+	for name, want := range map[string]bool{
+		"sloth-1.log":    true,
+		"sloth-2.log.gz": true,
+		"sloth-3.log.1":  true,
+		"sloth-4.log":    false,
+		"other.txt":      true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if got := err == nil; got != want {
+			t.Errorf("%s exists = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestGzipFile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "sloth-20240101.log")
+	if err := os.WriteFile(p, []byte("line one\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(p, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gzipFile(p); err != nil {
+		t.Fatalf("gzipFile: %v", err)
+	}
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Errorf("original file should be removed")
+	}
+	f, err := os.Open(p + ".gz")
+	if err != nil {
+		t.Fatalf("open gz: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	data, _ := io.ReadAll(zr)
+	if string(data) != "line one\n" {
+		t.Errorf("decompressed = %q", data)
+	}
+	if fi, _ := f.Stat(); !fi.ModTime().Equal(old) {
+		t.Errorf("mtime = %v, want %v", fi.ModTime(), old)
+	}
+}
+
+func TestRotationPattern(t *testing.T) {
+	tests := map[time.Duration]string{
+		24 * time.Hour:   "sloth-%Y%m%d.log",
+		6 * time.Hour:    "sloth-%Y%m%d%H.log",
+		15 * time.Minute: "sloth-%Y%m%d%H%M.log",
+	}
+	for every, want := range tests {
+		if got := rotationPattern(every); got != want {
+			t.Errorf("rotationPattern(%v) = %q, want %q", every, got, want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"net"
+	"sync"
+)
+
+// journaldSocket is the native journal protocol socket on systemd hosts.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// syslogSink writes records to the local syslog daemon with a matching priority.
+type syslogSink struct{ w *syslog.Writer }
+
+func openSyslogSink() (levelSink, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "sloth")
+	if err != nil {
+		return nil, fmt.Errorf("connect to syslog: %w", err)
+	}
+	return syslogSink{w}, nil
+}
+
+func (s syslogSink) WriteLevel(level slog.Level, p []byte) error {
+	msg := string(bytes.TrimRight(p, "\n"))
+	switch {
+	case level >= slog.LevelError:
+		return s.w.Err(msg)
+	case level >= slog.LevelWarn:
+		return s.w.Warning(msg)
+	case level >= slog.LevelInfo:
+		return s.w.Info(msg)
+	default:
+		return s.w.Debug(msg)
+	}
+}
+
+// journaldSink sends records to systemd-journald using its native datagram protocol.
+type journaldSink struct {
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+func openJournaldSink() (levelSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("connect to journald: %w", err)
+	}
+	return &journaldSink{conn: conn}, nil
+}
+
+func (s *journaldSink) WriteLevel(level slog.Level, p []byte) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "PRIORITY=%d\nSYSLOG_IDENTIFIER=sloth\n", syslogPriority(level))
+	// MESSAGE uses the binary field form so embedded newlines survive.
+	msg := bytes.TrimRight(p, "\n")
+	buf.WriteString("MESSAGE\n")
+	_ = binary.Write(&buf, binary.LittleEndian, uint64(len(msg)))
+	buf.Write(msg)
+	buf.WriteByte('\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.conn.Write(buf.Bytes())
+	return err
+}
+
+// syslogPriority maps slog levels onto syslog severities (err=3, warning=4, info=6, debug=7).
+func syslogPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
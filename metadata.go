@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// preserveOptions selects which metadata a copy-based move carries over. Every option
+// defaults to true; owner is only applied when running as root.
+type preserveOptions struct {
+	Mode   *bool `json:"mode,omitempty"`
+	Owner  *bool `json:"owner,omitempty"`
+	Times  *bool `json:"times,omitempty"`
+	Xattrs *bool `json:"xattrs,omitempty"`
+	ACLs   *bool `json:"acls,omitempty"`
+}
+
+func enabled(b *bool) bool { return b == nil || *b }
+
+func (p *preserveOptions) mode() bool   { return p == nil || enabled(p.Mode) }
+func (p *preserveOptions) owner() bool  { return p == nil || enabled(p.Owner) }
+func (p *preserveOptions) times() bool  { return p == nil || enabled(p.Times) }
+func (p *preserveOptions) xattrs() bool { return p == nil || enabled(p.Xattrs) }
+func (p *preserveOptions) acls() bool   { return p == nil || enabled(p.ACLs) }
+
+// applyMetadata gives dst the metadata of src (described by fi) selected by p. Ownership
+// goes first because chown clears setuid/setgid bits, and ACLs after the mode because
+// chmod rewrites the ACL mask. Times go last.
+func applyMetadata(src, dst string, fi os.FileInfo, p *preserveOptions) error {
+	if p.owner() && os.Geteuid() == 0 {
+		if uid, gid, ok := fileOwner(fi); ok {
+			if err := os.Lchown(dst, uid, gid); err != nil {
+				return err
+			}
+		}
+	}
+	if p.mode() {
+		if err := os.Chmod(dst, fi.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+			return err
+		}
+	}
+	if p.xattrs() || p.acls() {
+		if err := copyXattrs(src, dst, p.xattrs(), p.acls()); err != nil {
+			return err
+		}
+	}
+	if p.times() {
+		if err := os.Chtimes(dst, fileAtime(fi), fi.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isCrossDevice reports whether a rename failed because src and dst are on different
+// filesystems, so the move has to copy.
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// copyMove moves src to dst by copying, for when a rename is impossible or the file is
+// transformed on the way: compressed (c set), then encrypted (e set). The copy is
+// written to a temp file next to dst, synced, given src's metadata and renamed into
+// place; only then is src removed. If want is set, the source read must hash to it, and
+// a compressed copy is decompressed and checked against the source before src goes
+// (encrypted copies cannot be read back without the identity). It returns the SHA-256
+// of the written file.
+func copyMove(src, dst string, p *preserveOptions, c *compressOptions, e *encryptOptions, want string) (string, error) {
+	sum, err := copyFile(src, dst, p, c, e, want)
+	if err != nil {
+		return "", err
+	}
+	return sum, os.Remove(src)
+}
+
+// copyFile is copyMove without removing src.
+func copyFile(src, dst string, p *preserveOptions, c *compressOptions, e *encryptOptions, want string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	srcHash, dstHash := sha256.New(), sha256.New()
+	w, finish, err := transformWriter(io.MultiWriter(tmp, dstHash), c, e)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(w, io.TeeReader(in, srcHash)); err != nil {
+		return "", err
+	}
+	if err := finish(); err != nil {
+		return "", err
+	}
+	got := hex.EncodeToString(srcHash.Sum(nil))
+	if want != "" && got != want {
+		return "", fmt.Errorf("%w: source %s, copy %s", errChecksumMismatch, want, got)
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if c != nil && e == nil {
+		if err := verifyCompressed(tmp.Name(), c.Format, got); err != nil {
+			return "", err
+		}
+	}
+	if err := applyMetadata(src, tmp.Name(), fi, p); err != nil {
+		return "", fmt.Errorf("preserve metadata: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return "", err
+	}
+	ok = true
+	syncDir(filepath.Dir(dst))
+	return hex.EncodeToString(dstHash.Sum(nil)), nil
+}
+
+// transformWriter returns a writer that compresses (c set) and then encrypts (e set)
+// onto w, and a finish func that flushes and closes those layers but not w.
+func transformWriter(w io.Writer, c *compressOptions, e *encryptOptions) (io.Writer, func() error, error) {
+	var layers []io.WriteCloser // outermost first; closed in reverse
+	if e != nil {
+		ew, err := e.writer(w)
+		if err != nil {
+			return nil, nil, err
+		}
+		layers, w = append(layers, ew), ew
+	}
+	if c != nil {
+		cw, err := c.writer(w)
+		if err != nil {
+			return nil, nil, err
+		}
+		layers, w = append(layers, cw), cw
+	}
+	finish := func() error {
+		for i := len(layers) - 1; i >= 0; i-- {
+			if err := layers[i].Close(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return w, finish, nil
+}
+
+// syncDir flushes a directory entry change such as a rename to disk. It is best effort:
+// not every platform can sync a directory.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
+
+// dirSettings is the mode and owner for output directories sloth creates.
+type dirSettings struct {
+	mode     os.FileMode
+	uid, gid int // -1 leaves the owner unchanged
+}
+
+var defaultDirSettings = dirSettings{mode: 0755, uid: -1, gid: -1}
+
+// newDirSettings parses a rule's dirMode (octal, e.g. "2775") and dirOwner ("user:group",
+// "uid:gid", "user" or ":group").
+func newDirSettings(f *folder) (dirSettings, error) {
+	ds := defaultDirSettings
+	if f.DirMode != "" {
+		v, err := strconv.ParseUint(f.DirMode, 8, 32)
+		if err != nil || v > 07777 {
+			return ds, fmt.Errorf("%w: rule %q: dirMode %q: must be octal like 0755 or 2775", errInvalidConfig, f.Name, f.DirMode)
+		}
+		ds.mode = os.FileMode(v & 0777)
+		if v&04000 != 0 {
+			ds.mode |= os.ModeSetuid
+		}
+		if v&02000 != 0 {
+			ds.mode |= os.ModeSetgid
+		}
+		if v&01000 != 0 {
+			ds.mode |= os.ModeSticky
+		}
+	}
+	if f.DirOwner != "" {
+		name, group, _ := strings.Cut(f.DirOwner, ":")
+		var err error
+		if name != "" {
+			if ds.uid, err = lookupID(name, func(n string) (string, error) {
+				u, err := user.Lookup(n)
+				if err != nil {
+					return "", err
+				}
+				return u.Uid, nil
+			}); err != nil {
+				return ds, fmt.Errorf("%w: rule %q: dirOwner user %q: %w", errInvalidConfig, f.Name, name, err)
+			}
+		}
+		if group != "" {
+			if ds.gid, err = lookupID(group, func(n string) (string, error) {
+				g, err := user.LookupGroup(n)
+				if err != nil {
+					return "", err
+				}
+				return g.Gid, nil
+			}); err != nil {
+				return ds, fmt.Errorf("%w: rule %q: dirOwner group %q: %w", errInvalidConfig, f.Name, group, err)
+			}
+		}
+	}
+	return ds, nil
+}
+
+// lookupID accepts a numeric id or resolves a name.
+func lookupID(s string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(s); err == nil {
+		return id, nil
+	}
+	id, err := lookup(s)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+// makeDirs creates dir and its missing parents with ds. The mode is set with chmod so
+// the umask does not strip group write or the setgid bit.
+func makeDirs(dir string, ds dirSettings) error {
+	if fi, err := os.Stat(dir); err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := makeDirs(parent, ds); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, ds.mode.Perm()); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil // created concurrently by another worker
+		}
+		return err
+	}
+	if ds.uid != -1 || ds.gid != -1 {
+		if err := os.Chown(dir, ds.uid, ds.gid); err != nil {
+			return err
+		}
+	}
+	if ds != defaultDirSettings {
+		return os.Chmod(dir, ds.mode)
+	}
+	return nil
+}
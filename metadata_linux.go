@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func fileOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
+
+func fileAtime(fi os.FileInfo) time.Time {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec)) //nolint:unconvert // int32 on 32-bit platforms
+	}
+	return fi.ModTime()
+}
+
+// copyXattrs copies extended attributes from src to dst. POSIX ACLs are the
+// system.posix_acl_* attributes and are copied only with acls; everything else only with
+// xattrs. A destination filesystem without xattr support is not an error.
+func copyXattrs(src, dst string, xattrs, acls bool) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			return nil
+		}
+		return err
+	}
+	for _, name := range names {
+		isACL := strings.HasPrefix(name, "system.posix_acl_")
+		if (isACL && !acls) || (!isACL && !xattrs) {
+			continue
+		}
+		value, err := getXattr(src, name)
+		if err != nil {
+			return err
+		}
+		if err := syscall.Setxattr(dst, name, value, 0); err != nil {
+			if errors.Is(err, syscall.ENOTSUP) {
+				return nil
+			}
+			if errors.Is(err, syscall.EPERM) && strings.HasPrefix(name, "trusted.") {
+				continue // trusted.* needs CAP_SYS_ADMIN
+			}
+			return &os.PathError{Op: "setxattr " + name, Path: dst, Err: err}
+		}
+	}
+	return nil
+}
+
+func listXattrs(path string) ([]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(path, buf); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Getxattr(path, name, buf); err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
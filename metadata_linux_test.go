@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCopyMoveXattrsAndOwner(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := os.WriteFile(src, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(src, "user.sloth.origin", []byte("scanner-3"), 0); err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			t.Skip("filesystem without user xattrs")
+		}
+		t.Fatal(err)
+	}
+	asRoot := os.Geteuid() == 0
+	if asRoot {
+		if err := os.Chown(src, 1234, 5678); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := copyMove(src, dst, nil, nil, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := getXattr(dst, "user.sloth.origin"); err != nil || string(v) != "scanner-3" {
+		t.Errorf("xattr = %q, %v", v, err)
+	}
+	if asRoot {
+		fi, _ := os.Stat(dst)
+		if uid, gid, _ := fileOwner(fi); uid != 1234 || gid != 5678 {
+			t.Errorf("owner = %d:%d, want 1234:5678", uid, gid)
+		}
+	}
+
+	// With xattrs disabled nothing is copied.
+	off := false
+	if err := os.WriteFile(src, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(src, "user.sloth.origin", []byte("x"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := copyMove(src, dst, &preserveOptions{Xattrs: &off}, nil, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getXattr(dst, "user.sloth.origin"); err == nil {
+		t.Error("xattr copied with xattrs disabled")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// Ownership, atime and extended attributes are only preserved on Linux; elsewhere copies
+// keep the mode and modification time.
+
+func fileOwner(os.FileInfo) (uid, gid int, ok bool) { return 0, 0, false }
+
+func fileAtime(fi os.FileInfo) time.Time { return fi.ModTime() }
+
+func copyXattrs(src, dst string, xattrs, acls bool) error { return nil }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCopyMovePreservesMetadata(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.csv")
+	dst := filepath.Join(dir, "archive", "src.csv")
+	if err := os.WriteFile(src, []byte("a,b\n1,2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(src, 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Dir(dst), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := copyMove(src, dst, nil, nil, nil, hashFile(src)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("source not removed: %v", err)
+	}
+	fi, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want 0640", fi.Mode().Perm())
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("mtime = %v, want %v", fi.ModTime(), mtime)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(dst), ".*.tmp")); len(leftovers) > 0 {
+		t.Errorf("temp files left: %v", leftovers)
+	}
+}
+
+func TestCopyMoveChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := os.WriteFile(src, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := copyMove(src, dst, nil, nil, nil, "00")
+	if !errors.Is(err, errChecksumMismatch) {
+		t.Fatalf("err = %v, want checksum mismatch", err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("source removed after failed copy: %v", err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("destination written after failed copy: %v", err)
+	}
+}
+
+func TestPreserveOptionsDisable(t *testing.T) {
+	off := false
+	p := &preserveOptions{Times: &off}
+	if !p.mode() || p.times() {
+		t.Errorf("mode=%v times=%v, want true/false", p.mode(), p.times())
+	}
+	var none *preserveOptions
+	if !none.owner() || !none.acls() {
+		t.Error("nil options should preserve everything")
+	}
+}
+
+func TestDirSettings(t *testing.T) {
+	ds, err := newDirSettings(&folder{Name: "r", DirMode: "2775", DirOwner: "0:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.mode != os.ModeSetgid|0775 || ds.uid != 0 || ds.gid != 0 {
+		t.Errorf("settings = %+v", ds)
+	}
+	for _, f := range []folder{{Name: "r", DirMode: "999"}, {Name: "r", DirMode: "17777"}, {Name: "r", DirOwner: "no-such-user-sloth"}} {
+		if _, err := newDirSettings(&f); !errors.Is(err, errInvalidConfig) {
+			t.Errorf("newDirSettings(%+v) err = %v, want invalid config", f, err)
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	dir := filepath.Join(t.TempDir(), "a", "b")
+	if err := makeDirs(dir, dirSettings{mode: os.ModeSetgid | 0775, uid: -1, gid: -1}); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{dir, filepath.Dir(dir)} {
+		fi, err := os.Stat(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode()&os.ModeSetgid == 0 || fi.Mode().Perm() != 0775 {
+			t.Errorf("%s mode = %v, want setgid 0775", d, fi.Mode())
+		}
+	}
+}
+
+func TestApplyKeepsRuleSettings(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no setgid directories on Windows")
+	}
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtime := time.Date(2020, 3, 4, 5, 6, 7, 0, time.Local)
+	writeAged(t, in, "a.log", "line\n", mtime)
+	off := false
+	rules := []folder{{Name: "Logs", Input: in, Output: []string{out}, Extension: ".log", FolderType: "1",
+		Compress: &compressOptions{Format: "gzip"}, Preserve: &preserveOptions{Times: &off}, DirMode: "2770"}}
+	if err := validateFolders(rules); err != nil {
+		t.Fatal(err)
+	}
+
+	// The settings survive the plan file.
+	path := filepath.Join(dir, "plan.json")
+	if err := savePlan(path, buildPlan(rules)); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := loadPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	if stale := applyPlan(al, plan, false); len(stale) != 0 {
+		t.Fatalf("stale entries: %+v", stale)
+	}
+	if n := al.counters.errorsCount.Load(); n != 0 {
+		t.Fatalf("%d errors", n)
+	}
+	day := filepath.Join(out, "2020", "3", "Day 4")
+	for _, d := range []string{day, filepath.Dir(day)} {
+		if fi, err := os.Stat(d); err != nil || fi.Mode()&os.ModeSetgid == 0 || fi.Mode().Perm() != 0770 {
+			t.Errorf("%s: mode %v, %v; want setgid 0770", d, fi.Mode(), err)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(day, "a.log.gz")); err != nil || fi.ModTime().Equal(mtime) {
+		t.Errorf("compressed copy kept the source's mtime despite preserve.times false: %v", err)
+	}
+}
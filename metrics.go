@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsWriter renders the Prometheus text exposition format (version 0.0.4).
+type metricsWriter struct {
+	w       *bufio.Writer
+	current string
+}
+
+// family writes the HELP/TYPE header once per metric family.
+func (m *metricsWriter) family(name, typ, help string) {
+	if m.current == name {
+		return
+	}
+	m.current = name
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one sample; labels are name/value pairs.
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.w.WriteString(name)
+	if len(labels) > 0 {
+		m.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.w.WriteByte(',')
+			}
+			fmt.Fprintf(m.w, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		m.w.WriteByte('}')
+	}
+	m.w.WriteByte(' ')
+	m.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string { return labelEscaper.Replace(v) }
+
+// WriteMetrics writes every sloth metric derived from the logger's counters to w.
+func (al *AppLogger) WriteMetrics(w io.Writer) error {
+	m := &metricsWriter{w: bufio.NewWriter(w)}
+	rules, _ := al.counters.stats.snapshot()
+
+	type targetCounter struct {
+		name, help string
+		value      func(c *countsSnapshot) int64
+	}
+	perTarget := []targetCounter{
+		{"sloth_files_moved_total", "Files moved (or simulated in dry-run).", func(c *countsSnapshot) int64 { return c.Moved }},
+		{"sloth_files_deleted_total", "Files deleted by retention (or simulated in dry-run).", func(c *countsSnapshot) int64 { return c.Deleted }},
+		{"sloth_bytes_moved_total", "Bytes moved.", func(c *countsSnapshot) int64 { return c.BytesMoved }},
+		{"sloth_bytes_deleted_total", "Bytes deleted.", func(c *countsSnapshot) int64 { return c.BytesDeleted }},
+		{"sloth_files_failed_total", "Files whose move or delete failed.", func(c *countsSnapshot) int64 { return c.Failed }},
+	}
+	for _, tc := range perTarget {
+		m.family(tc.name, "counter", tc.help)
+		for i := range rules {
+			for j := range rules[i].Targets {
+				t := &rules[i].Targets[j]
+				m.sample(tc.name, float64(tc.value(&t.countsSnapshot)), "rule", rules[i].Name, "target", t.Path)
+			}
+		}
+	}
+
+	m.family("sloth_files_skipped_total", "counter", "Matching files intentionally not processed.")
+	for i := range rules {
+		m.sample("sloth_files_skipped_total", float64(rules[i].Skipped), "rule", rules[i].Name)
+	}
+
+	m.family("sloth_rule_duration_seconds", "histogram", "Wall time of each rule pass.")
+	for i := range rules {
+		h := &rules[i].hist
+		for b, le := range durationBuckets {
+			m.sample("sloth_rule_duration_seconds_bucket", float64(h.counts[b]), "rule", rules[i].Name, "le", strconv.FormatFloat(le, 'g', -1, 64))
+		}
+		m.sample("sloth_rule_duration_seconds_bucket", float64(h.count), "rule", rules[i].Name, "le", "+Inf")
+		m.sample("sloth_rule_duration_seconds_sum", h.sum, "rule", rules[i].Name)
+		m.sample("sloth_rule_duration_seconds_count", float64(h.count), "rule", rules[i].Name)
+	}
+
+	m.family("sloth_errors_total", "counter", "Errors logged, by kind.")
+	for k := errorKind(0); k < numErrorKinds; k++ {
+		m.sample("sloth_errors_total", float64(al.counters.errorsByKind[k].Load()), "kind", k.String())
+	}
+
+	m.family("sloth_target_free_bytes", "gauge", "Free space available on each output target.")
+	var outputs []string
+	if p := al.counters.outputs.Load(); p != nil {
+		outputs = *p
+	}
+	for _, target := range metricTargets(outputs) {
+		if free, err := diskFree(target); err == nil {
+			m.sample("sloth_target_free_bytes", float64(free), "target", target)
+		}
+	}
+
+	m.family("sloth_last_update_timestamp_seconds", "gauge", "Unix time these metrics were written.")
+	m.sample("sloth_last_update_timestamp_seconds", float64(time.Now().Unix()))
+
+	return m.w.Flush()
+}
+
+// SetOutputs records the output roots of the rules that were loaded, for the free space
+// gauge. It covers them whether or not anything was written to them yet.
+func (al *AppLogger) SetOutputs(outputs []string) {
+	al.counters.outputs.Store(&outputs)
+}
+
+// ruleOutputs returns the outputs of folders.
+func ruleOutputs(folders []folder) []string {
+	var outputs []string
+	for i := range folders {
+		outputs = append(outputs, folders[i].Output...)
+	}
+	return outputs
+}
+
+// planOutputs returns the output roots a plan moves or bundles files to.
+func planOutputs(plan *runPlan) []string {
+	var outputs []string
+	for _, rp := range plan.Rules {
+		for _, op := range rp.Ops {
+			if op.Op != "delete" && op.Target != "" {
+				outputs = append(outputs, op.Target)
+			}
+		}
+	}
+	return outputs
+}
+
+// metricTargets returns the distinct local outputs, sorted. Free space is not known for
+// remote ones.
+func metricTargets(outputs []string) []string {
+	seen := map[string]bool{}
+	var targets []string
+	for _, p := range outputs {
+		if !seen[p] && !isRemote(p) {
+			seen[p] = true
+			targets = append(targets, p)
+		}
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// serveMetrics exposes /metrics on addr in the background. Listen errors are logged.
+func serveMetrics(addr string, appLogger *AppLogger) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := appLogger.WriteMetrics(w); err != nil {
+			appLogger.Warn("metrics write failed: %v", err)
+		}
+	})
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			appLogger.Error("metrics endpoint on %s failed: %v", addr, err)
+		}
+	}()
+	appLogger.Info("Serving metrics on http://%s/metrics", addr)
+	return srv
+}
+
+// writeMetricsFile writes metrics for the node_exporter textfile collector. The file is
+// written to a temp name and renamed so the collector never reads a partial file.
+func writeMetricsFile(path string, appLogger *AppLogger) error {
+	var buf bytes.Buffer
+	if err := appLogger.WriteMetrics(&buf); err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
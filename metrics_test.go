@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	target := t.TempDir()
+	unwritten := t.TempDir()
+	deleteRoot := t.TempDir()
+	logger := newTestLogger(t, &bytes.Buffer{}, logConfig{})
+	logger.SetOutputs(ruleOutputs([]folder{{Output: []string{target, unwritten, "sftp://nas/archive"}}}))
+	ruleLog := logger.WithRule(`Scans "A"`)
+	ruleLog.StartRule()
+	ruleLog.CountMoved(target, 100)
+	ruleLog.CountMoved(target, 50)
+	ruleLog.CountDeleted(target, 7)
+	ruleLog.CountDeleted(deleteRoot, 3)
+	ruleLog.CountSkipped(2)
+	_, notFound := os.Stat(filepath.Join(target, "missing"))
+	ruleLog.Error("rename failed: %v", notFound)
+	ruleLog.Error("Balancer error: %v", errors.New("balancer: empty folders slice"))
+	ruleLog.FinishRule()
+
+	path := filepath.Join(t.TempDir(), "sloth.prom")
+	if err := writeMetricsFile(path, logger); err != nil {
+		t.Fatalf("writeMetricsFile: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+
+	labels := `rule="Scans \"A\"",target="` + target + `"`
+	for _, want := range []string{
+		"# TYPE sloth_files_moved_total counter",
+		"sloth_files_moved_total{" + labels + "} 2",
+		"sloth_bytes_moved_total{" + labels + "} 150",
+		"sloth_files_deleted_total{" + labels + "} 1",
+		`sloth_files_skipped_total{rule="Scans \"A\""} 2`,
+		`sloth_rule_duration_seconds_bucket{rule="Scans \"A\"",le="+Inf"} 1`,
+		`sloth_rule_duration_seconds_count{rule="Scans \"A\""} 1`,
+		`sloth_errors_total{kind="not_found"} 1`,
+		`sloth_errors_total{kind="internal"} 1`,
+		`sloth_target_free_bytes{target="` + target + `"}`,
+		`sloth_target_free_bytes{target="` + unwritten + `"}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %q\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{
+		`sloth_target_free_bytes{target="` + deleteRoot + `"}`,
+		`sloth_target_free_bytes{target="sftp://nas/archive"}`,
+	} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("metrics include %q, which is not a local output\n%s", unwanted, out)
+		}
+	}
+	if strings.Count(out, "# TYPE sloth_errors_total") != 1 {
+		t.Errorf("family header repeated:\n%s", out)
+	}
+}
+
+func TestDurationHistogram(t *testing.T) {
+	var h durationHistogram
+	h.observe(200 * time.Millisecond)
+	h.observe(2 * time.Hour)
+	if h.counts[0] != 0 || h.counts[1] != 1 || h.counts[len(h.counts)-1] != 1 || h.count != 2 {
+		t.Errorf("unexpected histogram: %+v", h)
+	}
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// planOp is one filesystem operation a run would perform.
+type planOp struct {
+	Op       string `json:"op"` // "mkdir", "download", "move", "bundle" or "delete"
+	Src      string `json:"src,omitempty"`
+	Dst      string `json:"dst,omitempty"`
+	Target   string `json:"target,omitempty"` // output root picked by the balancer, or the delete root
+	Conflict string `json:"conflict,omitempty"`
+
+	Source   *fingerprint     `json:"source,omitempty"`   // Src as planned, for moves and deletes
+	Compress *compressOptions `json:"compress,omitempty"` // moves that compress into Dst
+	Bundle   *bundleOptions   `json:"bundle,omitempty"`   // for "bundle": Src is packed into the archive Dst
+	Encrypt  *encryptOptions  `json:"encrypt,omitempty"`  // moves that encrypt into Dst
+
+	DeleteRemote bool   `json:"deleteRemote,omitempty"` // for "download": remove Src once it is staged
+	Action       string `json:"action,omitempty"`       // for "move": copy or link action that leaves Src; see action.go
+	Follow       bool   `json:"follow,omitempty"`       // Src may be a symbolic link that stands for its target; see scan.go
+	Sentinel     string `json:"sentinel,omitempty"`     // for "move" and "bundle": marker suffix removed with Src; see ready.go
+	CompanionOf  string `json:"companionOf,omitempty"`  // for "move": Src of the file this companion goes with; see companion.go
+	Verify       bool   `json:"verify,omitempty"`       // for "move": hash Src, check Dst and record it in the manifest; see verify.go
+
+	Preserve *preserveOptions `json:"preserve,omitempty"` // for "move": metadata kept by copies; see metadata.go
+	DirMode  string           `json:"dirMode,omitempty"`  // the rule's dirMode, for folders apply creates
+	DirOwner string           `json:"dirOwner,omitempty"` // the rule's dirOwner, for folders apply creates
+}
+
+// fingerprint identifies the version of a file a plan entry was computed for.
+type fingerprint struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+func fingerprintOf(fi os.FileInfo) *fingerprint {
+	return &fingerprint{Size: fi.Size(), ModTime: fi.ModTime()}
+}
+
+// matches reports whether fi is still the planned version of the file.
+func (f *fingerprint) matches(fi os.FileInfo) bool {
+	return f != nil && fi.Size() == f.Size && fi.ModTime().Equal(f.ModTime)
+}
+
+// withDirs sets the rule's folder settings on op, for the folders apply creates.
+func (f *folder) withDirs(op planOp) planOp {
+	op.DirMode, op.DirOwner = f.DirMode, f.DirOwner
+	return op
+}
+
+// dirs returns the folder settings of the rule, named rule, that op was planned for.
+func (op *planOp) dirs(rule string) (dirSettings, error) {
+	return newDirSettings(&folder{Name: rule, DirMode: op.DirMode, DirOwner: op.DirOwner})
+}
+
+// writeLocal writes the file of a move op into its local output like a run of its rule
+// would (see localMove). On failure it returns what failed, for the log.
+func (op *planOp) writeLocal(rule string) (string, error) {
+	dirs, err := op.dirs(rule)
+	if err != nil {
+		return "mkdir failed", err
+	}
+	m := localMove{dirs: dirs, preserve: op.Preserve, compress: op.Compress, encrypt: op.Encrypt, follow: op.Follow && isSymlink(op.Src), verify: op.Verify}
+	if op.Action != "" {
+		return m.place(op.Action, op.Src, op.Dst)
+	}
+	return m.move(op.Src, op.Dst)
+}
+
+func (op *planOp) size() int64 {
+	if op.Source == nil {
+		return 0
+	}
+	return op.Source.Size
+}
+
+// rulePlan is every operation of one rule, in the order a run would perform them.
+type rulePlan struct {
+	Name   string   `json:"name"`
+	DryRun bool     `json:"dryRun,omitempty"` // the rule itself is dry-run only
+	Ops    []planOp `json:"ops"`
+	Errors []string `json:"errors,omitempty"` // problems that would stop the rule
+}
+
+// runPlan is the output of `sloth plan`.
+type runPlan struct {
+	Created    time.Time  `json:"created"`
+	ConfigPath string     `json:"configPath"`
+	ConfigHash string     `json:"configSha256,omitempty"`
+	Rules      []rulePlan `json:"rules"`
+}
+
+// planner mirrors processFolder and deleteFiles without changing the filesystem.
+// Directories and destinations planned by earlier operations are tracked so later
+// rules see the state a run would have left behind.
+type planner struct {
+	balancer *Balancer
+	now      time.Time
+	dirs     map[string]bool   // directories planned for creation
+	claimed  map[string]string // planned move destination -> source
+}
+
+func newPlanner() *planner {
+	return &planner{
+		balancer: &Balancer{},
+		now:      time.Now(),
+		dirs:     make(map[string]bool),
+		claimed:  make(map[string]string),
+	}
+}
+
+// buildPlan computes the operations a run of folders would perform right now.
+func buildPlan(folders []folder) *runPlan {
+	p := newPlanner()
+	plan := &runPlan{Created: p.now, ConfigPath: configPath, ConfigHash: hashFile(configPath), Rules: []rulePlan{}}
+	for i := range folders {
+		plan.Rules = append(plan.Rules, p.rule(&folders[i]))
+	}
+	return plan
+}
+
+// dirExists reports whether dir exists or is planned. Remote folders always count as
+// existing: uploads create them.
+func (p *planner) dirExists(dir string) bool {
+	if p.dirs[dir] || isRemote(dir) {
+		return true
+	}
+	fi, err := os.Stat(dir)
+	return err == nil && fi.IsDir()
+}
+
+func (p *planner) rule(f *folder) rulePlan {
+	rp := rulePlan{Name: f.Name, DryRun: f.DryRun, Ops: []planOp{}}
+
+	if strings.EqualFold(f.FolderType, "delete") {
+		if f.DeleteOlderThan > 0 && f.Input != "" {
+			p.deletes(&rp, f.Input, f.Extension, false, f.DeleteOlderThan, f.Scan, nil)
+		}
+		return rp
+	}
+
+	for _, outPath := range f.Output {
+		if isRemote(outPath) {
+			if _, err := storageFor(outPath); err != nil {
+				rp.Errors = append(rp.Errors, fmt.Sprintf("cannot connect to output: %v", err))
+				return rp
+			}
+			continue
+		}
+		if p.dirExists(outPath) {
+			continue
+		}
+		if parent := filepath.Dir(outPath); !p.dirExists(parent) {
+			rp.Errors = append(rp.Errors, fmt.Sprintf("output parent directory does not exist (cannot auto-create): %s", parent))
+			return rp
+		}
+		p.dirs[outPath] = true
+		rp.Ops = append(rp.Ops, f.withDirs(planOp{Op: "mkdir", Dst: outPath}))
+	}
+
+	input, groups, err := p.inputs(&rp, f)
+	if err != nil {
+		rp.Errors = append(rp.Errors, fmt.Sprintf("ReadDir error: %v", err))
+		return rp
+	}
+
+	var moves []planOp
+	var bundled []bundleFile
+	for _, g := range groups {
+		fi := g.fi
+		if f.Bundle != nil {
+			bundled = append(bundled, bundleFile{src: filepath.Join(input, fi.Name()), name: fi.Name(), fi: fi})
+			continue
+		}
+		var companions []string
+		for _, c := range g.companions {
+			companions = append(companions, c.Name())
+		}
+		target := f.placedOn(input, fi, companions)
+		if target == "" {
+			if target, err = p.balancer.Next(f.Output); err != nil {
+				rp.Errors = append(rp.Errors, err.Error())
+				return rp
+			}
+		}
+		outFolder := outputFolder(target, fi, f.FolderType)
+		if outFolder == "" {
+			rp.Errors = append(rp.Errors, fmt.Sprintf("unknown folderType %q", f.FolderType))
+			return rp
+		}
+		// Companions go to the primary file's target and folder.
+		t, err := f.renameTokensOf(input, fi)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			rp.Errors = append(rp.Errors, fmt.Sprintf("cannot name %s: %v", fi.Name(), err))
+			continue
+		}
+		suffix := f.Compress.ext() + f.Encrypt.ext()
+		outNames := f.outNames(t, companions, func(src, out string) bool {
+			dst := joinLoc(outFolder, out+suffix)
+			_, claimed := p.claimed[dst]
+			return claimed || f.nameTaken(filepath.Join(input, src), dst)
+		})
+		for i, fi := range append([]fs.FileInfo{fi}, g.companions...) {
+			src := filepath.Join(input, fi.Name())
+			dst := joinLoc(outFolder, outNames[i]+suffix)
+			if a := f.action(); a != "move" && alreadyPlaced(a, src, dst, f.Compress != nil || f.Encrypt != nil) {
+				continue
+			}
+			if !p.dirExists(outFolder) {
+				p.dirs[outFolder] = true
+				rp.Ops = append(rp.Ops, f.withDirs(planOp{Op: "mkdir", Dst: outFolder, Target: target}))
+			}
+
+			op := f.withDirs(planOp{Op: "move", Src: src, Dst: dst, Target: target, Source: fingerprintOf(fi), Compress: f.Compress, Encrypt: f.Encrypt, Follow: f.Scan.symlinks() == "follow", Sentinel: f.Ready.sentinel(), Verify: f.Verify, Preserve: f.Preserve})
+			if a := f.action(); a != "move" {
+				op.Action = a
+			}
+			if i > 0 {
+				op.CompanionOf = filepath.Join(input, g.fi.Name())
+			}
+			if prev, ok := p.claimed[op.Dst]; ok {
+				op.Conflict = "also the destination of " + prev
+			} else if _, err := lstatLoc(op.Dst); err == nil {
+				op.Conflict = "destination exists and would be replaced"
+			}
+			p.claimed[op.Dst] = src
+			moves = append(moves, op)
+		}
+	}
+	if f.Bundle != nil {
+		groups, err := groupBundles(p.balancer, f, bundled)
+		if err != nil {
+			rp.Errors = append(rp.Errors, err.Error())
+			return rp
+		}
+		for _, g := range groups {
+			if dir := filepath.Dir(g.path); !p.dirExists(dir) {
+				p.dirs[dir] = true
+				rp.Ops = append(rp.Ops, f.withDirs(planOp{Op: "mkdir", Dst: dir, Target: g.target}))
+			}
+			for _, bf := range g.files {
+				moves = append(moves, f.withDirs(planOp{Op: "bundle", Src: bf.src, Dst: g.path, Target: g.target, Source: fingerprintOf(bf.fi), Bundle: f.Bundle, Sentinel: f.Ready.sentinel()}))
+			}
+		}
+	}
+	rp.Ops = append(rp.Ops, moves...)
+
+	if f.DeleteOlderThan > 0 {
+		for _, outPath := range f.Output {
+			p.deletes(&rp, outPath, f.Sanitize.ext(f.Extension), f.wrapsOutputs(), f.DeleteOlderThan, f.Scan, moves)
+		}
+	}
+	return rp
+}
+
+// inputs returns the directory a rule moves files from and the ready files in it,
+// grouped with their companions. For a remote input that is the staging directory:
+// files already staged, plus a "download" op for every new remote file, listed with the
+// remote file's size and time.
+func (p *planner) inputs(rp *rulePlan, f *folder) (string, []fileGroup, error) {
+	input := f.Input
+	if isRemote(f.Input) {
+		input = stagingDir(f.Name)
+	}
+	files, _, err := scanRule(input, f)
+	if err != nil && (input == f.Input || !errors.Is(err, fs.ErrNotExist)) {
+		return "", nil, err
+	}
+	groups, _, err := sortReadyGroups(f.Ready, input, f.groupFiles(files), func() bool { return false })
+	if err != nil {
+		rp.Errors = append(rp.Errors, fmt.Sprintf("readiness check: %v", err))
+	}
+	if input == f.Input {
+		return input, groups, nil
+	}
+
+	st, err := storageFor(f.Input)
+	if err != nil {
+		return "", nil, err
+	}
+	state, err := loadFetchState(input)
+	if err != nil {
+		return "", nil, err
+	}
+	remote, err := listRemote(st, f, state)
+	if err != nil {
+		return "", nil, err
+	}
+	var fetched []fs.FileInfo
+	for _, rf := range remote {
+		op := planOp{Op: "download", Src: rf.loc, Dst: filepath.Join(input, rf.fi.Name()), Target: f.Input, Source: fingerprintOf(rf.fi), DeleteRemote: f.DeleteRemote}
+		if _, err := os.Lstat(op.Dst); err == nil {
+			op.Conflict = "a file of this name is already staged"
+		}
+		rp.Ops = append(rp.Ops, op)
+		fetched = append(fetched, rf.fi)
+	}
+	return input, append(groups, f.groupFiles(fetched)...), nil
+}
+
+// deletes plans the retention pass of deleteFiles over root. Files moved into root
+// earlier in the same rule keep their mtime, so old ones are deleted too.
+func (p *planner) deletes(rp *rulePlan, root, extension string, wrapped bool, olderThan int, sc *scanOptions, moved []planOp) {
+	if abs, err := filepath.Abs(root); err == nil && !isRemote(root) && filepath.Dir(abs) == abs {
+		rp.Errors = append(rp.Errors, "safety guard: refusing to delete from a filesystem root: "+abs)
+		return
+	}
+	cutoff := p.now.AddDate(0, 0, -olderThan)
+	expired := func(name string, mtime time.Time) bool {
+		return matchesExtension(name, extension, wrapped) && mtime.Before(cutoff)
+	}
+	replaced := make(map[string]bool, len(moved))
+	for _, m := range moved {
+		replaced[m.Dst] = true
+	}
+
+	err := listLoc(root, sc, func(path string, fi fs.FileInfo) error {
+		if fi.Name() == manifestName || strings.HasSuffix(fi.Name(), bundleIndexSuffix) || replaced[path] {
+			return nil
+		}
+		if expired(fi.Name(), fi.ModTime()) {
+			rp.Ops = append(rp.Ops, planOp{Op: "delete", Src: path, Target: root, Source: fingerprintOf(fi), Follow: sc.symlinks() == "follow" && !isRemote(root)})
+		}
+		return nil
+	}, func(path string, err error) {
+		rp.Errors = append(rp.Errors, fmt.Sprintf("%s: %v", path, err))
+	})
+	if errors.Is(err, fs.ErrNotExist) && p.dirs[root] {
+		err = nil // created by this plan
+	}
+	if err != nil {
+		rp.Errors = append(rp.Errors, fmt.Sprintf("delete traversal error: %v", err))
+	}
+
+	// A bundle gets the mtime of its newest member, including members it already had.
+	var dsts []string
+	latest := make(map[string]*fingerprint)
+	for _, m := range moved {
+		if m.Target != root {
+			continue
+		}
+		fp, ok := latest[m.Dst]
+		if !ok {
+			fp = &fingerprint{}
+			if fi, err := os.Stat(m.Dst); err == nil && m.Op == "bundle" {
+				fp = &fingerprint{Size: fi.Size(), ModTime: fi.ModTime()}
+			}
+			latest[m.Dst] = fp
+			dsts = append(dsts, m.Dst)
+		}
+		if m.Op == "bundle" {
+			fp.Size += m.Source.Size
+		} else {
+			fp.Size = m.Source.Size
+		}
+		if m.Source.ModTime.After(fp.ModTime) {
+			fp.ModTime = m.Source.ModTime
+		}
+	}
+	for _, dst := range dsts {
+		if fp := latest[dst]; expired(dst, fp.ModTime) {
+			rp.Ops = append(rp.Ops, planOp{Op: "delete", Src: dst, Target: root, Source: fp})
+		}
+	}
+}
+
+// problems counts conflicts and rule errors across the plan.
+func (plan *runPlan) problems() int {
+	n := 0
+	for i := range plan.Rules {
+		n += len(plan.Rules[i].Errors)
+		for _, op := range plan.Rules[i].Ops {
+			if op.Conflict != "" {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// writePlan renders plan as "tree" or "json".
+func writePlan(w io.Writer, plan *runPlan, format string) error {
+	switch strings.ToLower(format) {
+	case "", "tree":
+		printPlanTree(w, plan)
+		return nil
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	default:
+		return fmt.Errorf("%w: plan format %q: must be tree or json", errInvalidConfig, format)
+	}
+}
+
+// printPlanTree prints each rule's moves grouped by destination folder, diff style:
+// "+" for a move, "-" for a delete, "<" for a download and "!" for a conflict.
+func printPlanTree(w io.Writer, plan *runPlan) {
+	for i := range plan.Rules {
+		rp := &plan.Rules[i]
+		var moves, deletes int
+		var moveBytes, deleteBytes int64
+		newDirs := map[string]bool{}
+		byFolder := map[string][]planOp{}
+		for _, op := range rp.Ops {
+			switch op.Op {
+			case "mkdir":
+				newDirs[op.Dst] = true
+			case "move":
+				moves++
+				moveBytes += op.size()
+				byFolder[dirLoc(op.Dst)] = append(byFolder[dirLoc(op.Dst)], op)
+			case "bundle":
+				moves++
+				moveBytes += op.size()
+				byFolder[op.Dst] = append(byFolder[op.Dst], op)
+			case "delete":
+				deletes++
+				deleteBytes += op.size()
+			}
+		}
+
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s: %d move(s), %s; %d delete(s), %s", rp.Name, moves, formatBytes(moveBytes), deletes, formatBytes(deleteBytes))
+		if rp.DryRun {
+			fmt.Fprint(w, " (rule is dry-run only)")
+		}
+		fmt.Fprintln(w)
+
+		for _, op := range rp.Ops {
+			if op.Op == "download" {
+				sign := "<"
+				if op.Conflict != "" {
+					sign = "!"
+				}
+				fmt.Fprintf(w, "  %s %s  (%s)", sign, op.Src, formatBytes(op.size()))
+				if op.Conflict != "" {
+					fmt.Fprintf(w, "  %s", op.Conflict)
+				}
+				fmt.Fprintln(w)
+			}
+		}
+		for _, op := range rp.Ops {
+			if op.Op == "mkdir" && byFolder[op.Dst] == nil {
+				fmt.Fprintf(w, "  %s%c  (new)\n", op.Dst, locSeparator(op.Dst))
+			}
+		}
+		folders := make([]string, 0, len(byFolder))
+		for dir := range byFolder {
+			folders = append(folders, dir)
+		}
+		sort.Strings(folders)
+		for _, dir := range folders {
+			mark := ""
+			if newDirs[dir] {
+				mark = "  (new)"
+			}
+			if byFolder[dir][0].Op == "bundle" {
+				fmt.Fprintf(w, "  %s  (bundle)\n", dir)
+			} else {
+				fmt.Fprintf(w, "  %s%c%s\n", dir, locSeparator(dir), mark)
+			}
+			for _, op := range byFolder[dir] {
+				sign := "+"
+				if op.Conflict != "" {
+					sign = "!"
+				}
+				name := filepath.Base(op.Dst)
+				if op.Op == "bundle" {
+					name = filepath.Base(op.Src)
+				}
+				fmt.Fprintf(w, "    %s %s  <- %s  (%s", sign, name, op.Src, formatBytes(op.size()))
+				if op.Action != "" {
+					fmt.Fprintf(w, ", %s", op.Action)
+				}
+				fmt.Fprint(w, ")")
+				if op.Conflict != "" {
+					fmt.Fprintf(w, "  %s", op.Conflict)
+				}
+				fmt.Fprintln(w)
+			}
+		}
+		for _, op := range rp.Ops {
+			if op.Op == "delete" {
+				fmt.Fprintf(w, "  - %s  (%s, modified %s)\n", op.Src, formatBytes(op.size()), op.Source.ModTime.Format("2006-01-02"))
+			}
+		}
+		for _, e := range rp.Errors {
+			fmt.Fprintf(w, "  ! %s\n", e)
+		}
+	}
+}
+
+// formatBytes renders n with a binary unit, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildPlan(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	outA := filepath.Join(dir, "outA")
+	outB := filepath.Join(dir, "outB") // missing; parent exists so it would be created
+	if err := os.MkdirAll(in, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(outA, 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().AddDate(0, 0, -100)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "skip.log"} {
+		p := filepath.Join(in, name)
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// c.txt lands in outA again (round robin) where a file of that name already exists.
+	if err := os.WriteFile(filepath.Join(outA, "c.txt"), []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	folders := []folder{
+		{Name: "Archive", Input: in, Output: []string{outA, outB}, Extension: ".txt", FolderType: "4", DeleteOlderThan: 30},
+		{Name: "Unmounted", Input: in, Output: []string{filepath.Join(dir, "missing", "out")}, FolderType: "4"},
+	}
+	plan := buildPlan(folders)
+
+	if _, err := os.Stat(outB); !os.IsNotExist(err) {
+		t.Fatalf("plan created %s", outB)
+	}
+
+	archive := plan.Rules[0]
+	var got []string
+	for _, op := range archive.Ops {
+		s := op.Op + " " + filepath.Base(op.Src) + " " + op.Dst
+		if op.Conflict != "" {
+			s += " !"
+		}
+		got = append(got, s)
+	}
+	want := []string{
+		"mkdir . " + outB,
+		"move a.txt " + filepath.Join(outA, "a.txt"),
+		"move b.txt " + filepath.Join(outB, "b.txt"),
+		"move c.txt " + filepath.Join(outA, "c.txt") + " !",
+		"delete a.txt ", // moved files keep their old mtime
+		"delete c.txt ",
+		"delete b.txt ",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("ops:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if errs := plan.Rules[1].Errors; len(errs) != 1 || !strings.Contains(errs[0], "does not exist") {
+		t.Errorf("Unmounted errors = %v", errs)
+	}
+	if n := plan.problems(); n != 2 {
+		t.Errorf("problems() = %d, want 2", n)
+	}
+}
+
+func TestWritePlan(t *testing.T) {
+	plan := &runPlan{Rules: []rulePlan{{
+		Name: "Archive",
+		Ops: []planOp{
+			{Op: "mkdir", Dst: filepath.Join("out", "2024")},
+			{Op: "move", Src: filepath.Join("in", "a.txt"), Dst: filepath.Join("out", "2024", "a.txt"), Target: "out", Source: &fingerprint{Size: 2048}},
+			{Op: "delete", Src: filepath.Join("out", "old.txt"), Source: &fingerprint{Size: 10}},
+		},
+	}}}
+
+	var tree bytes.Buffer
+	if err := writePlan(&tree, plan, "tree"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Archive: 1 move(s), 2.0 KiB; 1 delete(s), 10 B", "(new)", "+ a.txt", "- " + filepath.Join("out", "old.txt")} {
+		if !strings.Contains(tree.String(), want) {
+			t.Errorf("tree missing %q:\n%s", want, tree.String())
+		}
+	}
+
+	var js bytes.Buffer
+	if err := writePlan(&js, plan, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var back runPlan
+	if err := json.Unmarshal(js.Bytes(), &back); err != nil {
+		t.Fatal(err)
+	}
+	if len(back.Rules) != 1 || len(back.Rules[0].Ops) != 3 || back.Rules[0].Ops[1].Target != "out" {
+		t.Errorf("json round trip = %+v", back)
+	}
+
+	if err := writePlan(&js, plan, "yaml"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
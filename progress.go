@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	progressBarRefresh = 200 * time.Millisecond
+	progressBarWidth   = 30
+)
+
+// progressSettings selects how rule progress is shown.
+type progressSettings struct {
+	bar      *barWriter    // draw a live bar on the terminal; nil logs progress lines instead
+	interval time.Duration // between progress lines when logging
+}
+
+// progressOutput is the run's progress configuration; nil disables progress reporting.
+var progressOutput *progressSettings
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// barWriter owns the terminal line holding the progress bar. Console output written
+// through it clears the bar first and redraws it afterwards, so log echoes and the bar
+// don't overwrite each other.
+type barWriter struct {
+	mu   sync.Mutex
+	out  io.Writer
+	line string
+}
+
+func (b *barWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.line != "" {
+		io.WriteString(b.out, "\r\033[K")
+	}
+	n, err := b.out.Write(p)
+	if b.line != "" {
+		io.WriteString(b.out, b.line)
+	}
+	return n, err
+}
+
+func (b *barWriter) draw(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.line = line
+	io.WriteString(b.out, "\r\033[K"+line)
+}
+
+func (b *barWriter) clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.line != "" {
+		io.WriteString(b.out, "\r\033[K")
+		b.line = ""
+	}
+}
+
+// progress tracks one pass of one rule. Workers call add once per file; all methods
+// are no-ops on a nil progress.
+type progress struct {
+	logger *AppLogger
+	total  int64
+	done   atomic.Int64
+	bytes  atomic.Int64
+	start  time.Time
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// startProgress begins reporting for a pass over total files.
+func startProgress(ruleLog *AppLogger, total int) *progress {
+	s := progressOutput
+	if s == nil || total == 0 {
+		return nil
+	}
+	p := &progress{logger: ruleLog, total: int64(total), start: time.Now(), stop: make(chan struct{})}
+	p.stopped.Add(1)
+	go p.loop(s)
+	return p
+}
+
+func (p *progress) add(bytes int64) {
+	if p == nil {
+		return
+	}
+	p.done.Add(1)
+	p.bytes.Add(bytes)
+}
+
+// finish stops reporting and removes the bar.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	p.stopped.Wait()
+}
+
+func (p *progress) loop(s *progressSettings) {
+	defer p.stopped.Done()
+	every := s.interval
+	if s.bar != nil {
+		every = progressBarRefresh
+	}
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-p.stop:
+			if s.bar != nil {
+				s.bar.clear()
+			}
+			return
+		case <-tick.C:
+			if s.bar != nil {
+				s.bar.draw(p.barLine())
+			} else {
+				p.logLine()
+			}
+		}
+	}
+}
+
+// rates returns files/s, bytes/s and the estimated time left at the current rate.
+func (p *progress) rates() (filesPerSec, bytesPerSec float64, eta time.Duration) {
+	elapsed := time.Since(p.start).Seconds()
+	if elapsed <= 0 {
+		return 0, 0, 0
+	}
+	done := p.done.Load()
+	filesPerSec = float64(done) / elapsed
+	bytesPerSec = float64(p.bytes.Load()) / elapsed
+	if filesPerSec > 0 {
+		eta = time.Duration(float64(p.total-done) / filesPerSec * float64(time.Second)).Round(time.Second)
+	}
+	return filesPerSec, bytesPerSec, eta
+}
+
+func (p *progress) barLine() string {
+	done := p.done.Load()
+	filled := int(done * progressBarWidth / p.total)
+	filesPerSec, bytesPerSec, eta := p.rates()
+	return fmt.Sprintf("%s [%s%s] %d/%d %3d%% %.0f files/s %.1f MB/s ETA %s",
+		p.logger.rule, strings.Repeat("#", filled), strings.Repeat(".", progressBarWidth-filled),
+		done, p.total, done*100/p.total, filesPerSec, bytesPerSec/1e6, eta)
+}
+
+func (p *progress) logLine() {
+	filesPerSec, bytesPerSec, eta := p.rates()
+	p.logger.InfoAttrs("Progress",
+		slog.Int64("done", p.done.Load()),
+		slog.Int64("total", p.total),
+		bytesAttr(p.bytes.Load()),
+		slog.String("filesPerSec", fmt.Sprintf("%.1f", filesPerSec)),
+		slog.String("mbPerSec", fmt.Sprintf("%.2f", bytesPerSec/1e6)),
+		slog.Duration("eta", eta))
+}
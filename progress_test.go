@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressLogLines(t *testing.T) {
+	var buf bytes.Buffer
+	al := newTestLogger(t, &buf, logConfig{Format: "text", Level: "info", Quiet: true}).WithRule("Archive")
+
+	progressOutput = &progressSettings{interval: 10 * time.Millisecond}
+	defer func() { progressOutput = nil }()
+
+	p := startProgress(al, 4)
+	p.add(1000)
+	p.add(1000)
+	time.Sleep(50 * time.Millisecond)
+	p.finish()
+
+	out := buf.String()
+	if !strings.Contains(out, "msg=Progress") || !strings.Contains(out, "done=2 total=4 bytes=2000") {
+		t.Errorf("no progress line logged:\n%s", out)
+	}
+	if startProgress(al, 0) != nil {
+		t.Error("progress started for an empty pass")
+	}
+	var nilProgress *progress
+	nilProgress.add(1)
+	nilProgress.finish()
+}
+
+func TestBarWriter(t *testing.T) {
+	var term bytes.Buffer
+	bw := &barWriter{out: &term}
+	bw.draw("Archive [##..] 1/2")
+	bw.Write([]byte("WARN: slow disk\n"))
+	bw.clear()
+
+	want := "\r\033[KArchive [##..] 1/2" + "\r\033[KWARN: slow disk\nArchive [##..] 1/2" + "\r\033[K"
+	if term.String() != want {
+		t.Errorf("terminal output = %q, want %q", term.String(), want)
+	}
+
+	p := &progress{logger: &AppLogger{rule: "Archive"}, total: 4, start: time.Now().Add(-time.Second)}
+	p.add(2_000_000)
+	p.add(2_000_000)
+	if line := p.barLine(); !strings.HasPrefix(line, "Archive [###############...............] 2/4  50%") || !strings.Contains(line, "MB/s ETA") {
+		t.Errorf("barLine() = %q", line)
+	}
+}
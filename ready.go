@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// readyOptions keep a rule from moving files that are still being written, e.g. by a
+// scanner or an upload in progress. A file that fails any check is deferred to a later
+// run and counted as skipped.
+type readyOptions struct {
+	// MinAge is how long ago a file must have been modified last, e.g. "5m".
+	MinAge string `json:"minAge,omitempty"`
+	// StableFor is how long a file's size and mtime must stay the same, e.g. "10s".
+	// The rule waits this long once per run, for all its files together.
+	StableFor string `json:"stableFor,omitempty"`
+	// NotOpen defers files that another process has open (Linux only).
+	NotOpen bool `json:"notOpen,omitempty"`
+	// Sentinel is the suffix of marker files written once a file is complete: with
+	// ".done", "scan.pdf" is ready once "scan.pdf.done" or "scan.done" exists. Markers
+	// are never moved themselves, and are removed with the file they mark.
+	Sentinel string `json:"sentinel,omitempty"`
+}
+
+func (r *readyOptions) validate() error {
+	if _, err := parseReadyDuration("minAge", r.MinAge); err != nil {
+		return err
+	}
+	if _, err := parseReadyDuration("stableFor", r.StableFor); err != nil {
+		return err
+	}
+	if r.NotOpen && !openFilesSupported {
+		return errors.New("ready: notOpen needs /proc and is only supported on Linux")
+	}
+	if r.Sentinel != "" && (!strings.HasPrefix(r.Sentinel, ".") || strings.ContainsAny(r.Sentinel, `/\`)) {
+		return fmt.Errorf("ready: sentinel %q must be a suffix like .done", r.Sentinel)
+	}
+	return nil
+}
+
+func parseReadyDuration(field, s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("ready: %s %q must be a duration like 30s or 5m", field, s)
+	}
+	return d, nil
+}
+
+// minAge and stableFor return the parsed durations; validate reports bad ones.
+func (r *readyOptions) minAge() time.Duration {
+	d, _ := parseReadyDuration("minAge", r.MinAge)
+	return d
+}
+
+func (r *readyOptions) stableFor() time.Duration {
+	d, _ := parseReadyDuration("stableFor", r.StableFor)
+	return d
+}
+
+func (r *readyOptions) String() string {
+	var checks []string
+	if r.MinAge != "" {
+		checks = append(checks, "modified "+r.MinAge+" ago")
+	}
+	if r.StableFor != "" {
+		checks = append(checks, "stable for "+r.StableFor)
+	}
+	if r.NotOpen {
+		checks = append(checks, "not open")
+	}
+	if r.Sentinel != "" {
+		checks = append(checks, "marked with "+r.Sentinel)
+	}
+	return strings.Join(checks, ", ")
+}
+
+// sentinel returns the marker suffix, or "" for a nil r.
+func (r *readyOptions) sentinel() string {
+	if r == nil {
+		return ""
+	}
+	return r.Sentinel
+}
+
+// isSentinel reports whether name is a marker file of suffix.
+func isSentinel(name, suffix string) bool {
+	return suffix != "" && strings.HasSuffix(name, suffix)
+}
+
+// sentinelPaths returns the marker files of path for suffix: path+suffix and the path
+// with its extension replaced by suffix.
+func sentinelPaths(path, suffix string) []string {
+	if suffix == "" {
+		return nil
+	}
+	return []string{path + suffix, strings.TrimSuffix(path, filepath.Ext(path)) + suffix}
+}
+
+// removeSentinels removes the markers of a file that was moved, so they cannot mark a
+// new file of the same name as ready.
+func removeSentinels(path, suffix string) {
+	for _, p := range sentinelPaths(path, suffix) {
+		os.Remove(p)
+	}
+}
+
+// readiness holds what a rule's readiness checks found for one scan of its input.
+type readiness struct {
+	opts    *readyOptions
+	now     time.Time
+	open    map[fileID]bool
+	changed map[string]bool
+}
+
+// checkReadiness prepares the checks of r for files, found in the same folder. It
+// collects the files other processes have open and, with stableFor, waits (until
+// interrupted reports true) and notes which files changed meanwhile. If the open files
+// cannot be listed, every file counts as open and the error is returned.
+func checkReadiness(r *readyOptions, dir string, files []fs.FileInfo, interrupted func() bool) (*readiness, error) {
+	rd := &readiness{opts: r, now: time.Now()}
+	if r == nil || len(files) == 0 {
+		return rd, nil
+	}
+	var err error
+	if r.NotOpen {
+		rd.open, err = openFiles()
+	}
+	if wait := r.stableFor(); wait > 0 {
+		deadline := time.Now().Add(wait)
+		for !interrupted() && time.Now().Before(deadline) {
+			time.Sleep(min(100*time.Millisecond, time.Until(deadline)))
+		}
+		rd.changed = map[string]bool{}
+		for _, fi := range files {
+			stat := os.Lstat
+			if _, ok := fi.(followedInfo); ok {
+				stat = os.Stat
+			}
+			now, err := stat(filepath.Join(dir, fi.Name()))
+			if err != nil || now.Size() != fi.Size() || !now.ModTime().Equal(fi.ModTime()) {
+				rd.changed[fi.Name()] = true
+			}
+		}
+		rd.now = time.Now()
+	}
+	return rd, err
+}
+
+// reason returns why the file fi in dir is not ready yet, or "".
+func (rd *readiness) reason(dir string, fi fs.FileInfo) string {
+	r := rd.opts
+	if r == nil {
+		return ""
+	}
+	path := filepath.Join(dir, fi.Name())
+	if age := rd.now.Sub(fi.ModTime()); age < r.minAge() {
+		return fmt.Sprintf("modified %s ago, less than %s", age.Round(time.Second), r.MinAge)
+	}
+	if rd.changed[fi.Name()] {
+		return "still changing after " + r.StableFor
+	}
+	if r.NotOpen {
+		if rd.open == nil {
+			return "open files unknown"
+		}
+		if id, ok := fileIDOf(path); !ok || rd.open[id] {
+			return "open by another process"
+		}
+	}
+	if r.Sentinel != "" {
+		marked := false
+		for _, p := range sentinelPaths(path, r.Sentinel) {
+			marked = marked || fileExists(p)
+		}
+		if !marked {
+			return "no " + r.Sentinel + " marker"
+		}
+	}
+	return ""
+}
+
+// deferredFile is a file that is not ready yet, and why.
+type deferredFile struct {
+	fi     fs.FileInfo
+	reason string
+}
+
+// sortReady splits the files of a scan of dir into those ready to be moved and those
+// deferred. Markers are dropped. The error is checkReadiness's.
+func sortReady(r *readyOptions, dir string, files []fs.FileInfo, interrupted func() bool) ([]fs.FileInfo, []deferredFile, error) {
+	if r == nil {
+		return files, nil, nil
+	}
+	var candidates []fs.FileInfo
+	for _, fi := range files {
+		if !isSentinel(fi.Name(), r.Sentinel) {
+			candidates = append(candidates, fi)
+		}
+	}
+	rd, err := checkReadiness(r, dir, candidates, interrupted)
+	var ready []fs.FileInfo
+	var deferred []deferredFile
+	for _, fi := range candidates {
+		if why := rd.reason(dir, fi); why != "" {
+			deferred = append(deferred, deferredFile{fi, why})
+		} else {
+			ready = append(ready, fi)
+		}
+	}
+	return ready, deferred, err
+}
+
+// readyFiles is sortReadyGroups for a run: deferred groups are logged and their files
+// counted as skipped.
+func readyFiles(appLogger *AppLogger, r *readyOptions, dir string, groups []fileGroup) []fileGroup {
+	if r != nil && r.stableFor() > 0 && len(groups) > 0 {
+		appLogger.DebugAttrs("Waiting for files to settle", srcAttr(dir), durationAttr(r.stableFor()))
+	}
+	ready, deferred, err := sortReadyGroups(r, dir, groups, appLogger.Interrupted)
+	if err != nil {
+		appLogger.WarnAttrs("cannot list open files, treating every file as open", errAttr(err))
+	}
+	for _, d := range deferred {
+		appLogger.DebugAttrs("Not ready, deferred: "+d.reason, srcAttr(filepath.Join(dir, d.fi.Name())))
+		appLogger.CountSkipped(d.size())
+	}
+	return ready
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+const openFilesSupported = true
+
+// fileID identifies a file independently of the path it is reached by.
+type fileID struct{ dev, ino uint64 }
+
+func fileIDOf(path string) (fileID, bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileID{}, false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{uint64(st.Dev), uint64(st.Ino)}, true
+}
+
+// openFiles returns the files other processes have open, from /proc/*/fd. Processes
+// whose descriptors cannot be read (those of other users, without privileges) are
+// missed, so a file written by another user is not detected.
+func openFiles() (map[fileID]bool, error) {
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	self := strconv.Itoa(os.Getpid())
+	open := map[fileID]bool{}
+	for _, p := range procs {
+		if _, err := strconv.Atoi(p.Name()); err != nil || p.Name() == self {
+			continue
+		}
+		fdDir := filepath.Join("/proc", p.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue // exited, or not ours to read
+		}
+		for _, fd := range fds {
+			if id, ok := fileIDOf(filepath.Join(fdDir, fd.Name())); ok {
+				open[id] = true
+			}
+		}
+	}
+	return open, nil
+}
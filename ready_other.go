@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+const openFilesSupported = false
+
+type fileID struct{}
+
+func fileIDOf(path string) (fileID, bool) { return fileID{}, false }
+
+func openFiles() (map[fileID]bool, error) { return nil, errors.ErrUnsupported }
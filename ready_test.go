@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+// sortReadyNames runs sortReady over every file in dir and returns the ready names.
+func sortReadyNames(t *testing.T, r *readyOptions, dir string) []string {
+	t.Helper()
+	files, _, err := scanInput(dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready, _, err := sortReady(r, dir, files, func() bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range ready {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestReadinessChecks(t *testing.T) {
+	never := func() bool { return false }
+	for name, tc := range map[string]struct {
+		ready *readyOptions
+		setup func(t *testing.T, dir string)
+		want  []string
+	}{
+		"minAge": {
+			ready: &readyOptions{MinAge: "1h"},
+			setup: func(t *testing.T, dir string) {
+				writeAged(t, dir, "old.pdf", "x", time.Now().Add(-2*time.Hour))
+				writeAged(t, dir, "new.pdf", "x", time.Now().Add(-time.Minute))
+			},
+			want: []string{"old.pdf"},
+		},
+		"sentinel": {
+			ready: &readyOptions{Sentinel: ".done"},
+			setup: func(t *testing.T, dir string) {
+				for _, n := range []string{"a.pdf", "a.pdf.done", "b.pdf", "b.done", "c.pdf"} {
+					writeAged(t, dir, n, "", time.Now())
+				}
+			},
+			want: []string{"a.pdf", "b.pdf"},
+		},
+		"stableFor": {
+			ready: &readyOptions{StableFor: "300ms"},
+			setup: func(t *testing.T, dir string) {
+				writeAged(t, dir, "still.pdf", "x", time.Now().Add(-time.Hour))
+				growing := writeAged(t, dir, "growing.pdf", "x", time.Now().Add(-time.Hour))
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					os.WriteFile(growing, []byte("xx"), 0644)
+				}()
+			},
+			want: []string{"still.pdf"},
+		},
+	} {
+		dir := t.TempDir()
+		tc.setup(t, dir)
+		if got := sortReadyNames(t, tc.ready, dir); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: ready = %q, want %q", name, got, tc.want)
+		}
+	}
+
+	// Without options every file is ready at once.
+	files := []fs.FileInfo{}
+	if ready, deferred, err := sortReady(nil, t.TempDir(), files, never); len(ready) != 0 || deferred != nil || err != nil {
+		t.Errorf("nil options: %v, %v, %v", ready, deferred, err)
+	}
+}
+
+func TestReadinessNotOpen(t *testing.T) {
+	if !openFilesSupported {
+		t.Skip("open files are only detected on Linux")
+	}
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("no sleep command")
+	}
+	dir := t.TempDir()
+	writeAged(t, dir, "closed.pdf", "x", time.Now())
+	open, err := os.Open(writeAged(t, dir, "open.pdf", "x", time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+	// The check ignores sloth's own descriptors, so another process has to hold it.
+	cmd := exec.Command(sleep, "10")
+	cmd.Stdin = open
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { cmd.Process.Kill(); cmd.Wait() }()
+
+	if got := sortReadyNames(t, &readyOptions{NotOpen: true}, dir); !slices.Equal(got, []string{"closed.pdf"}) {
+		t.Errorf("ready = %q, want only closed.pdf", got)
+	}
+}
+
+func TestReadyRuleDefersUntilMarked(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := writeAged(t, in, "scan.pdf", "%PDF", time.Now())
+	rule := folder{Name: "Scans", Input: in, Output: []string{out}, Extension: "", FolderType: "4", Ready: &readyOptions{Sentinel: ".ok"}}
+	if err := validateFolders([]folder{rule}); err != nil {
+		t.Fatal(err)
+	}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	processFolder(al, &Balancer{}, &rule)
+	if _, err := os.Stat(src); err != nil {
+		t.Fatalf("unmarked file was moved: %v", err)
+	}
+	if n := al.counters.stats.rule("Scans").skipped.Load(); n != 1 {
+		t.Errorf("skipped = %d, want 1", n)
+	}
+
+	marker := writeAged(t, in, "scan.ok", "", time.Now())
+	processFolder(al, &Balancer{}, &rule)
+	if _, err := os.Stat(filepath.Join(out, "scan.pdf")); err != nil {
+		t.Fatalf("marked file not moved: %v", err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("marker left behind: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "scan.ok")); !os.IsNotExist(err) {
+		t.Errorf("marker was moved as a file: %v", err)
+	}
+}
+
+func TestReadyValidation(t *testing.T) {
+	base := folder{Name: "r", Input: "in", Output: []string{"out"}, FolderType: "4"}
+	for name, ready := range map[string]*readyOptions{
+		"bad minAge":        {MinAge: "5 minutes"},
+		"negative":          {StableFor: "-1s"},
+		"sentinel no dot":   {Sentinel: "done"},
+		"sentinel with dir": {Sentinel: ".d/one"},
+	} {
+		f := base
+		f.Ready = ready
+		if validateFolders([]folder{f}) == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+	f := base
+	f.Input, f.Ready = "sftp://host/drop", &readyOptions{Sentinel: ".done"}
+	if validateFolders([]folder{f}) == nil {
+		t.Error("sentinel on a remote input: expected validation error")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is only implemented on Linux.
+func cloneFile(dst, src *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// A rule's renameTemplate names the files it moves, e.g. "{date}_{stem}{ext}". Tokens:
+//
+//	{date}       modification date, 2006-01-02; {date:YYYYMMDD} picks the layout from
+//	             YYYY, YY, MM, DD, hh, mm and ss
+//	{stem}       original name without its extension
+//	{ext}        original extension, with the dot
+//	{counter}    lowest number from 1 that gives a name not used in the output folder;
+//	             {counter:3} pads it to 3 digits. Without it, a name in use gets a "_2",
+//	             "_3", ... suffix
+//	{hash}       first 8 hex digits of the file's SHA-256
+//	{rule}       rule name
+//
+// Companions keep the primary file's new name, with their own extension.
+
+// renamePart is literal text or a token of a rename template.
+type renamePart struct {
+	text  string
+	token string
+	arg   string
+}
+
+// parseRenameTemplate splits tmpl into literal text and tokens.
+func parseRenameTemplate(tmpl string) ([]renamePart, error) {
+	var parts []renamePart
+	for tmpl != "" {
+		i := strings.IndexAny(tmpl, "{}")
+		if i < 0 {
+			parts = append(parts, renamePart{text: tmpl})
+			break
+		}
+		if tmpl[i] == '}' {
+			return nil, errors.New("unmatched }")
+		}
+		if i > 0 {
+			parts = append(parts, renamePart{text: tmpl[:i]})
+		}
+		end := strings.IndexByte(tmpl[i:], '}')
+		if end < 0 {
+			return nil, errors.New("unclosed {")
+		}
+		token, arg, _ := strings.Cut(tmpl[i+1:i+end], ":")
+		switch token {
+		case "date", "stem", "ext", "hash", "rule":
+		case "counter":
+			if w, err := strconv.Atoi(arg); arg != "" && (err != nil || w < 1 || w > 9) {
+				return nil, fmt.Errorf("{counter:%s}: width must be 1 to 9", arg)
+			}
+		default:
+			return nil, fmt.Errorf("unknown token {%s}", token)
+		}
+		if arg != "" && token != "date" && token != "counter" {
+			return nil, fmt.Errorf("{%s} takes no argument", token)
+		}
+		parts = append(parts, renamePart{token: token, arg: arg})
+		tmpl = tmpl[i+end+1:]
+	}
+	return parts, nil
+}
+
+func validateRename(f *folder) error {
+	if f.RenameTemplate != "" {
+		parts, err := parseRenameTemplate(f.RenameTemplate)
+		if err != nil {
+			return fmt.Errorf("renameTemplate %q: %v", f.RenameTemplate, err)
+		}
+		if strings.ContainsAny(f.RenameTemplate, `/\`) {
+			return fmt.Errorf("renameTemplate %q: names cannot contain folders; use folderType for those", f.RenameTemplate)
+		}
+		if !slices.ContainsFunc(parts, func(p renamePart) bool { return p.token == "stem" || p.token == "hash" || p.token == "counter" }) {
+			return fmt.Errorf("renameTemplate %q needs {stem}, {hash} or {counter} to give files distinct names", f.RenameTemplate)
+		}
+		if f.renames("hash") && f.Scan.special() == "include" {
+			return errors.New("renameTemplate: special files are never read and have no {hash}")
+		}
+	}
+	if s := f.Sanitize; s != nil && s.MaxLength != 0 {
+		if least := 16 + len(f.Compress.ext()+f.Encrypt.ext()); s.MaxLength < least {
+			return fmt.Errorf("sanitize: maxLength %d is too short; use at least %d", s.MaxLength, least)
+		}
+	}
+	if (f.RenameTemplate != "" || f.Sanitize != nil) && f.Bundle != nil {
+		return errors.New("renameTemplate and sanitize cannot be combined with bundle; bundled files keep their names")
+	}
+	return nil
+}
+
+// renames reports whether the rule's renameTemplate uses token.
+func (f *folder) renames(token string) bool {
+	parts, _ := parseRenameTemplate(f.RenameTemplate)
+	return slices.ContainsFunc(parts, func(p renamePart) bool { return p.token == token })
+}
+
+// sanitizeOptions clean up output names for the filesystems and shares they land on.
+type sanitizeOptions struct {
+	// Windows strips the characters SMB and Windows shares reject (<>:"/\|?* and control
+	// characters) and trailing dots and spaces, and prefixes reserved names like CON or
+	// LPT1 with "_".
+	Windows bool `json:"windows,omitempty"`
+	// NFC normalizes names to Unicode NFC, as macOS clients and most shares expect.
+	NFC bool `json:"nfc,omitempty"`
+	// LowercaseExt lowercases extensions: "IMG.JPG" becomes "IMG.jpg".
+	LowercaseExt bool `json:"lowercaseExt,omitempty"`
+	// MaxLength is the longest name in bytes, including compression and encryption
+	// suffixes. Longer names are shortened before their extension.
+	MaxLength int `json:"maxLength,omitempty"`
+}
+
+func (s *sanitizeOptions) String() string {
+	var opts []string
+	if s.Windows {
+		opts = append(opts, "windows")
+	}
+	if s.NFC {
+		opts = append(opts, "NFC")
+	}
+	if s.LowercaseExt {
+		opts = append(opts, "lowercase extensions")
+	}
+	if s.MaxLength > 0 {
+		opts = append(opts, fmt.Sprintf("max %d bytes", s.MaxLength))
+	}
+	return strings.Join(opts, ", ")
+}
+
+// windowsReserved are device names Windows refuses as file names, with any extension.
+var windowsReserved = map[string]bool{"CON": true, "PRN": true, "AUX": true, "NUL": true}
+
+func init() {
+	for i := 1; i <= 9; i++ {
+		windowsReserved["COM"+strconv.Itoa(i)] = true
+		windowsReserved["LPT"+strconv.Itoa(i)] = true
+	}
+}
+
+// clean applies the character options of s to name; nil s leaves it as is.
+func (s *sanitizeOptions) clean(name string) string {
+	if s == nil {
+		return name
+	}
+	if s.NFC {
+		name = norm.NFC.String(name)
+	}
+	if s.Windows {
+		name = strings.Map(func(r rune) rune {
+			if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+				return -1
+			}
+			return r
+		}, name)
+		name = strings.TrimRight(name, ". ")
+		if base, _, _ := strings.Cut(name, "."); windowsReserved[strings.ToUpper(base)] {
+			name = "_" + name
+		}
+		if name == "" {
+			name = "_"
+		}
+	}
+	if s.LowercaseExt {
+		ext := filepath.Ext(name)
+		name = strings.TrimSuffix(name, ext) + strings.ToLower(ext)
+	}
+	return name
+}
+
+// ext returns the rule extension ext as files of the rule are named in the output.
+func (s *sanitizeOptions) ext(ext string) string {
+	if s != nil && s.LowercaseExt {
+		return strings.ToLower(ext)
+	}
+	return ext
+}
+
+// apply cleans name and shortens it to the maximum length, less reserve bytes for
+// suffixes added later. The extension and whole runes are kept.
+func (s *sanitizeOptions) apply(name string, reserve int) string {
+	name = s.clean(name)
+	if s == nil || s.MaxLength == 0 || len(name) <= s.MaxLength-reserve {
+		return name
+	}
+	limit := s.MaxLength - reserve
+	ext := filepath.Ext(name)
+	if len(ext) > limit/2 {
+		ext = ""
+	}
+	return s.cutStem(strings.TrimSuffix(name, ext), limit-len(ext)) + ext
+}
+
+// numbered returns name with "_n" before its extension, shortened like apply.
+func (s *sanitizeOptions) numbered(name string, n, reserve int) string {
+	ext := filepath.Ext(name)
+	suffix := "_" + strconv.Itoa(n)
+	stem := strings.TrimSuffix(name, ext)
+	if s != nil && s.MaxLength > 0 {
+		stem = s.cutStem(stem, s.MaxLength-reserve-len(ext)-len(suffix))
+	}
+	return stem + suffix + ext
+}
+
+// cutStem shortens stem to at most n bytes of whole runes.
+func (s *sanitizeOptions) cutStem(stem string, n int) string {
+	if len(stem) <= n {
+		return stem
+	}
+	stem = stem[:max(0, n)]
+	for !utf8.ValidString(stem) {
+		stem = stem[:len(stem)-1]
+	}
+	if s.Windows {
+		stem = strings.TrimRight(stem, ". ")
+	}
+	return stem
+}
+
+// renameTokens are the token values of one file.
+type renameTokens struct {
+	name string
+	date time.Time
+	hash string
+	rule string
+}
+
+// renameTokensOf returns the token values of the file name in dir, with info fi. The
+// file is only read for {hash}; if that fails, the error is returned with the other
+// values, and {hash} renders as itself.
+func (f *folder) renameTokensOf(dir string, fi fs.FileInfo) (renameTokens, error) {
+	t := renameTokens{name: fi.Name(), date: fi.ModTime(), rule: f.Name}
+	if !f.renames("hash") {
+		return t, nil
+	}
+	sum, err := sha256File(filepath.Join(dir, fi.Name()))
+	if err != nil {
+		t.hash = "{hash}"
+		return t, err
+	}
+	t.hash = sum[:8]
+	return t, nil
+}
+
+// render fills in the rule's template for t, with counter n.
+func (f *folder) render(t renameTokens, n int) string {
+	if f.RenameTemplate == "" {
+		return t.name
+	}
+	parts, _ := parseRenameTemplate(f.RenameTemplate)
+	var b strings.Builder
+	for _, p := range parts {
+		switch p.token {
+		case "":
+			b.WriteString(p.text)
+		case "date":
+			b.WriteString(formatDate(t.date, p.arg))
+		case "stem":
+			b.WriteString(strings.TrimSuffix(t.name, filepath.Ext(t.name)))
+		case "ext":
+			b.WriteString(filepath.Ext(t.name))
+		case "counter":
+			w, _ := strconv.Atoi(p.arg)
+			fmt.Fprintf(&b, "%0*d", w, n)
+		case "hash":
+			b.WriteString(t.hash)
+		case "rule":
+			b.WriteString(strings.NewReplacer("/", "_", `\`, "_").Replace(t.rule))
+		}
+	}
+	return b.String()
+}
+
+// formatDate formats t with a layout of YYYY, YY, MM, DD, hh, mm and ss, or as
+// 2006-01-02 for an empty layout.
+func formatDate(t time.Time, layout string) string {
+	if layout == "" {
+		return t.Format("2006-01-02")
+	}
+	return strings.NewReplacer(
+		"YYYY", t.Format("2006"), "YY", t.Format("06"), "MM", t.Format("01"), "DD", t.Format("02"),
+		"hh", t.Format("15"), "mm", t.Format("04"), "ss", t.Format("05"),
+	).Replace(layout)
+}
+
+// outNames returns the output names of the file t.name and its companions: the rendered
+// and sanitized template for the file, and its new stem with their own extension for
+// the companions. taken reports whether the output name out for the input file src is
+// in use. Until no name of the group is, {counter} counts up, or, without it, the file's
+// name gets a "_2", "_3", ... suffix: sanitizing and shortening can give different
+// files the same name.
+func (f *folder) outNames(t renameTokens, companions []string, taken func(src, out string) bool) []string {
+	if f.RenameTemplate == "" && f.Sanitize == nil {
+		return append([]string{t.name}, companions...)
+	}
+	reserve := len(f.Compress.ext() + f.Encrypt.ext())
+	srcs := append([]string{t.name}, companions...)
+	counted := f.renames("counter")
+	for n := 1; ; n++ {
+		out := f.Sanitize.apply(f.render(t, n), reserve)
+		if n > 1 && !counted {
+			out = f.Sanitize.numbered(out, n, reserve)
+		}
+		names := []string{out}
+		for _, c := range companions {
+			names = append(names, f.companionOutName(t.name, out, c))
+		}
+		free := true
+		for i := range names {
+			if taken(srcs[i], names[i]) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return names
+		}
+	}
+}
+
+// companionOutName returns the output name of the companion c of the file name, which
+// is renamed to out: c's part after name, or after name's stem, follows out or its stem.
+func (f *folder) companionOutName(name, out, c string) string {
+	if f.RenameTemplate == "" && f.Sanitize == nil {
+		return c
+	}
+	if rest, ok := strings.CutPrefix(c, name); ok {
+		return out + f.Sanitize.clean(rest)
+	}
+	ext := filepath.Ext(name)
+	rest := strings.TrimPrefix(c, strings.TrimSuffix(name, ext))
+	if n := len(out) - len(ext); n >= 0 && strings.EqualFold(out[n:], ext) {
+		out = out[:n]
+	}
+	return out + f.Sanitize.clean(rest)
+}
+
+// claimedNames are output paths picked for renamed files whose moves are still in
+// progress, so that concurrent workers do not pick the same one.
+var claimedNames = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: map[string]bool{}}
+
+// claimOutNames returns the output names of the file name in the rule's input and its
+// companions, for outFolder. Renamed files get names that are neither in use nor
+// claimed by another worker, and keep them claimed until release is called.
+func (f *folder) claimOutNames(name string, companions []string, outFolder string) (names []string, release func(), err error) {
+	release = func() {}
+	if f.RenameTemplate == "" && f.Sanitize == nil {
+		return append([]string{name}, companions...), release, nil
+	}
+	fi, err := f.statInput(name)
+	if err != nil {
+		return nil, release, err
+	}
+	t, err := f.renameTokensOf(f.Input, fi)
+	if err != nil {
+		return nil, release, err
+	}
+	suffix := f.Compress.ext() + f.Encrypt.ext()
+
+	claimedNames.Lock()
+	defer claimedNames.Unlock()
+	names = f.outNames(t, companions, func(src, out string) bool {
+		dst := joinLoc(outFolder, out+suffix)
+		if claimedNames.paths[dst] {
+			return true
+		}
+		return f.nameTaken(filepath.Join(f.Input, src), dst)
+	})
+	var dsts []string
+	for _, n := range names {
+		dst := joinLoc(outFolder, n+suffix)
+		claimedNames.paths[dst] = true
+		dsts = append(dsts, dst)
+	}
+	return names, func() {
+		claimedNames.Lock()
+		defer claimedNames.Unlock()
+		for _, dst := range dsts {
+			delete(claimedNames.paths, dst)
+		}
+	}, nil
+}
+
+// nameTaken reports whether dst is in use by a file other than the one the rule's copy
+// or link action placed there for src before.
+func (f *folder) nameTaken(src, dst string) bool {
+	if _, err := lstatLoc(dst); err != nil {
+		return false
+	}
+	a := f.action()
+	return a == "move" || !alreadyPlaced(a, src, dst, f.Compress != nil || f.Encrypt != nil)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	tok := renameTokens{name: "scan 7.PDF", date: time.Date(2024, 3, 15, 9, 5, 0, 0, time.Local), hash: "0a1b2c3d", rule: "Office/Scans"}
+	for tmpl, want := range map[string]string{
+		"":                                 "scan 7.PDF",
+		"{date}_{stem}{ext}":               "2024-03-15_scan 7.PDF",
+		"{date:YYYYMMDD-hhmm}_{hash}{ext}": "20240315-0905_0a1b2c3d.PDF",
+		"{rule}-{counter:3}{ext}":          "Office_Scans-012.PDF",
+		"{stem} ({counter})":               "scan 7 (12)",
+	} {
+		f := folder{RenameTemplate: tmpl}
+		if got := f.render(tok, 12); got != want {
+			t.Errorf("%q: %q, want %q", tmpl, got, want)
+		}
+	}
+	for _, tmpl := range []string{"{stem", "stem}", "{size}", "{hash:4}", "{counter:x}"} {
+		if _, err := parseRenameTemplate(tmpl); err == nil {
+			t.Errorf("%q: expected parse error", tmpl)
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	for name, tc := range map[string]struct {
+		opts    sanitizeOptions
+		in, out string
+	}{
+		"windows":        {sanitizeOptions{Windows: true}, `a<b>:c"d|e?f*g.txt. `, "abcdefg.txt"},
+		"reserved":       {sanitizeOptions{Windows: true}, "con.log", "_con.log"},
+		"nfc":            {sanitizeOptions{NFC: true}, "cafe\u0301.txt", "caf\u00e9.txt"},
+		"lowercaseExt":   {sanitizeOptions{LowercaseExt: true}, "IMG_0001.JPG", "IMG_0001.jpg"},
+		"maxLength":      {sanitizeOptions{MaxLength: 16}, "a-very-long-file-name.pdf", "a-very-long-.pdf"},
+		"maxLength rune": {sanitizeOptions{MaxLength: 16}, "aääääääää.pdf", "aäääää.pdf"},
+	} {
+		if got := tc.opts.apply(tc.in, 0); got != tc.out {
+			t.Errorf("%s: %q, want %q", name, got, tc.out)
+		}
+	}
+}
+
+func TestRenameOnMove(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtime := time.Date(2024, 3, 15, 12, 0, 0, 0, time.Local)
+	for _, n := range []string{"IMG_0001.CR2", "IMG_0001.xmp", "IMG_0002.CR2"} {
+		writeAged(t, in, n, n, mtime)
+	}
+	writeAged(t, out, "20240315_001.xmp", "earlier", mtime)
+
+	rule := folder{Name: "Raw", Input: in, Output: []string{out}, Extension: ".CR2", FolderType: "4",
+		Companions: []string{".xmp"}, RenameTemplate: "{date:YYYYMMDD}_{counter:3}{ext}", Sanitize: &sanitizeOptions{LowercaseExt: true}}
+	if err := validateFolders([]folder{rule}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The plan shows the names a run gives the files.
+	var planned []string
+	for _, op := range buildPlan([]folder{rule}).Rules[0].Ops {
+		planned = append(planned, filepath.Base(op.Src)+" "+filepath.Base(op.Dst))
+	}
+	sort.Strings(planned)
+	want := "IMG_0001.CR2 20240315_002.cr2, IMG_0001.xmp 20240315_002.xmp, IMG_0002.CR2 20240315_001.cr2"
+	if got := strings.Join(planned, ", "); got != want {
+		t.Errorf("plan:\n%s\nwant:\n%s", got, want)
+	}
+
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	processFolder(al, &Balancer{}, &rule)
+	if n := al.counters.errorsCount.Load(); n != 0 {
+		t.Fatalf("%d errors", n)
+	}
+	entries, err := os.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{}
+	for _, e := range entries {
+		data, _ := os.ReadFile(filepath.Join(out, e.Name()))
+		contents[e.Name()] = string(data)
+	}
+	// The group shares one number, and skips the one its companion would clash with.
+	for name, data := range map[string]string{
+		"20240315_001.xmp": "earlier",
+		"20240315_001.cr2": "IMG_0002.CR2",
+		"20240315_002.cr2": "IMG_0001.CR2",
+		"20240315_002.xmp": "IMG_0001.xmp",
+	} {
+		if contents[name] != data {
+			t.Errorf("%s = %q, want %q", name, contents[name], data)
+		}
+	}
+}
+
+func TestSanitizedNamesDoNotCollide(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range []string{"Report:1.pdf", "Report1.pdf", "Report\x01.pdf"} {
+		writeAged(t, in, n, n, time.Now())
+	}
+	writeAged(t, out, "Report.pdf", "existing", time.Now())
+
+	rule := folder{Name: "Share", Input: in, Output: []string{out}, Extension: ".pdf", FolderType: "4", Sanitize: &sanitizeOptions{Windows: true}}
+	if err := validateFolders([]folder{rule}); err != nil {
+		t.Fatal(err)
+	}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	processFolder(al, &Balancer{}, &rule)
+	if n := al.counters.errorsCount.Load(); n != 0 {
+		t.Fatalf("%d errors", n)
+	}
+	entries, err := os.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names, contents []string
+	for _, e := range entries {
+		data, _ := os.ReadFile(filepath.Join(out, e.Name()))
+		names = append(names, e.Name())
+		contents = append(contents, string(data))
+	}
+	sort.Strings(contents)
+	if got, want := strings.Join(names, " "), "Report.pdf Report1.pdf Report1_2.pdf Report_2.pdf"; got != want {
+		t.Errorf("output names %q, want %q", got, want)
+	}
+	if got, want := strings.Join(contents, " "), "Report\x01.pdf Report1.pdf Report:1.pdf existing"; got != want {
+		t.Errorf("output contents %q, want %q: a file was replaced", got, want)
+	}
+}
+
+func TestRenameValidation(t *testing.T) {
+	base := folder{Name: "r", Input: "in", Output: []string{"out"}, Extension: ".pdf", FolderType: "4"}
+	for name, mod := range map[string]func(*folder){
+		"unknown token":    func(f *folder) { f.RenameTemplate = "{name}" },
+		"folder":           func(f *folder) { f.RenameTemplate = "{date}/{stem}{ext}" },
+		"not distinct":     func(f *folder) { f.RenameTemplate = "{date}{ext}" },
+		"short maxLength":  func(f *folder) { f.Sanitize = &sanitizeOptions{MaxLength: 8} },
+		"with bundle":      func(f *folder) { f.RenameTemplate = "{hash}{ext}"; f.Bundle = &bundleOptions{Format: "zip"} },
+		"hash of specials": func(f *folder) { f.RenameTemplate = "{hash}{ext}"; f.Scan = &scanOptions{Special: "include"} },
+	} {
+		f := base
+		mod(&f)
+		if validateFolders([]folder{f}) == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runInfo identifies one invocation of sloth for logs and reports.
+type runInfo struct {
+	ID         string
+	Start      time.Time
+	ConfigPath string
+	ConfigHash string // SHA-256 of the rules file as the run loaded it; see loadFolders
+}
+
+func newRunInfo() runInfo {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return runInfo{ID: hex.EncodeToString(b[:]), Start: time.Now(), ConfigPath: configPath}
+}
+
+// runReport is the machine-readable outcome of a run written by --report.
+type runReport struct {
+	RunID           string          `json:"runId"`
+	Start           time.Time       `json:"start"`
+	End             time.Time       `json:"end"`
+	Duration        time.Duration   `json:"durationNs"`
+	ConfigPath      string          `json:"configPath"`
+	ConfigHash      string          `json:"configSha256,omitempty"`
+	DryRun          bool            `json:"dryRun"`
+	Totals          countsSnapshot  `json:"totals"`
+	Warnings        int64           `json:"warnings"`
+	Errors          int64           `json:"errors"`
+	Rules           []ruleSnapshot  `json:"rules"`
+	Failures        []failureRecord `json:"failures"`
+	DroppedFailures int             `json:"droppedFailures,omitempty"`
+}
+
+// Report assembles the run report from the logger's counters.
+func (al *AppLogger) Report(run runInfo, end time.Time) *runReport {
+	rules, total := al.counters.stats.snapshot()
+	failures, dropped := al.counters.stats.failureList()
+	if failures == nil {
+		failures = []failureRecord{}
+	}
+	return &runReport{
+		RunID:           run.ID,
+		Start:           run.Start,
+		End:             end,
+		Duration:        end.Sub(run.Start),
+		ConfigPath:      run.ConfigPath,
+		ConfigHash:      run.ConfigHash,
+		DryRun:          al.dryRun,
+		Totals:          total,
+		Warnings:        al.counters.warningsCount.Load(),
+		Errors:          al.counters.errorsCount.Load(),
+		Rules:           rules,
+		Failures:        failures,
+		DroppedFailures: dropped,
+	}
+}
+
+// hashFile returns the hex SHA-256 of the file at path, or "" if it cannot be read.
+func hashFile(path string) string {
+	sum, _ := sha256File(path)
+	return sum
+}
+
+// writeReport writes rep to path as JSON or, for format "junit" (or a .xml path when
+// format is empty), as JUnit XML. The file is written atomically via a temp file.
+func writeReport(path, format string, rep *runReport) error {
+	if format == "" {
+		format = "json"
+		if strings.EqualFold(filepath.Ext(path), ".xml") {
+			format = "junit"
+		}
+	}
+
+	var data []byte
+	var err error
+	switch strings.ToLower(format) {
+	case "json":
+		data, err = json.MarshalIndent(rep, "", "  ")
+	case "junit":
+		data, err = xml.MarshalIndent(rep.junit(), "", "  ")
+		data = append([]byte(xml.Header), data...)
+	default:
+		return fmt.Errorf("%w: report format %q: must be json or junit", errInvalidConfig, format)
+	}
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// JUnit XML: one test suite per run, one test case per rule. A rule with failed files is a
+// failing test case so CI dashboards (e.g. dry-run checks) show it.
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	ID        string      `xml:"id,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func (rep *runReport) junit() junitSuites {
+	suite := junitSuite{
+		Name:      "sloth",
+		Tests:     len(rep.Rules),
+		Time:      seconds(rep.Duration),
+		Timestamp: rep.Start.Format(time.RFC3339),
+		ID:        rep.RunID,
+	}
+	for i := range rep.Rules {
+		r := &rep.Rules[i]
+		tc := junitCase{
+			Name:      r.Name,
+			ClassName: "sloth.rules",
+			Time:      seconds(r.Duration),
+			SystemOut: fmt.Sprintf("moved=%d deleted=%d skipped=%d failed=%d bytesMoved=%d bytesDeleted=%d dryRun=%v",
+				r.Moved, r.Deleted, r.Skipped, r.Failed, r.BytesMoved, r.BytesDeleted, rep.DryRun),
+		}
+		var lines []string
+		for _, f := range rep.Failures {
+			if f.Rule == r.Name {
+				lines = append(lines, fmt.Sprintf("%s: %s src=%s dst=%s error=%s", f.Kind, f.Message, f.Src, f.Dst, f.Error))
+			}
+		}
+		if r.Failed > 0 || len(lines) > 0 {
+			suite.Failures++
+			tc.Failure = &junitFailure{
+				Message: fmt.Sprintf("%d file(s) failed", r.Failed),
+				Text:    strings.Join(lines, "\n"),
+			}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	return junitSuites{Suites: []junitSuite{suite}}
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// lastReportPath is where every run saves its report for `sloth stats`.
+func lastReportPath(stateDir string) string { return filepath.Join(stateDir, "last-run.json") }
+
+func saveLastReport(stateDir string, rep *runReport) error {
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return err
+	}
+	return writeReport(lastReportPath(stateDir), "json", rep)
+}
+
+func loadLastReport(stateDir string) (*runReport, error) {
+	data, err := os.ReadFile(lastReportPath(stateDir))
+	if err != nil {
+		return nil, err
+	}
+	var rep runReport
+	if err := json.Unmarshal(data, &rep); err != nil {
+		return nil, err
+	}
+	return &rep, nil
+}
+
+// printReport renders rep as a table for `sloth stats`.
+func printReport(w io.Writer, rep *runReport) {
+	fmt.Fprintf(w, "Run %s started %s, took %s (dry-run: %v)\n", rep.RunID, rep.Start.Format(time.RFC3339), rep.Duration.Round(time.Millisecond), rep.DryRun)
+	fmt.Fprintf(w, "Config %s, %d warning(s), %d error(s)\n\n", rep.ConfigPath, rep.Warnings, rep.Errors)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "RULE\tMOVED\tDELETED\tSKIPPED\tFAILED\tBYTES MOVED\tBYTES DELETED\tDURATION\t")
+	row := func(name string, c countsSnapshot, d time.Duration) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t\n", name, c.Moved, c.Deleted, c.Skipped, c.Failed, c.BytesMoved, c.BytesDeleted, d.Round(time.Millisecond))
+	}
+	for i := range rep.Rules {
+		row(rep.Rules[i].Name, rep.Rules[i].countsSnapshot, rep.Rules[i].Duration)
+	}
+	row("TOTAL", rep.Totals, rep.Duration)
+	tw.Flush()
+}
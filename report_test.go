@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteReport(t *testing.T) {
+	base := t.TempDir()
+	cfgFile := filepath.Join(base, "config.json")
+	if err := os.WriteFile(cfgFile, []byte("[]"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := newTestLogger(t, &bytes.Buffer{}, logConfig{})
+	ruleLog := logger.WithRule("Archive")
+	ruleLog.StartRule()
+	ruleLog.CountMoved("/out", 10)
+	ruleLog.CountFailed("/out")
+	_, notFound := os.Stat(filepath.Join(base, "gone.pdf"))
+	ruleLog.ErrorAttrs("rename failed", srcAttr("/in/gone.pdf"), dstAttr("/out/gone.pdf"), errAttr(notFound))
+	ruleLog.FinishRule()
+
+	_, sum, err := loadFolders(cfgFile, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := hashFile(cfgFile)
+	// The report names the config the run loaded, not the file as it is at the end.
+	if err := os.WriteFile(cfgFile, []byte("[ ]"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	run := runInfo{ID: "abc123", Start: time.Now().Add(-time.Second), ConfigPath: cfgFile, ConfigHash: sum}
+	rep := logger.Report(run, time.Now())
+
+	jsonPath := filepath.Join(base, "report.json")
+	if err := writeReport(jsonPath, "", rep); err != nil {
+		t.Fatalf("writeReport json: %v", err)
+	}
+	var got runReport
+	data, _ := os.ReadFile(jsonPath)
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("report is not JSON: %v", err)
+	}
+	if got.RunID != "abc123" || got.ConfigHash != loaded || len(got.ConfigHash) != 64 {
+		t.Errorf("unexpected header: id=%q hash=%q", got.RunID, got.ConfigHash)
+	}
+	if len(got.Rules) != 1 || got.Rules[0].Moved != 1 || got.Rules[0].Failed != 1 {
+		t.Errorf("unexpected rules: %+v", got.Rules)
+	}
+	if len(got.Failures) != 1 || got.Failures[0].Src != "/in/gone.pdf" || got.Failures[0].Kind != "not_found" {
+		t.Errorf("unexpected failures: %+v", got.Failures)
+	}
+
+	xmlPath := filepath.Join(base, "report.xml")
+	if err := writeReport(xmlPath, "", rep); err != nil {
+		t.Fatalf("writeReport junit: %v", err)
+	}
+	var suites junitSuites
+	data, _ = os.ReadFile(xmlPath)
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		t.Fatalf("report is not XML: %v", err)
+	}
+	if len(suites.Suites) != 1 || suites.Suites[0].Failures != 1 {
+		t.Fatalf("unexpected suites: %+v", suites)
+	}
+	tc := suites.Suites[0].Cases[0]
+	if tc.Name != "Archive" || tc.Failure == nil || !strings.Contains(tc.Failure.Text, "/in/gone.pdf") {
+		t.Errorf("unexpected test case: %+v", tc)
+	}
+
+	if err := writeReport(filepath.Join(base, "r"), "yaml", rep); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
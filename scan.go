@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// scanOptions decide what a rule does with symbolic links and special files (FIFOs,
+// sockets and devices) it finds in its input, or in a folder retention walks.
+type scanOptions struct {
+	// Symlinks is "link" (the default) to act on links themselves, "skip" to ignore
+	// them, or "follow" to treat each as the file or folder it points to.
+	Symlinks string `json:"symlinks,omitempty"`
+	// Special is "skip" (the default) or "include" to move and delete special files
+	// like other files. They are only ever renamed, never copied.
+	Special string `json:"special,omitempty"`
+	// OneFilesystem keeps retention walks and followed links on the filesystem of the
+	// folder being scanned, like find -xdev.
+	OneFilesystem bool `json:"oneFilesystem,omitempty"`
+}
+
+func (s *scanOptions) symlinks() string {
+	if s == nil || s.Symlinks == "" {
+		return "link"
+	}
+	return s.Symlinks
+}
+
+func (s *scanOptions) special() string {
+	if s == nil || s.Special == "" {
+		return "skip"
+	}
+	return s.Special
+}
+
+func (s *scanOptions) oneFilesystem() bool { return s != nil && s.OneFilesystem }
+
+func (s *scanOptions) String() string {
+	str := "symlinks " + s.symlinks() + ", special files " + s.special()
+	if s.oneFilesystem() {
+		str += ", one filesystem"
+	}
+	return str
+}
+
+func (s *scanOptions) validate() error {
+	switch s.symlinks() {
+	case "link", "skip", "follow":
+	default:
+		return fmt.Errorf("scan: unknown symlinks policy %q: must be link, skip or follow", s.Symlinks)
+	}
+	switch s.special() {
+	case "skip", "include":
+	default:
+		return fmt.Errorf("scan: unknown special policy %q: must be skip or include", s.Special)
+	}
+	return nil
+}
+
+// isSpecial reports whether mode is a FIFO, socket, device or other non-regular file
+// that is not a folder or symbolic link.
+func isSpecial(mode fs.FileMode) bool {
+	return !mode.IsRegular() && !mode.IsDir() && mode&fs.ModeSymlink == 0
+}
+
+// isSymlink reports whether path is a symbolic link.
+func isSymlink(path string) bool {
+	fi, err := os.Lstat(path)
+	return err == nil && fi.Mode()&fs.ModeSymlink != 0
+}
+
+// sameDevice returns a test for whether a file is on the filesystem of root. It accepts
+// everything unless the options keep scans on one filesystem and the platform reports
+// device numbers.
+func (s *scanOptions) sameDevice(root fs.FileInfo) func(fi fs.FileInfo) bool {
+	dev, ok := deviceOf(root)
+	if !s.oneFilesystem() || !ok {
+		return func(fs.FileInfo) bool { return true }
+	}
+	return func(fi fs.FileInfo) bool {
+		d, ok := deviceOf(fi)
+		return !ok || d == dev
+	}
+}
+
+// skippedEntry is an input entry that a rule's scan options leave alone.
+type skippedEntry struct {
+	path   string
+	reason string
+}
+
+// scanInput lists the files directly in input that match extension and that the rule
+// acts on. A followed link is returned with its target's info, so the output layout
+// uses the target's time. Matching entries left alone are returned as skipped.
+func scanInput(input, extension string, s *scanOptions) ([]fs.FileInfo, []skippedEntry, error) {
+	entries, err := os.ReadDir(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	onDevice := func(fs.FileInfo) bool { return true }
+	if ri, err := os.Stat(input); err == nil {
+		onDevice = s.sameDevice(ri)
+	}
+
+	var files []fs.FileInfo
+	var skipped []skippedEntry
+	for _, e := range entries {
+		if e.IsDir() || (extension != "" && filepath.Ext(e.Name()) != extension) {
+			continue
+		}
+		path := filepath.Join(input, e.Name())
+		fi, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // moved away since the folder was read
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		fi, reason := s.entry(path, fi, onDevice)
+		if reason != "" {
+			skipped = append(skipped, skippedEntry{path, reason})
+		}
+		if fi != nil {
+			files = append(files, fi)
+		}
+	}
+	return files, skipped, nil
+}
+
+// entry applies the scan options to the input entry at path with its Lstat info fi. It
+// returns the info to act on, or nil and why the entry is left alone; the reason is ""
+// for entries that are ignored like folders.
+func (s *scanOptions) entry(path string, fi fs.FileInfo, onDevice func(fs.FileInfo) bool) (fs.FileInfo, string) {
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		switch s.symlinks() {
+		case "skip":
+			return nil, "symbolic link"
+		case "follow":
+			target, err := os.Stat(path)
+			if err != nil {
+				return nil, "broken symbolic link"
+			}
+			if target.IsDir() {
+				return nil, "" // like folders, linked folders are not scanned
+			}
+			if !onDevice(target) {
+				return nil, "links to another filesystem"
+			}
+			fi = followedInfo{target, fi.Name()}
+		}
+	}
+	if fi.IsDir() {
+		return nil, ""
+	}
+	if isSpecial(fi.Mode()) && s.special() == "skip" {
+		return nil, "special file (" + fi.Mode().Type().String() + ")"
+	}
+	return fi, ""
+}
+
+// followedInfo is a link target's info under the link's name.
+type followedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (fi followedInfo) Name() string { return fi.name }
+
+// statInput returns the info of the file name in the rule's input: a link's own, or its
+// target's under the link's name when the rule follows links.
+func (f *folder) statInput(name string) (fs.FileInfo, error) {
+	in := filepath.Join(f.Input, name)
+	if f.Scan.symlinks() != "follow" {
+		return os.Lstat(in)
+	}
+	fi, err := os.Stat(in)
+	if err != nil {
+		return nil, err
+	}
+	return followedInfo{fi, name}, nil
+}
+
+// walkLocal calls fn for every file under root, recursively, according to the scan
+// options. Links are never followed unless the options say so; then a link to a file is
+// passed with its target's info (removing the path removes only the link). Linked
+// folders are never walked: retention deletes what the walk finds, and must not delete
+// files in other trees. Problems that only skip part of the tree are passed to warn,
+// which may be nil.
+func walkLocal(root string, s *scanOptions, fn func(path string, fi fs.FileInfo) error, warn func(path string, err error)) error {
+	ri, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if warn == nil {
+		warn = func(string, error) {}
+	}
+	w := &walker{opts: s, fn: fn, warn: warn, onDevice: s.sameDevice(ri)}
+	err = w.dir(root, []fs.FileInfo{ri})
+	if errors.Is(err, filepath.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+type walker struct {
+	opts     *scanOptions
+	fn       func(path string, fi fs.FileInfo) error
+	warn     func(path string, err error)
+	onDevice func(fi fs.FileInfo) bool
+}
+
+// dir walks path, whose info and that of its parents up to the root are in ancestors.
+func (w *walker) dir(path string, ancestors []fs.FileInfo) error {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		p := filepath.Join(path, e.Name())
+		fi, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // removed since the directory was read, e.g. a bundle index
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			switch w.opts.symlinks() {
+			case "skip":
+				continue
+			case "link":
+				if err := w.fn(p, fi); err != nil {
+					return err
+				}
+				continue
+			}
+			target, err := os.Stat(p)
+			if err != nil {
+				w.warn(p, fmt.Errorf("broken symbolic link: %w", err))
+				continue
+			}
+			if target.IsDir() {
+				continue // like in the input, linked folders are not scanned
+			}
+			fi = followedInfo{target, fi.Name()}
+		}
+		if !w.onDevice(fi) {
+			continue
+		}
+		if fi.IsDir() {
+			if loopsBack(ancestors, fi) {
+				w.warn(p, errors.New("folder loop: leads back to a parent folder"))
+				continue
+			}
+			if err := w.dir(p, append(ancestors[:len(ancestors):len(ancestors)], fi)); err != nil {
+				return err
+			}
+			continue
+		}
+		if isSpecial(fi.Mode()) && w.opts.special() == "skip" {
+			continue
+		}
+		if err := w.fn(p, fi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loopsBack(ancestors []fs.FileInfo, fi fs.FileInfo) bool {
+	for _, a := range ancestors {
+		if os.SameFile(a, fi) {
+			return true
+		}
+	}
+	return false
+}
+
+// listLoc lists the files under root like storage List, applying the scan options to
+// local folders.
+func listLoc(root string, s *scanOptions, fn func(path string, fi fs.FileInfo) error, warn func(path string, err error)) error {
+	if !isRemote(root) {
+		return walkLocal(root, s, fn, warn)
+	}
+	st, err := storageFor(root)
+	if err != nil {
+		return err
+	}
+	return st.List(root, fn)
+}
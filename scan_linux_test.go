@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSpecialFiles(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fifo := filepath.Join(in, "pipe.log")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Skip("mkfifo:", err)
+	}
+	old := time.Now().AddDate(0, 0, -30)
+	if err := os.Chtimes(fifo, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// By default FIFOs are neither moved nor deleted.
+	rule := folder{Name: "Pipes", Input: in, Output: []string{out}, Extension: ".log", FolderType: "4"}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	processFolder(al, &Balancer{}, &rule)
+	deleteFiles(in, ".log", false, 7, nil, al, false)
+	if _, err := os.Lstat(fifo); err != nil {
+		t.Fatalf("FIFO was touched: %v", err)
+	}
+	if n := al.counters.stats.rule("Pipes").skipped.Load(); n != 1 {
+		t.Errorf("skipped = %d, want 1", n)
+	}
+
+	rule.Scan = &scanOptions{Special: "include"}
+	if err := validateFolders([]folder{rule}); err != nil {
+		t.Fatal(err)
+	}
+	processFolder(al, &Balancer{}, &rule)
+	if n := al.counters.errorsCount.Load(); n != 0 {
+		t.Fatalf("%d errors", n)
+	}
+	moved := filepath.Join(out, "pipe.log")
+	if fi, err := os.Lstat(moved); err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Fatalf("FIFO not renamed into the output: %v", err)
+	}
+	deleteFiles(out, ".log", false, 7, rule.Scan, al, false)
+	if _, err := os.Lstat(moved); !os.IsNotExist(err) {
+		t.Errorf("included FIFO not deleted by retention: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestScanSymlinkPolicies(t *testing.T) {
+	for _, policy := range []string{"link", "skip", "follow"} {
+		t.Run(policy, func(t *testing.T) {
+			dir := t.TempDir()
+			in := filepath.Join(dir, "in")
+			out := filepath.Join(dir, "out")
+			elsewhere := filepath.Join(dir, "elsewhere")
+			for _, d := range []string{in, out, elsewhere} {
+				if err := os.MkdirAll(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			target := writeAged(t, elsewhere, "report.log", "data", time.Date(2022, 1, 10, 0, 0, 0, 0, time.Local))
+			link := filepath.Join(in, "report.log")
+			if err := os.Symlink(target, link); err != nil {
+				t.Fatal(err)
+			}
+
+			rule := folder{Name: "Logs", Input: in, Output: []string{out}, Extension: ".log", FolderType: "5", Scan: &scanOptions{Symlinks: policy}}
+			if err := validateFolders([]folder{rule}); err != nil {
+				t.Fatal(err)
+			}
+			al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+			processFolder(al, &Balancer{}, &rule)
+			if n := al.counters.errorsCount.Load(); n != 0 {
+				t.Fatalf("%d errors", n)
+			}
+			if data, err := os.ReadFile(target); err != nil || string(data) != "data" {
+				t.Fatalf("link target changed: %q, %v", data, err)
+			}
+
+			switch policy {
+			case "link":
+				moved := filepath.Join(out, time.Now().Format("200601"), "report.log")
+				if !isSymlink(moved) {
+					t.Errorf("link was not moved as a link to %s", moved)
+				}
+			case "skip":
+				if !isSymlink(link) {
+					t.Error("skipped link was moved")
+				}
+				if n := al.counters.stats.rule("Logs").skipped.Load(); n != 1 {
+					t.Errorf("skipped = %d, want 1", n)
+				}
+			case "follow":
+				moved := filepath.Join(out, "202201", "report.log")
+				fi, err := os.Lstat(moved)
+				if err != nil || !fi.Mode().IsRegular() {
+					t.Fatalf("followed link not copied to %s: %v", moved, err)
+				}
+				if _, err := os.Lstat(link); !os.IsNotExist(err) {
+					t.Errorf("followed link left in the input: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestWalkLocal(t *testing.T) {
+	root := t.TempDir()
+	other := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeAged(t, filepath.Join(root, "a"), "f.log", "f", time.Now())
+	g := writeAged(t, other, "g.log", "g", time.Now())
+	for link, to := range map[string]string{"a/up": root, "ext": other, "g.log": g} {
+		if err := os.Symlink(to, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for policy, want := range map[string][]string{
+		"skip":   {"a/f.log"},
+		"link":   {"a/f.log", "a/up", "ext", "g.log"},
+		"follow": {"a/f.log", "g.log"}, // linked folders are not walked
+	} {
+		var got []string
+		var warned []string
+		err := walkLocal(root, &scanOptions{Symlinks: policy}, func(path string, fi fs.FileInfo) error {
+			rel, _ := filepath.Rel(root, path)
+			got = append(got, filepath.ToSlash(rel))
+			return nil
+		}, func(path string, err error) {
+			warned = append(warned, filepath.Base(path))
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+		sort.Strings(got)
+		if len(got) != len(want) {
+			t.Errorf("%s: listed %q, want %q", policy, got, want)
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: listed %q, want %q", policy, got, want)
+				break
+			}
+		}
+		if len(warned) != 0 {
+			t.Errorf("%s: unexpected warnings for %q", policy, warned)
+		}
+	}
+}
+
+// TestRetentionFollowStaysInTree checks that retention with symlinks "follow" unlinks
+// expired links but never deletes files in trees that a linked folder leads to.
+func TestRetentionFollowStaysInTree(t *testing.T) {
+	root := t.TempDir()
+	other := t.TempDir()
+	old := time.Now().AddDate(0, 0, -60)
+	inOther := writeAged(t, other, "keep.log", "k", old)
+	linked := writeAged(t, other, "linked.log", "l", old)
+	if err := os.Symlink(other, filepath.Join(root, "ext")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(linked, filepath.Join(root, "linked.log")); err != nil {
+		t.Fatal(err)
+	}
+	expired := writeAged(t, root, "expired.log", "e", old)
+
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	deleteFiles(root, ".log", false, 30, &scanOptions{Symlinks: "follow"}, al, false)
+	for _, p := range []string{inOther, linked} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("file in another tree deleted: %v", err)
+		}
+	}
+	for _, p := range []string{expired, filepath.Join(root, "linked.log")} {
+		if _, err := os.Lstat(p); !os.IsNotExist(err) {
+			t.Errorf("%s not removed by retention: %v", p, err)
+		}
+	}
+}
+
+func TestScanValidation(t *testing.T) {
+	base := folder{Name: "r", Input: "in", Output: []string{"out"}, FolderType: "4"}
+	for name, mod := range map[string]func(*folder){
+		"unknown symlinks policy": func(f *folder) { f.Scan = &scanOptions{Symlinks: "resolve"} },
+		"unknown special policy":  func(f *folder) { f.Scan = &scanOptions{Special: "move"} },
+		"special with compress": func(f *folder) {
+			f.Scan = &scanOptions{Special: "include"}
+			f.Compress = &compressOptions{Format: "gzip"}
+		},
+		"special with verify": func(f *folder) { f.Scan = &scanOptions{Special: "include"}; f.Verify = true },
+	} {
+		f := base
+		mod(&f)
+		if validateFolders([]folder{f}) == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
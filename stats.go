@@ -0,0 +1,190 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fileCounters tracks per-file outcomes. Each field is updated atomically by the workers.
+type fileCounters struct {
+	moved        atomic.Int64
+	deleted      atomic.Int64
+	skipped      atomic.Int64
+	failed       atomic.Int64
+	bytesMoved   atomic.Int64
+	bytesDeleted atomic.Int64
+}
+
+// countsSnapshot is a point-in-time copy of fileCounters.
+type countsSnapshot struct {
+	Moved        int64 `json:"moved"`
+	Deleted      int64 `json:"deleted"`
+	Skipped      int64 `json:"skipped"`
+	Failed       int64 `json:"failed"`
+	BytesMoved   int64 `json:"bytesMoved"`
+	BytesDeleted int64 `json:"bytesDeleted"`
+}
+
+func (c *fileCounters) snapshot() countsSnapshot {
+	return countsSnapshot{
+		Moved:        c.moved.Load(),
+		Deleted:      c.deleted.Load(),
+		Skipped:      c.skipped.Load(),
+		Failed:       c.failed.Load(),
+		BytesMoved:   c.bytesMoved.Load(),
+		BytesDeleted: c.bytesDeleted.Load(),
+	}
+}
+
+func (s *countsSnapshot) add(o countsSnapshot) {
+	s.Moved += o.Moved
+	s.Deleted += o.Deleted
+	s.Skipped += o.Skipped
+	s.Failed += o.Failed
+	s.BytesMoved += o.BytesMoved
+	s.BytesDeleted += o.BytesDeleted
+}
+
+// ruleStats accumulates outcomes for one rule, overall and per output target.
+type ruleStats struct {
+	fileCounters
+
+	mu       sync.Mutex
+	targets  map[string]*fileCounters
+	started  time.Time     // start of the pass in progress
+	duration time.Duration // wall time of all completed passes
+	passes   int
+	passHist durationHistogram
+}
+
+// durationBuckets are the upper bounds (seconds) of the rule pass duration histogram.
+var durationBuckets = [...]float64{0.1, 0.5, 1, 5, 15, 60, 300, 900, 3600}
+
+// durationHistogram is a cumulative histogram over durationBuckets. Guarded by ruleStats.mu.
+type durationHistogram struct {
+	counts [len(durationBuckets)]uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *durationHistogram) observe(d time.Duration) {
+	sec := d.Seconds()
+	for i, le := range durationBuckets {
+		if sec <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += sec
+	h.count++
+}
+
+func (rs *ruleStats) target(path string) *fileCounters {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	c, ok := rs.targets[path]
+	if !ok {
+		c = &fileCounters{}
+		rs.targets[path] = c
+	}
+	return c
+}
+
+// maxFailureRecords bounds memory in long-running mode; later failures are only counted.
+const maxFailureRecords = 1000
+
+// failureRecord describes one error event for the run report.
+type failureRecord struct {
+	Time    time.Time `json:"time"`
+	Rule    string    `json:"rule,omitempty"`
+	Message string    `json:"message"`
+	Src     string    `json:"src,omitempty"`
+	Dst     string    `json:"dst,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Kind    string    `json:"kind"`
+}
+
+// runStats holds the statistics for every rule executed in this process.
+type runStats struct {
+	mu    sync.Mutex
+	rules map[string]*ruleStats
+	order []string // rule names in first-run order
+
+	failures        []failureRecord
+	droppedFailures int
+}
+
+func (s *runStats) addFailure(f failureRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.failures) >= maxFailureRecords {
+		s.droppedFailures++
+		return
+	}
+	s.failures = append(s.failures, f)
+}
+
+// failureList returns the recorded failures and how many were dropped over the limit.
+func (s *runStats) failureList() ([]failureRecord, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]failureRecord(nil), s.failures...), s.droppedFailures
+}
+
+func newRunStats() *runStats {
+	return &runStats{rules: make(map[string]*ruleStats)}
+}
+
+// rule returns the stats for name, creating them on first use.
+func (s *runStats) rule(name string) *ruleStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rs, ok := s.rules[name]
+	if !ok {
+		rs = &ruleStats{targets: make(map[string]*fileCounters)}
+		s.rules[name] = rs
+		s.order = append(s.order, name)
+	}
+	return rs
+}
+
+// targetSnapshot is the outcome for one output (or delete) root of a rule.
+type targetSnapshot struct {
+	Path string `json:"path"`
+	countsSnapshot
+}
+
+// ruleSnapshot is the outcome of one rule.
+type ruleSnapshot struct {
+	Name     string        `json:"name"`
+	Passes   int           `json:"passes"`
+	Duration time.Duration `json:"durationNs"`
+	countsSnapshot
+	Targets []targetSnapshot `json:"targets"`
+
+	hist durationHistogram // pass durations, for metrics
+}
+
+// snapshot returns per-rule stats in execution order plus the totals across rules.
+func (s *runStats) snapshot() ([]ruleSnapshot, countsSnapshot) {
+	s.mu.Lock()
+	names := append([]string(nil), s.order...)
+	s.mu.Unlock()
+
+	var total countsSnapshot
+	rules := make([]ruleSnapshot, 0, len(names))
+	for _, name := range names {
+		rs := s.rule(name)
+		rs.mu.Lock()
+		snap := ruleSnapshot{Name: name, Passes: rs.passes, Duration: rs.duration, countsSnapshot: rs.snapshot(), hist: rs.passHist}
+		for path, c := range rs.targets {
+			snap.Targets = append(snap.Targets, targetSnapshot{Path: path, countsSnapshot: c.snapshot()})
+		}
+		rs.mu.Unlock()
+		sort.Slice(snap.Targets, func(i, j int) bool { return snap.Targets[i].Path < snap.Targets[j].Path })
+		total.add(snap.countsSnapshot)
+		rules = append(rules, snap)
+	}
+	return rules, total
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunStats verifies per-rule and per-target counters for a real move + retention pass.
+func TestRunStats(t *testing.T) {
+	base := t.TempDir()
+	inputDir := filepath.Join(base, "input")
+	out1 := filepath.Join(base, "out1")
+	out2 := filepath.Join(base, "out2")
+	for _, d := range []string{inputDir, out1, out2} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte("12345"), 0600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	// An old archived file that retention should delete from out1.
+	old := filepath.Join(out1, "old.txt")
+	if err := os.WriteFile(old, []byte("123"), 0600); err != nil {
+		t.Fatalf("write old: %v", err)
+	}
+	past := time.Now().Add(-72 * time.Hour)
+	if err := os.Chtimes(old, past, past); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+
+	var buf bytes.Buffer
+	logger := newTestLogger(t, &buf, logConfig{})
+	rule := folder{Name: "Stats", Input: inputDir, Output: []string{out1, out2}, Extension: ".txt", FolderType: "4", DeleteOlderThan: 2}
+	processFolder(logger, &Balancer{}, &rule)
+
+	rules, total := logger.counters.stats.snapshot()
+	if len(rules) != 1 || rules[0].Name != "Stats" || rules[0].Passes != 1 {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+	if total.Moved != 3 || total.BytesMoved != 15 || total.Deleted != 1 || total.BytesDeleted != 3 || total.Failed != 0 {
+		t.Errorf("unexpected totals: %+v", total)
+	}
+	if rules[0].Duration <= 0 {
+		t.Errorf("rule duration not recorded")
+	}
+
+	byPath := map[string]countsSnapshot{}
+	for _, tgt := range rules[0].Targets {
+		byPath[tgt.Path] = tgt.countsSnapshot
+	}
+	if byPath[out1].Moved+byPath[out2].Moved != 3 || byPath[out1].Moved == 0 || byPath[out2].Moved == 0 {
+		t.Errorf("moves not split across targets: %+v", byPath)
+	}
+	if byPath[out1].Deleted != 1 {
+		t.Errorf("delete not attributed to out1: %+v", byPath[out1])
+	}
+
+	logger.Summary(time.Second)
+	if got := buf.String(); !strings.Contains(got, "msg=SUMMARY moved=3 deleted=1") || !strings.Contains(got, "rules=1 files=4") {
+		t.Errorf("summary line missing counts:\n%s", got)
+	}
+}
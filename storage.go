@@ -0,0 +1,399 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storage is where rule outputs (and remote inputs) live. Locations are the strings used in config: a local
+// path, or a URL such as "s3://bucket/prefix/2024/file.log" for remote backends. Every
+// method takes and returns full locations of its own backend.
+type storage interface {
+	// Stat describes the file or directory at loc. Missing locations wrap fs.ErrNotExist.
+	Stat(loc string) (fs.FileInfo, error)
+	// Mkdir creates loc and any missing parents. Backends without directories do nothing.
+	Mkdir(loc string) error
+	// Put stores everything read from r at loc, replacing it atomically where the backend
+	// allows, and returns the number of bytes stored. A non-zero modTime becomes the
+	// file's modification time on backends that can keep one; see keepsModTime.
+	Put(loc string, r io.Reader, modTime time.Time) (int64, error)
+	// Open reads the file at loc from byte offset on.
+	Open(loc string, offset int64) (io.ReadCloser, error)
+	Rename(from, to string) error
+	// List calls fn for every file under root, recursively. fn may return
+	// filepath.SkipAll to stop early.
+	List(root string, fn func(loc string, fi fs.FileInfo) error) error
+	Delete(loc string) error
+}
+
+// storageSchemes maps URL schemes to the backend that handles them.
+var storageSchemes = map[string]func(scheme, host string) (storage, error){
+	"s3":      newS3Storage,
+	"sftp":    newSFTPStorage,
+	"webdav":  newWebDAVStorage,
+	"webdavs": newWebDAVStorage,
+}
+
+// isRemote reports whether loc is a URL rather than a local path.
+func isRemote(loc string) bool {
+	return strings.Contains(loc, "://")
+}
+
+// splitLoc splits a URL location into scheme, host (with user info or bucket) and an
+// absolute slash path.
+func splitLoc(loc string) (scheme, host, p string) {
+	scheme, rest, _ := strings.Cut(loc, "://")
+	host, p, _ = strings.Cut(rest, "/")
+	return scheme, host, path.Clean("/" + p)
+}
+
+// joinLoc joins path elements onto a location, like filepath.Join for local paths.
+func joinLoc(loc string, elem ...string) string {
+	if !isRemote(loc) {
+		return filepath.Join(append([]string{loc}, elem...)...)
+	}
+	scheme, host, p := splitLoc(loc)
+	return scheme + "://" + host + path.Join(append([]string{p}, elem...)...)
+}
+
+// cleanLoc is filepath.Clean for local paths; for URLs it cleans only the path, keeping
+// the "scheme://host" intact.
+func cleanLoc(loc string) string {
+	if !isRemote(loc) {
+		return filepath.Clean(loc)
+	}
+	scheme, host, p := splitLoc(loc)
+	return scheme + "://" + host + p
+}
+
+// dirLoc returns all but the last element of a location, like filepath.Dir.
+func dirLoc(loc string) string {
+	if !isRemote(loc) {
+		return filepath.Dir(loc)
+	}
+	scheme, host, p := splitLoc(loc)
+	return scheme + "://" + host + path.Dir(p)
+}
+
+// locSeparator is the path separator used by loc.
+func locSeparator(loc string) rune {
+	if isRemote(loc) {
+		return '/'
+	}
+	return filepath.Separator
+}
+
+var (
+	storagesMu sync.Mutex
+	storages   = map[string]storage{}
+)
+
+// storageFor returns the backend for loc. Remote backends are connected on first use and
+// shared by every rule with the same scheme and host.
+func storageFor(loc string) (storage, error) {
+	if !isRemote(loc) {
+		return localStorage{}, nil
+	}
+	scheme, host, _ := splitLoc(loc)
+	open, ok := storageSchemes[scheme]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported storage scheme %q in %s", errInvalidConfig, scheme, loc)
+	}
+	if err := checkNoPassword(scheme, host); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidConfig, err)
+	}
+	key := scheme + "://" + host
+	storagesMu.Lock()
+	defer storagesMu.Unlock()
+	if st, ok := storages[key]; ok {
+		if c, ok := st.(remoteStorage).st.(interface{ alive() bool }); !ok || c.alive() {
+			return st, nil
+		}
+	}
+	st, err := open(scheme, host)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, storageErr(err))
+	}
+	st = remoteStorage{st}
+	storages[key] = st
+	return st, nil
+}
+
+// keepsModTime reports whether files put at loc keep the modification time of their
+// source, which retention ages them by. WebDAV servers set their own.
+func keepsModTime(loc string) bool {
+	scheme, _, _ := splitLoc(loc)
+	return !isRemote(loc) || (scheme != "webdav" && scheme != "webdavs")
+}
+
+// validateLocation checks that loc is a local path or a URL with a known scheme,
+// without connecting.
+func validateLocation(loc string) error {
+	if !isRemote(loc) {
+		return nil
+	}
+	scheme, host, _ := splitLoc(loc)
+	if _, ok := storageSchemes[scheme]; !ok {
+		return fmt.Errorf("unsupported storage scheme %q in %s", scheme, loc)
+	}
+	if host == "" {
+		return fmt.Errorf("%s: missing host or bucket", loc)
+	}
+	return checkNoPassword(scheme, host)
+}
+
+// checkNoPassword rejects a password in a location's user info. Locations show up in
+// logs, the journal, plans, "sloth list" and metric labels, so passwords come from the
+// environment instead. The error leaves the password out.
+func checkNoPassword(scheme, host string) error {
+	i := strings.LastIndex(host, "@")
+	if i < 0 || !strings.Contains(host[:i], ":") {
+		return nil
+	}
+	user, _, _ := strings.Cut(host[:i], ":")
+	return fmt.Errorf("%s://%s@%s: passwords are not allowed in URLs; set %s instead", scheme, user, host[i+1:], passwordEnv(scheme))
+}
+
+// passwordEnv names the environment variable with the password for a scheme.
+func passwordEnv(scheme string) string {
+	if scheme == "webdavs" {
+		scheme = "webdav"
+	}
+	return "SLOTH_" + strings.ToUpper(scheme) + "_PASSWORD"
+}
+
+// statLoc stats a location on whichever backend holds it.
+func statLoc(loc string) (fs.FileInfo, error) {
+	st, err := storageFor(loc)
+	if err != nil {
+		return nil, err
+	}
+	return st.Stat(loc)
+}
+
+// lstatLoc is os.Lstat for local paths and the backend's Stat for URLs.
+func lstatLoc(loc string) (fs.FileInfo, error) {
+	if !isRemote(loc) {
+		return os.Lstat(loc)
+	}
+	return statLoc(loc)
+}
+
+// uploadMove moves the local file src to the remote location dst: it is uploaded
+// through putFile and removed once the upload is confirmed.
+func uploadMove(src, dst string, c *compressOptions, e *encryptOptions) error {
+	if err := uploadFile(src, dst, c, e); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// uploadFile is uploadMove without removing src, for the copy action.
+func uploadFile(src, dst string, c *compressOptions, e *encryptOptions) error {
+	st, err := storageFor(dst)
+	if err != nil {
+		return err
+	}
+	if err := st.Mkdir(dirLoc(dst)); err != nil {
+		return err
+	}
+	return putFile(st, src, dst, c, e)
+}
+
+// putFile uploads src to dst on st, compressed and encrypted like copyMove, and checks
+// that the stored object has the size that was sent. src is not removed.
+func putFile(st storage, src, dst string, c *compressOptions, e *encryptOptions) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		w, finish, err := transformWriter(pw, c, e)
+		if err == nil {
+			_, err = io.Copy(w, in)
+		}
+		if err == nil {
+			err = finish()
+		}
+		pw.CloseWithError(err)
+	}()
+	var modTime time.Time
+	if fi, err := in.Stat(); err == nil {
+		modTime = fi.ModTime()
+	}
+	n, err := st.Put(dst, pr, modTime)
+	pr.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+	fi, err := st.Stat(dst)
+	if err != nil {
+		return err
+	}
+	if fi.Size() != n {
+		return fmt.Errorf("%w: sent %d bytes, %s has %d", errChecksumMismatch, n, dst, fi.Size())
+	}
+	return nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// localStorage is the local filesystem. Moves between local paths keep using rename
+// (see moveFile); it serves retention and plan listing.
+type localStorage struct{}
+
+func (localStorage) Stat(loc string) (fs.FileInfo, error) { return os.Stat(loc) }
+func (localStorage) Mkdir(loc string) error               { return os.MkdirAll(loc, 0755) }
+func (localStorage) Rename(from, to string) error         { return os.Rename(from, to) }
+func (localStorage) Delete(loc string) error              { return os.Remove(loc) }
+
+func (localStorage) Open(loc string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(loc)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (localStorage) Put(loc string, r io.Reader, modTime time.Time) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(loc), "."+filepath.Base(loc)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !modTime.IsZero() {
+		err = os.Chtimes(tmp.Name(), modTime, modTime)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), loc)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	return n, nil
+}
+
+// List uses the default scan options: symbolic links are listed as themselves and never
+// followed, and special files are left out. See walkLocal.
+func (localStorage) List(root string, fn func(loc string, fi fs.FileInfo) error) error {
+	return walkLocal(root, nil, fn, nil)
+}
+
+// remoteFileInfo describes a remote object.
+type remoteFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+	special bool   // not a regular file or directory (symlink, device, ...)
+	md5     string // hex MD5 of the content, where the backend reports it
+}
+
+func (fi remoteFileInfo) Name() string       { return fi.name }
+func (fi remoteFileInfo) Size() int64        { return fi.size }
+func (fi remoteFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi remoteFileInfo) IsDir() bool        { return fi.dir }
+func (fi remoteFileInfo) Sys() any           { return nil }
+func (fi remoteFileInfo) Mode() fs.FileMode {
+	switch {
+	case fi.dir:
+		return fs.ModeDir | 0755
+	case fi.special:
+		return fs.ModeIrregular | 0644
+	}
+	return 0644
+}
+
+// remoteStorage marks every error of the remote backend st with errStorage, so failures
+// of S3, SFTP or WebDAV servers are logged as I/O errors rather than internal ones. The
+// errors keep their message and what they wrap, e.g. fs.ErrNotExist.
+type remoteStorage struct{ st storage }
+
+// storageError is err marked with errStorage.
+type storageError struct{ err error }
+
+func (e storageError) Error() string   { return e.err.Error() }
+func (e storageError) Unwrap() []error { return []error{errStorage, e.err} }
+
+func storageErr(err error) error {
+	if err == nil || err == io.EOF || errors.Is(err, errStorage) {
+		return err
+	}
+	return storageError{err}
+}
+
+func (r remoteStorage) Stat(loc string) (fs.FileInfo, error) {
+	fi, err := r.st.Stat(loc)
+	return fi, storageErr(err)
+}
+
+func (r remoteStorage) Mkdir(loc string) error { return storageErr(r.st.Mkdir(loc)) }
+
+func (r remoteStorage) Put(loc string, rd io.Reader, modTime time.Time) (int64, error) {
+	n, err := r.st.Put(loc, rd, modTime)
+	return n, storageErr(err)
+}
+
+func (r remoteStorage) Open(loc string, offset int64) (io.ReadCloser, error) {
+	rc, err := r.st.Open(loc, offset)
+	if err != nil {
+		return nil, storageErr(err)
+	}
+	return remoteReader{rc}, nil
+}
+
+func (r remoteStorage) Rename(from, to string) error { return storageErr(r.st.Rename(from, to)) }
+func (r remoteStorage) Delete(loc string) error      { return storageErr(r.st.Delete(loc)) }
+
+// List leaves the errors of fn as they are.
+func (r remoteStorage) List(root string, fn func(loc string, fi fs.FileInfo) error) error {
+	var fnErr error
+	err := r.st.List(root, func(loc string, fi fs.FileInfo) error {
+		fnErr = fn(loc, fi)
+		return fnErr
+	})
+	if err != nil && err == fnErr {
+		return err
+	}
+	return storageErr(err)
+}
+
+// remoteReader marks the errors of a download that fails part way.
+type remoteReader struct{ io.ReadCloser }
+
+func (r remoteReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	return n, storageErr(err)
+}
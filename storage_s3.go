@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Storage stores outputs in an S3 bucket: "s3://bucket/prefix". The endpoint is
+// AWS_ENDPOINT_URL (default s3.amazonaws.com) and credentials come from the usual AWS
+// or MinIO environment variables or ~/.aws/credentials.
+type s3Storage struct {
+	bucket string
+	c      *minio.Client
+}
+
+func newS3Storage(_, bucket string) (storage, error) {
+	endpoint, secure := "s3.amazonaws.com", true
+	if v := os.Getenv("AWS_ENDPOINT_URL"); v != "" {
+		scheme, host, ok := strings.Cut(v, "://")
+		if !ok {
+			scheme, host = "https", v
+		}
+		endpoint, secure = strings.TrimSuffix(host, "/"), scheme != "http"
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	c, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{}, &credentials.EnvMinio{}, &credentials.FileAWSCredentials{},
+		}),
+		Secure:       secure,
+		Region:       region,
+		BucketLookup: minio.BucketLookupAuto,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ok, err := c.BucketExists(context.Background(), bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("bucket %s does not exist", bucket)
+	}
+	return &s3Storage{bucket: bucket, c: c}, nil
+}
+
+// key returns the object key for loc, which has no leading slash.
+func (s *s3Storage) key(loc string) string {
+	_, _, p := splitLoc(loc)
+	return strings.TrimPrefix(p, "/")
+}
+
+// s3Err maps a missing object to fs.ErrNotExist.
+func s3Err(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return fmt.Errorf("%w: %v", fs.ErrNotExist, err)
+	}
+	return err
+}
+
+// Stat reports keys that only exist as a prefix of other keys as directories.
+func (s *s3Storage) Stat(loc string) (fs.FileInfo, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key := s.key(loc)
+	if key != "" {
+		info, err := s.c.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+		if err == nil {
+			return s3FileInfo(info), nil
+		}
+		if err = s3Err(err); !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	prefix := key
+	if prefix != "" {
+		prefix += "/"
+	}
+	for obj := range s.c.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, MaxKeys: 1}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		return remoteFileInfo{name: path.Base("/" + key), dir: true}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: loc, Err: fs.ErrNotExist}
+}
+
+// Mkdir does nothing: S3 has no directories.
+func (s *s3Storage) Mkdir(string) error { return nil }
+
+// Put keeps modTime in the object's "mtime" metadata (x-amz-meta-mtime, in Unix
+// seconds), which Stat and List report instead of the upload time.
+func (s *s3Storage) Put(loc string, r io.Reader, modTime time.Time) (int64, error) {
+	var opts minio.PutObjectOptions
+	if !modTime.IsZero() {
+		opts.UserMetadata = map[string]string{s3MtimeKey: strconv.FormatInt(modTime.Unix(), 10)}
+	}
+	info, err := s.c.PutObject(context.Background(), s.bucket, s.key(loc), r, -1, opts)
+	return info.Size, err
+}
+
+func (s *s3Storage) Open(loc string, offset int64) (io.ReadCloser, error) {
+	var opts minio.GetObjectOptions
+	if offset > 0 {
+		if err := opts.SetRange(offset, 0); err != nil {
+			return nil, err
+		}
+	}
+	obj, err := s.c.GetObject(context.Background(), s.bucket, s.key(loc), opts)
+	return obj, s3Err(err)
+}
+
+// Rename copies server-side, then removes the original.
+func (s *s3Storage) Rename(from, to string) error {
+	ctx := context.Background()
+	_, err := s.c.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: s.key(to)},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: s.key(from)})
+	if err != nil {
+		return s3Err(err)
+	}
+	return s.c.RemoveObject(ctx, s.bucket, s.key(from), minio.RemoveObjectOptions{})
+}
+
+func (s *s3Storage) Delete(loc string) error {
+	ctx := context.Background()
+	key := s.key(loc)
+	if _, err := s.c.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{}); err != nil {
+		return s3Err(err)
+	}
+	return s.c.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+// s3MtimeKey is the user metadata that holds the source's modification time of an
+// uploaded object.
+const s3MtimeKey = "mtime"
+
+// s3FileInfo describes an object. Its modification time is the kept one (see Put), or
+// else the upload time, truncated to seconds so they compare like local file times; the
+// ETag of a single-part upload is its MD5.
+func s3FileInfo(obj minio.ObjectInfo) remoteFileInfo {
+	fi := remoteFileInfo{name: path.Base(obj.Key), size: obj.Size, modTime: obj.LastModified.Truncate(time.Second)}
+	// StatObject strips the "X-Amz-Meta-" prefix from the keys; listings keep it.
+	for k, v := range obj.UserMetadata {
+		if strings.TrimPrefix(strings.ToLower(k), "x-amz-meta-") == s3MtimeKey {
+			if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+				fi.modTime = time.Unix(sec, 0)
+			}
+		}
+	}
+	if etag := strings.Trim(obj.ETag, `"`); len(etag) == 32 && !strings.Contains(etag, "-") {
+		fi.md5 = etag
+	}
+	return fi
+}
+
+func (s *s3Storage) List(root string, fn func(loc string, fi fs.FileInfo) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prefix := s.key(root)
+	if prefix != "" {
+		prefix += "/"
+	}
+	for obj := range s.c.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true, WithMetadata: true}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		if strings.HasSuffix(obj.Key, "/") {
+			continue // folder marker created by some clients
+		}
+		if obj.UserMetadata == nil {
+			// Only MinIO lists metadata; elsewhere it takes a HEAD request per object.
+			info, err := s.c.StatObject(ctx, s.bucket, obj.Key, minio.StatObjectOptions{})
+			if err != nil {
+				return s3Err(err)
+			}
+			obj.UserMetadata = info.UserMetadata
+		}
+		if err := fn("s3://"+s.bucket+"/"+obj.Key, s3FileInfo(obj)); err != nil {
+			if errors.Is(err, fs.SkipAll) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
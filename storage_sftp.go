@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpStorage stores outputs on an SSH server: "sftp://user@host:port/path". It
+// authenticates with the SSH agent, SLOTH_SFTP_KEY (or ~/.ssh/id_ed25519, id_ecdsa,
+// id_rsa) and SLOTH_SFTP_PASSWORD, and checks the host key against known_hosts. One
+// connection is shared by all workers writing to the same host.
+type sftpStorage struct {
+	prefix string
+	client *ssh.Client
+	c      *sftp.Client
+	closed atomic.Bool
+}
+
+func newSFTPStorage(scheme, host string) (storage, error) {
+	user, addr, hasUser := strings.Cut(host, "@")
+	if !hasUser {
+		user, addr = os.Getenv("USER"), host
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	auth, err := sshAuth()
+	if err != nil {
+		return nil, err
+	}
+	hostKeys, err := sshHostKeys()
+	if err != nil {
+		return nil, err
+	}
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	s := &sftpStorage{prefix: scheme + "://" + host, client: client, c: c}
+	go func() {
+		c.Wait()
+		s.closed.Store(true)
+		client.Close()
+	}()
+	return s, nil
+}
+
+// sshAuth collects the authentication methods that are configured, agent first.
+func sshAuth() ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if c, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(c).Signers))
+		}
+	}
+	keys, explicit := []string{os.Getenv("SLOTH_SFTP_KEY")}, true
+	if keys[0] == "" {
+		home, _ := os.UserHomeDir()
+		keys, explicit = nil, false
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			keys = append(keys, filepath.Join(home, ".ssh", name))
+		}
+	}
+	var signers []ssh.Signer
+	for _, file := range keys {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			var s ssh.Signer
+			if s, err = ssh.ParsePrivateKey(data); err == nil {
+				signers = append(signers, s)
+				continue
+			}
+		}
+		if explicit {
+			return nil, fmt.Errorf("SLOTH_SFTP_KEY: %w", err)
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if pass := os.Getenv("SLOTH_SFTP_PASSWORD"); pass != "" {
+		methods = append(methods, ssh.Password(pass))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no SSH agent, key or password available")
+	}
+	return methods, nil
+}
+
+// sshHostKeys verifies servers against SLOTH_SFTP_KNOWN_HOSTS or ~/.ssh/known_hosts.
+// Unknown hosts are refused.
+func sshHostKeys() (ssh.HostKeyCallback, error) {
+	file := os.Getenv("SLOTH_SFTP_KNOWN_HOSTS")
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	return knownhosts.New(file)
+}
+
+func (s *sftpStorage) alive() bool { return !s.closed.Load() }
+
+// sftpErr adds the operation and path to err, unless it has them; missing files wrap
+// fs.ErrNotExist.
+func sftpErr(op, p string, err error) error {
+	var status *sftp.StatusError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &status) && status.FxCode() == sftp.ErrSSHFxNoSuchFile:
+		return &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+	case errors.As(err, new(*fs.PathError)):
+		return err
+	}
+	return &fs.PathError{Op: op, Path: p, Err: err}
+}
+
+func (s *sftpStorage) Stat(loc string) (fs.FileInfo, error) {
+	_, _, p := splitLoc(loc)
+	fi, err := s.c.Stat(p)
+	return fi, sftpErr("stat", p, err)
+}
+
+func (s *sftpStorage) Mkdir(loc string) error {
+	_, _, p := splitLoc(loc)
+	return sftpErr("mkdir", p, s.c.MkdirAll(p))
+}
+
+// Put writes to a hidden temporary file and renames it into place.
+func (s *sftpStorage) Put(loc string, r io.Reader, modTime time.Time) (int64, error) {
+	_, _, p := splitLoc(loc)
+	tmp := path.Join(path.Dir(p), "."+path.Base(p)+".tmp")
+	f, err := s.c.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return 0, sftpErr("open", tmp, err)
+	}
+	n, err := f.ReadFrom(r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !modTime.IsZero() {
+		err = s.c.Chtimes(tmp, modTime, modTime)
+	}
+	if err == nil {
+		err = s.rename(tmp, p)
+	}
+	if err != nil {
+		s.c.Remove(tmp)
+		return 0, sftpErr("put", p, err)
+	}
+	return n, nil
+}
+
+func (s *sftpStorage) Open(loc string, offset int64) (io.ReadCloser, error) {
+	_, _, p := splitLoc(loc)
+	f, err := s.c.Open(p)
+	if err != nil {
+		return nil, sftpErr("open", p, err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *sftpStorage) Rename(from, to string) error {
+	_, _, f := splitLoc(from)
+	_, _, t := splitLoc(to)
+	return sftpErr("rename", f, s.rename(f, t))
+}
+
+// rename replaces to, atomically where the server supports posix-rename.
+func (s *sftpStorage) rename(from, to string) error {
+	if _, ok := s.c.HasExtension("posix-rename@openssh.com"); ok {
+		return s.c.PosixRename(from, to)
+	}
+	if err := s.c.Remove(to); err != nil && !errors.Is(sftpErr("remove", to, err), fs.ErrNotExist) {
+		return err
+	}
+	return s.c.Rename(from, to)
+}
+
+func (s *sftpStorage) Delete(loc string) error {
+	_, _, p := splitLoc(loc)
+	return sftpErr("remove", p, s.c.Remove(p))
+}
+
+func (s *sftpStorage) List(root string, fn func(loc string, fi fs.FileInfo) error) error {
+	_, _, p := splitLoc(root)
+	err := s.list(p, fn)
+	if errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+func (s *sftpStorage) list(dir string, fn func(loc string, fi fs.FileInfo) error) error {
+	entries, err := s.c.ReadDir(dir)
+	if err != nil {
+		return sftpErr("readdir", dir, err)
+	}
+	for _, fi := range entries {
+		p := path.Join(dir, fi.Name())
+		if fi.IsDir() {
+			err = s.list(p, fn)
+		} else if fi.Mode().IsRegular() {
+			err = fn(s.prefix+p, fi)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
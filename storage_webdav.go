@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// webdavStorage stores outputs on a WebDAV server: "webdav://user@host/path" over HTTP,
+// "webdavs://" over HTTPS. The password comes from SLOTH_WEBDAV_PASSWORD.
+type webdavStorage struct {
+	prefix string // scheme://host, prepended to listed paths
+	c      *gowebdav.Client
+}
+
+func newWebDAVStorage(scheme, host string) (storage, error) {
+	user, addr, hasUser := strings.Cut(host, "@")
+	if !hasUser {
+		user, addr = "", host
+	}
+	pass := os.Getenv("SLOTH_WEBDAV_PASSWORD")
+	proto := "http"
+	if scheme == "webdavs" {
+		proto = "https"
+	}
+	c := gowebdav.NewClient(proto+"://"+addr, user, pass)
+	c.SetTimeout(5 * time.Minute)
+	if err := c.Connect(); err != nil {
+		return nil, err
+	}
+	return &webdavStorage{prefix: scheme + "://" + host, c: c}, nil
+}
+
+// davErr maps a missing resource to fs.ErrNotExist.
+func davErr(err error) error {
+	if gowebdav.IsErrNotFound(err) {
+		return fmt.Errorf("%w: %v", fs.ErrNotExist, err)
+	}
+	return err
+}
+
+func (s *webdavStorage) Stat(loc string) (fs.FileInfo, error) {
+	_, _, p := splitLoc(loc)
+	fi, err := s.c.Stat(p)
+	return fi, davErr(err)
+}
+
+func (s *webdavStorage) Mkdir(loc string) error {
+	_, _, p := splitLoc(loc)
+	return s.c.MkdirAll(p, 0755)
+}
+
+// Put uploads to a hidden temporary name and moves it into place, so readers never see
+// a partial file.
+// Put cannot keep modTime: WebDAV servers set the modification time themselves.
+func (s *webdavStorage) Put(loc string, r io.Reader, _ time.Time) (int64, error) {
+	_, _, p := splitLoc(loc)
+	tmp := path.Join(path.Dir(p), "."+path.Base(p)+".tmp")
+	cr := &countingReader{r: r}
+	if err := s.c.WriteStream(tmp, cr, 0644); err != nil {
+		return 0, err
+	}
+	if err := s.c.Rename(tmp, p, true); err != nil {
+		s.c.Remove(tmp)
+		return 0, err
+	}
+	return cr.n, nil
+}
+
+// Open uses a range request to resume. Servers that ignore ranges send the whole file,
+// which gowebdav skips forward; it needs the length for that.
+func (s *webdavStorage) Open(loc string, offset int64) (io.ReadCloser, error) {
+	_, _, p := splitLoc(loc)
+	if offset == 0 {
+		rc, err := s.c.ReadStream(p)
+		return rc, davErr(err)
+	}
+	fi, err := s.c.Stat(p)
+	if err != nil {
+		return nil, davErr(err)
+	}
+	rc, err := s.c.ReadStreamRange(p, offset, fi.Size()-offset)
+	return rc, davErr(err)
+}
+
+func (s *webdavStorage) Rename(from, to string) error {
+	_, _, f := splitLoc(from)
+	_, _, t := splitLoc(to)
+	return davErr(s.c.Rename(f, t, true))
+}
+
+func (s *webdavStorage) Delete(loc string) error {
+	_, _, p := splitLoc(loc)
+	if _, err := s.c.Stat(p); err != nil {
+		return davErr(err)
+	}
+	return s.c.Remove(p)
+}
+
+func (s *webdavStorage) List(root string, fn func(loc string, fi fs.FileInfo) error) error {
+	_, _, p := splitLoc(root)
+	err := s.list(p, fn)
+	if err == fs.SkipAll {
+		return nil
+	}
+	return davErr(err)
+}
+
+func (s *webdavStorage) list(dir string, fn func(loc string, fi fs.FileInfo) error) error {
+	entries, err := s.c.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range entries {
+		p := path.Join(dir, fi.Name())
+		if fi.IsDir() {
+			err = s.list(p, fn)
+		} else {
+			err = fn(s.prefix+p, fi)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// manifestName is the checksum manifest kept in each output folder of a verified rule.
+// Its lines use the sha256sum format ("<hex>  <name>"), so `sha256sum -c` can check it too.
+const manifestName = "SHA256SUMS"
+
+// manifestMu serializes appends from the workers to manifest files.
+var manifestMu sync.Mutex
+
+// sha256File returns the hex SHA-256 of the file at path.
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyMove checks that out has the content hashed from in before the move and records
+// it in the manifest of out's folder. On a mismatch the file is moved back to in.
+func verifyMove(in, out, want string) error {
+	got, err := sha256File(out)
+	if err == nil && got != want {
+		err = fmt.Errorf("%w: source %s, destination %s", errChecksumMismatch, want, got)
+	}
+	if err != nil {
+		if rbErr := os.Rename(out, in); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %w)", err, rbErr)
+		}
+		return fmt.Errorf("%w (moved back to source)", err)
+	}
+	return appendManifest(filepath.Dir(out), filepath.Base(out), got)
+}
+
+func appendManifest(dir, name, sum string) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(dir, manifestName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s  %s\n", sum, name); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// removeFromManifest drops the entry of path, which retention deleted, from the manifest
+// of its folder, so verify does not report it missing. The manifest is rewritten
+// atomically, and removed once it lists nothing.
+func removeFromManifest(path string) error {
+	manifest := filepath.Join(filepath.Dir(path), manifestName)
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	data, err := os.ReadFile(manifest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var kept strings.Builder
+	removed := false
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if _, name, ok := strings.Cut(strings.TrimSuffix(line, "\n"), "  "); ok && name == filepath.Base(path) {
+			removed = true
+			continue
+		}
+		kept.WriteString(line)
+	}
+	switch {
+	case !removed:
+		return nil
+	case kept.Len() == 0:
+		return os.Remove(manifest)
+	}
+	tmp := manifest + ".tmp"
+	if err := os.WriteFile(tmp, []byte(kept.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, manifest)
+}
+
+// readManifest returns the checksum of each file in a manifest. A file listed more than
+// once (moved again after being replaced) keeps its last checksum.
+func readManifest(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sums := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		sum, name, ok := strings.Cut(sc.Text(), "  ")
+		if !ok || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("%s:%d: malformed manifest line", path, n)
+		}
+		sums[name] = sum
+	}
+	return sums, sc.Err()
+}
+
+// manifestResult is the outcome of checking one manifest entry.
+type manifestResult struct {
+	Path   string
+	Status string // "OK", "FAILED" or "MISSING"
+}
+
+// verifyTree checks every manifest found under root.
+func verifyTree(root string) (results []manifestResult, manifests int, err error) {
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != manifestName {
+			return nil
+		}
+		manifests++
+		sums, err := readManifest(path)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(sums))
+		for name := range sums {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			file := filepath.Join(filepath.Dir(path), name)
+			got, err := sha256File(file)
+			status := "OK"
+			switch {
+			case os.IsNotExist(err):
+				status = "MISSING"
+			case err != nil || got != sums[name]:
+				status = "FAILED"
+			}
+			results = append(results, manifestResult{Path: file, Status: status})
+		}
+		return nil
+	})
+	return results, manifests, err
+}
+
+// cmdVerify rechecks the manifests under each directory and prints failures
+// (every entry with --verbose).
+func cmdVerify(opts *cliOptions, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sloth verify <dir>...")
+		return exitConfig
+	}
+	code := exitOK
+	for _, dir := range args {
+		results, manifests, err := verifyTree(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", dir, err)
+			code = exitFailures
+			continue
+		}
+		if manifests == 0 {
+			fmt.Fprintf(os.Stderr, "%s: no %s manifest found\n", dir, manifestName)
+			code = exitFailures
+			continue
+		}
+		bad := 0
+		for _, r := range results {
+			if r.Status != "OK" {
+				bad++
+			}
+			if r.Status != "OK" || opts.log.Verbose {
+				fmt.Printf("%s: %s\n", r.Path, r.Status)
+			}
+		}
+		fmt.Printf("%s: %d file(s) in %d manifest(s), %d failed\n", dir, len(results), manifests, bad)
+		if bad > 0 {
+			code = exitFailures
+		}
+	}
+	return code
+}
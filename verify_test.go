@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifiedMoveAndManifest(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a.csv", "b.csv"} {
+		if err := os.WriteFile(filepath.Join(in, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := &folder{Name: "Ledger", Input: in, Output: []string{out}, FolderType: "4", Verify: true}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true}).WithRule(f.Name)
+	for _, name := range []string{"a.csv", "b.csv"} {
+		moveFile(al, &Balancer{}, f, defaultDirSettings, name, nil, false)
+	}
+	if n := al.counters.errorsCount.Load(); n != 0 {
+		t.Fatalf("%d errors during verified moves", n)
+	}
+
+	results, manifests, err := verifyTree(dir)
+	if err != nil || manifests != 1 || len(results) != 2 || results[0].Status != "OK" || results[1].Status != "OK" {
+		t.Fatalf("verifyTree = %+v, %d, %v", results, manifests, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(out, "a.csv"), []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(out, "b.csv")); err != nil {
+		t.Fatal(err)
+	}
+	results, _, _ = verifyTree(dir)
+	if results[0].Status != "FAILED" || results[1].Status != "MISSING" {
+		t.Errorf("after tampering: %+v", results)
+	}
+}
+
+func TestApplyVerifiedPlan(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a.csv", "b.csv"} {
+		if err := os.WriteFile(filepath.Join(in, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules := []folder{
+		{Name: "Ledger", Input: in, Output: []string{out}, Extension: ".csv", FolderType: "4", Verify: true},
+	}
+	plan := buildPlan(rules)
+	for _, op := range plan.Rules[0].Ops {
+		if op.Op == "move" && !op.Verify {
+			t.Errorf("planned move of %s is not verified", op.Src)
+		}
+	}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	if stale := applyPlan(al, plan, false); len(stale) != 0 {
+		t.Fatalf("stale entries: %+v", stale)
+	}
+	if n := al.counters.errorsCount.Load(); n != 0 {
+		t.Fatalf("%d errors", n)
+	}
+	results, manifests, err := verifyTree(out)
+	if err != nil || manifests != 1 || len(results) != 2 || results[0].Status != "OK" || results[1].Status != "OK" {
+		t.Errorf("verifyTree = %+v, %d, %v", results, manifests, err)
+	}
+}
+
+func TestRetentionUpdatesManifest(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{in, out} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().AddDate(0, 0, -40)
+	writeAged(t, in, "a.csv", "a", old)
+	writeAged(t, in, "b.csv", "b", time.Now())
+
+	f := &folder{Name: "Ledger", Input: in, Output: []string{out}, FolderType: "4", Verify: true}
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true}).WithRule(f.Name)
+	for _, name := range []string{"a.csv", "b.csv"} {
+		moveFile(al, &Balancer{}, f, defaultDirSettings, name, nil, false)
+	}
+	deleteFiles(out, ".csv", false, 30, nil, al, false)
+	if n := al.counters.errorsCount.Load(); n != 0 {
+		t.Fatalf("%d errors", n)
+	}
+	results, manifests, err := verifyTree(out)
+	if err != nil || manifests != 1 || len(results) != 1 || results[0].Status != "OK" || filepath.Base(results[0].Path) != "b.csv" {
+		t.Errorf("after retention: verifyTree = %+v, %d, %v", results, manifests, err)
+	}
+
+	// Once the last listed file is deleted, the manifest goes too.
+	if err := os.Chtimes(filepath.Join(out, "b.csv"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	deleteFiles(out, ".csv", false, 30, nil, al, false)
+	if _, err := os.Stat(filepath.Join(out, manifestName)); !os.IsNotExist(err) {
+		t.Errorf("empty manifest left behind: %v", err)
+	}
+}
+
+func TestVerifyMoveRollsBack(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.txt")
+	dst := filepath.Join(dir, "dst.txt")
+	if err := os.WriteFile(dst, []byte("landed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := verifyMove(src, dst, "0000000000000000000000000000000000000000000000000000000000000000")
+	if !errors.Is(err, errChecksumMismatch) || classifyError(err) != kindIO {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("file not moved back: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, manifestName)); !os.IsNotExist(err) {
+		t.Error("manifest written for a failed verification")
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// configPollInterval is how often --watch mode checks the rules file for changes.
+const configPollInterval = 2 * time.Second
+
+// configStamp identifies one version of the rules file on disk.
+type configStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statConfig(path string) configStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return configStamp{}
+	}
+	return configStamp{modTime: fi.ModTime(), size: fi.Size()}
+}
+
+// ruleRunner executes a single rule every interval until it is stopped.
+type ruleRunner struct {
+	rule   folder
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// stop asks the runner to exit after its current pass and waits for it.
+func (r *ruleRunner) stop() {
+	r.cancel()
+	<-r.done
+}
+
+// watcher owns the running rules in --watch mode and swaps them on config reloads.
+type watcher struct {
+	logger   *AppLogger
+	balancer *Balancer
+	path     string
+	interval time.Duration
+	selector ruleSelector
+	info     *runInfo       // gets the hash of each config version applied; may be nil
+	exit     func(code int) // os.Exit; replaced in tests
+
+	mu      sync.Mutex
+	runners map[string]*ruleRunner
+	stamp   configStamp
+	retired sync.WaitGroup // removed rules still finishing their last pass
+}
+
+func newWatcher(appLogger *AppLogger, balancer *Balancer, path string, interval time.Duration) *watcher {
+	return &watcher{
+		logger:   appLogger,
+		balancer: balancer,
+		path:     path,
+		interval: interval,
+		exit:     os.Exit,
+		runners:  make(map[string]*ruleRunner),
+	}
+}
+
+// runWatch keeps rules running until SIGINT/SIGTERM, then calls finish. The config is
+// reloaded on SIGHUP and whenever the rules file changes on disk. It only returns an
+// error if the initial config cannot be loaded.
+func runWatch(appLogger *AppLogger, balancer *Balancer, run *runInfo, interval time.Duration, sel ruleSelector, finish func()) error {
+	w := newWatcher(appLogger, balancer, configPath, interval)
+	w.selector = sel
+	w.info = run
+	if err := w.reload(); err != nil {
+		return fmt.Errorf("initial config load failed: %w", err)
+	}
+	appLogger.Info("Watch mode started (interval=%s, config=%s)", interval, configPath)
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGHUP, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	w.run(sigs, finish)
+	return nil
+}
+
+// run handles signals and config changes until SIGINT or SIGTERM, which interrupt the
+// in-flight passes like a single run (exit code 3). A second SIGINT or SIGTERM while they
+// finish exits at once. Rejected reloads are warnings: the current rules keep running.
+func (w *watcher) run(sigs <-chan os.Signal, finish func()) {
+	poll := time.NewTicker(configPollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case sig := <-sigs:
+			if sig != syscall.SIGHUP {
+				w.logger.Info("Received %v, waiting for in-flight rules to finish (send again to exit now)", sig)
+				w.logger.Interrupt()
+				stopped := make(chan struct{})
+				go w.exitOnSignal(sigs, stopped)
+				w.stopAll()
+				close(stopped)
+				finish()
+				return
+			}
+			w.logger.Info("Received SIGHUP, reloading %s", w.path)
+			if err := w.reload(); err != nil {
+				w.logger.Warn("config reload rejected, keeping current rules: %v", err)
+			}
+		case <-poll.C:
+			if !w.changed() {
+				continue
+			}
+			w.logger.Info("Detected change to %s, reloading", w.path)
+			if err := w.reload(); err != nil {
+				w.logger.Warn("config reload rejected, keeping current rules: %v", err)
+			}
+		}
+	}
+}
+
+// exitOnSignal exits with exitInterrupted on SIGINT or SIGTERM until stopped is closed.
+func (w *watcher) exitOnSignal(sigs <-chan os.Signal, stopped <-chan struct{}) {
+	for {
+		select {
+		case sig := <-sigs:
+			if sig != syscall.SIGHUP {
+				w.exit(exitInterrupted)
+				return
+			}
+		case <-stopped:
+			return
+		}
+	}
+}
+
+// changed reports whether the rules file differs from the last version loaded.
+func (w *watcher) changed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return statConfig(w.path) != w.stamp
+}
+
+// reload loads and validates the rules file and, only if it is valid, applies it.
+func (w *watcher) reload() error {
+	folders, sum, err := loadFolders(w.path, w.logger)
+
+	// Stamp after loading: migration may have rewritten the file, and a rejected
+	// version should not be retried until it changes again.
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stamp = statConfig(w.path)
+
+	if err != nil {
+		return err
+	}
+	if err := validateFolders(folders); err != nil {
+		return err
+	}
+	if folders, err = w.selector.apply(folders); err != nil {
+		return err
+	}
+	w.apply(folders)
+	w.logger.SetOutputs(ruleOutputs(folders))
+	if w.info != nil {
+		w.info.ConfigHash = sum
+	}
+	return nil
+}
+
+// apply diffs folders against the running rules. Unchanged rules are left alone; removed
+// rules finish their current pass and stop; changed rules are restarted once the old
+// version has finished. Callers must hold w.mu.
+func (w *watcher) apply(folders []folder) {
+	next := make(map[string]folder, len(folders))
+	for i := range folders {
+		next[folders[i].Name] = folders[i]
+	}
+
+	for name, r := range w.runners {
+		f, ok := next[name]
+		switch {
+		case !ok:
+			w.logger.WithRule(name).Info("Removed from config, stopping after current pass")
+			delete(w.runners, name)
+			w.retired.Add(1)
+			go func(r *ruleRunner) {
+				defer w.retired.Done()
+				r.stop()
+			}(r)
+		case !reflect.DeepEqual(f, r.rule):
+			w.logger.WithRule(name).Info("Changed in config, restarting after current pass")
+			w.runners[name] = w.start(f, r)
+		}
+	}
+
+	for name, f := range next {
+		if _, ok := w.runners[name]; !ok {
+			w.logger.WithRule(name).Info("Added to config")
+			w.runners[name] = w.start(f, nil)
+		}
+	}
+}
+
+// start launches a runner for f. If prev is non-nil the new runner waits for it to stop
+// first, so two versions of the same rule never process the same input concurrently.
+func (w *watcher) start(f folder, prev *ruleRunner) *ruleRunner {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &ruleRunner{rule: f, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		if prev != nil {
+			prev.stop()
+		}
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+			processFolder(w.logger, w.balancer, &r.rule)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(w.interval):
+			}
+		}
+	}()
+	return r
+}
+
+// stopAll stops every runner and waits for in-flight passes to complete.
+func (w *watcher) stopAll() {
+	w.mu.Lock()
+	runners := w.runners
+	w.runners = make(map[string]*ruleRunner)
+	w.mu.Unlock()
+
+	for _, r := range runners {
+		r.stop()
+	}
+	w.retired.Wait()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func writeRules(t *testing.T, path string, rules []folder) {
+	t.Helper()
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+}
+
+// TestWatcherReload verifies that reloads only restart added/changed rules and that an
+// invalid config is rejected without touching the running set.
+func TestWatcherReload(t *testing.T) {
+	base := t.TempDir()
+	inputDir := filepath.Join(base, "input")
+	outDir := filepath.Join(base, "out")
+	for _, d := range []string{inputDir, outDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	configFile := filepath.Join(base, "config.json")
+
+	rule := func(name, ext string) folder {
+		return folder{Name: name, Input: inputDir, Output: []string{outDir}, Extension: ext, FolderType: "4", DryRun: true}
+	}
+
+	origWD, _ := os.Getwd()
+	defer func() { _ = os.Chdir(origWD) }()
+	if err := os.Chdir(base); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+
+	w := newWatcher(NewAppLogger(true), &Balancer{}, configFile, time.Hour)
+	w.info = &runInfo{}
+	defer w.stopAll()
+
+	writeRules(t, configFile, []folder{rule("keep", ".txt"), rule("change", ".log"), rule("drop", ".csv")})
+	if err := w.reload(); err != nil {
+		t.Fatalf("initial reload: %v", err)
+	}
+	kept := w.runners["keep"]
+	changed := w.runners["change"]
+
+	writeRules(t, configFile, []folder{rule("keep", ".txt"), rule("change", ".pdf"), rule("add", ".csv")})
+	if err := w.reload(); err != nil {
+		t.Fatalf("second reload: %v", err)
+	}
+	applied := hashFile(configFile)
+	if w.info.ConfigHash != applied {
+		t.Errorf("run config hash = %q, want that of the reloaded file", w.info.ConfigHash)
+	}
+
+	if w.runners["keep"] != kept {
+		t.Errorf("unchanged rule was restarted")
+	}
+	if w.runners["change"] == changed || w.runners["change"].rule.Extension != ".pdf" {
+		t.Errorf("changed rule was not swapped in")
+	}
+	if _, ok := w.runners["drop"]; ok {
+		t.Errorf("removed rule still running")
+	}
+	if _, ok := w.runners["add"]; !ok {
+		t.Errorf("added rule not started")
+	}
+
+	// Duplicate names are invalid; the running set must be left untouched.
+	writeRules(t, configFile, []folder{rule("keep", ".txt"), rule("keep", ".log")})
+	if err := w.reload(); err == nil {
+		t.Fatalf("expected invalid config to be rejected")
+	}
+	if len(w.runners) != 3 || w.runners["keep"] != kept {
+		t.Errorf("rejected reload modified running rules: %v", w.runners)
+	}
+	if w.info.ConfigHash != applied {
+		t.Errorf("rejected reload changed the run config hash")
+	}
+	if w.changed() {
+		t.Errorf("rejected config should not be reported as changed until edited again")
+	}
+}
+
+// TestWatcherSignals checks that a rejected reload is only a warning, and that SIGINT
+// stops the rules like an interrupted run, with exit code 3.
+func TestWatcherSignals(t *testing.T) {
+	base := t.TempDir()
+	inputDir := filepath.Join(base, "input")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	configFile := filepath.Join(base, "config.json")
+	rule := folder{Name: "keep", Input: inputDir, Output: []string{filepath.Join(base, "out")}, Extension: ".txt", FolderType: "4", DryRun: true}
+	writeRules(t, configFile, []folder{rule})
+
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	w := newWatcher(al, &Balancer{}, configFile, time.Hour)
+	if err := w.reload(); err != nil {
+		t.Fatalf("initial reload: %v", err)
+	}
+
+	sigs := make(chan os.Signal)
+	finished := make(chan struct{})
+	go w.run(sigs, func() { close(finished) })
+	writeRules(t, configFile, []folder{rule, rule})
+	sigs <- syscall.SIGHUP
+	sigs <- os.Interrupt
+	<-finished
+
+	if n := al.counters.errorsCount.Load(); n != 0 {
+		t.Errorf("rejected reload counted %d errors", n)
+	}
+	if !al.Interrupted() {
+		t.Error("SIGINT did not interrupt the rules")
+	}
+	if got := al.ExitCode(false); got != exitInterrupted {
+		t.Errorf("ExitCode() = %d, want %d", got, exitInterrupted)
+	}
+}
+
+// TestWatcherSecondSignal checks that a second SIGINT or SIGTERM exits at once while the
+// rules are still finishing, like in a single run.
+func TestWatcherSecondSignal(t *testing.T) {
+	al := newTestLogger(t, &bytes.Buffer{}, logConfig{Quiet: true})
+	w := newWatcher(al, &Balancer{}, filepath.Join(t.TempDir(), "config.json"), time.Hour)
+	exited := make(chan int, 1)
+	w.exit = func(code int) { exited <- code }
+	w.retired.Add(1) // a removed rule still in its last pass keeps stopAll waiting
+
+	sigs := make(chan os.Signal)
+	finished := make(chan struct{})
+	go w.run(sigs, func() { close(finished) })
+	sigs <- syscall.SIGTERM
+	sigs <- syscall.SIGTERM
+	select {
+	case code := <-exited:
+		if code != exitInterrupted {
+			t.Errorf("exit code = %d, want %d", code, exitInterrupted)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second signal did not exit")
+	}
+	w.retired.Done()
+	<-finished
+}
+
+func TestValidateFolders(t *testing.T) {
+	tests := []struct {
+		name    string
+		folders []folder
+		wantErr bool
+	}{
+		{"valid move", []folder{{Name: "a", Input: "/in", Output: []string{"/out"}, FolderType: "1"}}, false},
+		{"valid delete", []folder{{Name: "a", Input: "/in", FolderType: "delete", DeleteOlderThan: 5}}, false},
+		{"missing name", []folder{{Input: "/in", Output: []string{"/out"}, FolderType: "1"}}, true},
+		{"missing output", []folder{{Name: "a", Input: "/in", FolderType: "1"}}, true},
+		{"unknown type", []folder{{Name: "a", Input: "/in", Output: []string{"/out"}, FolderType: "9"}}, true},
+		{"delete without age", []folder{{Name: "a", Input: "/in", FolderType: "delete"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateFolders(tt.folders)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateFolders() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}